@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/fmstephe/flib/fstrconv"
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/msg"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type runMode int
+
+const (
+	STEP = runMode(iota) // Pause after every record
+	EXEC = runMode(iota) // Run until the target line, then step
+	RUN  = runMode(iota) // Run until a breakpoint or the end of the capture
+)
+
+func (m runMode) String() string {
+	switch m {
+	case STEP:
+		return "STEP"
+	case EXEC:
+		return "EXEC"
+	case RUN:
+		return "RUN"
+	}
+	panic("Bad Value")
+}
+
+type breakpoints struct {
+	lines   map[uint64]bool
+	stocks  map[uint64]bool
+	traders map[uint64]bool
+}
+
+func newBreakpoints() *breakpoints {
+	return &breakpoints{lines: make(map[uint64]bool), stocks: make(map[uint64]bool), traders: make(map[uint64]bool)}
+}
+
+// Adds a breakpoint on a 'line', 'stock' or 'trader'
+func (b *breakpoints) add(on string, id uint64) error {
+	switch on {
+	case "line":
+		b.lines[id] = true
+	case "stock":
+		b.stocks[id] = true
+	case "trader":
+		b.traders[id] = true
+	default:
+		return errors.New(fmt.Sprintf("Can't break on %q, 'line', 'stock' and 'trader' supported", on))
+	}
+	return nil
+}
+
+// Describes the first breakpoint r hits, or returns "" if it hits none. prev
+// is the line of the record before r. A breakpoint on a line which was
+// skipped, or held no record, is hit by the next record after it.
+func (b *breakpoints) match(r *record, prev uint) string {
+	if b.lines[uint64(r.line)] {
+		return fmt.Sprintf("line %d", r.line)
+	}
+	for l := range b.lines {
+		if l > uint64(prev) && l < uint64(r.line) {
+			return fmt.Sprintf("line %d, at the next record", l)
+		}
+	}
+	for i := range r.msgs {
+		m := &r.msgs[i]
+		if b.stocks[m.StockId] {
+			return fmt.Sprintf("stock %d", m.StockId)
+		}
+		if b.traders[uint64(m.TraderId)] {
+			return fmt.Sprintf("trader %d", m.TraderId)
+		}
+	}
+	return ""
+}
+
+// Collects the depth messages for the limits touched by each record
+type touchWriter struct {
+	ms []msg.Message
+}
+
+func (w *touchWriter) Write(m msg.Message) {
+	w.ms = append(w.ms, m)
+}
+
+func (w *touchWriter) touched(kind msg.MsgKind, stockId, price uint64) bool {
+	for _, m := range w.ms {
+		if m.Kind == kind && m.StockId == stockId && m.Price == price {
+			return true
+		}
+	}
+	return false
+}
+
+// Submits each record of an ITCH capture to a matcher, pausing to show the
+// matcher's output and the book around the touch.
+type debugger struct {
+	src        source
+	m          *matcher.M
+	touches    *touchWriter
+	in         *bufio.Reader
+	out        io.Writer
+	mode       runMode
+	target     uint
+	breaks     *breakpoints
+	levels     int
+	line       uint // The line of the last record read
+	records    uint64
+	executions uint64
+	mismatches uint64
+	invalid    uint64
+}
+
+func newDebugger(src source, in io.Reader, out io.Writer, levels int) *debugger {
+	touches := &touchWriter{}
+	m := matcher.NewMatcher(1024)
+	m.Config("ITCH Debug", coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+	m.SetDepth(touches)
+	return &debugger{src: src, m: m, touches: touches, in: bufio.NewReader(in), out: out, breaks: newBreakpoints(), levels: levels}
+}
+
+// Runs until the capture ends or the user quits
+func (d *debugger) run() error {
+	defer d.summary()
+	for {
+		r, err := d.src.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		outs := d.submit(r)
+		hit := d.breaks.match(r, d.line)
+		d.line = r.line
+		if hit != "" {
+			fmt.Fprintf(d.out, "Breakpoint on %s\n", hit)
+			d.mode = STEP
+		}
+		if d.mode == EXEC && r.line >= d.target {
+			d.mode = STEP
+		}
+		if d.mode == STEP {
+			d.print(r, outs)
+			if d.prompt() {
+				return nil
+			}
+		}
+	}
+}
+
+// Invalid messages, such as a buy at the market price, are reported and not submitted
+func (d *debugger) submit(r *record) []msg.Message {
+	d.records++
+	d.touches.ms = d.touches.ms[:0]
+	valid := r.msgs[:0]
+	for i := range r.msgs {
+		if r.msgs[i].Valid() {
+			valid = append(valid, r.msgs[i])
+			continue
+		}
+		d.invalid++
+		fmt.Fprintf(d.out, "Line %d: invalid %v not submitted\n", r.line, &r.msgs[i])
+	}
+	r.msgs = valid
+	outs := d.m.SubmitBatch(r.msgs, nil)
+	for i := range outs {
+		o := &outs[i]
+		switch o.Kind {
+		case msg.FULL, msg.PARTIAL:
+			d.executions++
+		case msg.NOT_CANCELLED, msg.REJECTED, msg.CANCELLED:
+			// An execution's aggressor is cancelled after it, and should be found filled.
+			// Any other cancel should find its order.
+			if o.Kind != msg.REJECTED && (o.Kind == msg.NOT_CANCELLED) == r.cancelsOwnOrder(o) {
+				continue
+			}
+			// The matcher's book disagrees with the capture, always worth knowing
+			d.mismatches++
+			if d.mode != STEP {
+				fmt.Fprintf(d.out, "Line %d: %v\n", r.line, o)
+			}
+		}
+	}
+	return outs
+}
+
+func (d *debugger) print(r *record, outs []msg.Message) {
+	fmt.Fprintf(d.out, "Line %d: %s\n", r.line, r.text)
+	stocks := make([]uint64, 0, 1)
+	for i := range r.msgs {
+		fmt.Fprintf(d.out, "  in   %v\n", &r.msgs[i])
+		if len(stocks) == 0 || stocks[len(stocks)-1] != r.msgs[i].StockId {
+			stocks = append(stocks, r.msgs[i].StockId)
+		}
+	}
+	for i := range outs {
+		fmt.Fprintf(d.out, "  out  %v\n", &outs[i])
+	}
+	for _, stockId := range stocks {
+		d.printBook(stockId)
+	}
+}
+
+// Prints the best limits on each side, sells above buys. Touched limits are marked with a *
+func (d *debugger) printBook(stockId uint64) {
+	name := strconv.FormatUint(stockId, 10)
+	if s := d.src.symbol(stockId); s != "" {
+		name += " (" + s + ")"
+	}
+	fmt.Fprintf(d.out, "  Stock %s\n", name)
+	buys, sells := d.m.Survey(stockId, d.levels)
+	for i := len(sells) - 1; i >= 0; i-- {
+		d.printLimit("sell", msg.SELL_DEPTH, stockId, &sells[i])
+	}
+	fmt.Fprintln(d.out, "    ----")
+	for i := range buys {
+		d.printLimit("buy ", msg.BUY_DEPTH, stockId, &buys[i])
+	}
+}
+
+func (d *debugger) printLimit(side string, kind msg.MsgKind, stockId uint64, l *msg.SurveyLimit) {
+	mark := " "
+	if d.touches.touched(kind, stockId, l.Price) {
+		mark = "*"
+	}
+	price := fstrconv.ItoaDelim(int64(l.Price), ',')
+	size := fstrconv.ItoaDelim(int64(l.Size), ',')
+	fmt.Fprintf(d.out, "  %s %s %12s %12s %6d\n", mark, side, price, size, l.Orders)
+}
+
+const help = `  s, step            Submit the next record
+  e, exec <line>     Run until <line>, then step
+  r, run             Run until a breakpoint or the end of the capture
+  b, break <on> <id> Break on a 'line', 'stock' or 'trader'
+  q, quit            Stop debugging
+`
+
+// Reads commands until one moves the debugger on. Returns true if the user has quit.
+func (d *debugger) prompt() bool {
+	for {
+		fmt.Fprint(d.out, "> ")
+		line, err := d.in.ReadString('\n')
+		if err != nil && line == "" {
+			return true
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return false
+		}
+		switch fields[0] {
+		case "s", "step":
+			return false
+		case "r", "run":
+			d.mode = RUN
+			return false
+		case "e", "exec":
+			if len(fields) == 2 {
+				if target, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+					d.mode = EXEC
+					d.target = uint(target)
+					return false
+				}
+			}
+			fmt.Fprintln(d.out, "Usage: exec <line>")
+		case "b", "break":
+			if len(fields) == 3 {
+				id, err := strconv.ParseUint(fields[2], 10, 64)
+				if err == nil {
+					err = d.breaks.add(fields[1], id)
+				}
+				if err != nil {
+					fmt.Fprintln(d.out, err.Error())
+				}
+				continue
+			}
+			fmt.Fprintln(d.out, "Usage: break <on> <id>")
+		case "q", "quit":
+			return true
+		default:
+			fmt.Fprint(d.out, help)
+		}
+	}
+}
+
+func (d *debugger) summary() {
+	fmt.Fprintf(d.out, "Records %d, executions %d, mismatches %d, invalid %d\n", d.records, d.executions, d.mismatches, d.invalid)
+}
@@ -1,134 +1,88 @@
+// Steps through an ITCH capture, submitting each record to a matcher.
+//
+// After each record the matcher's output is printed along with the best
+// limits of the book it changed, with the limits it touched marked. Used to
+// find where a replay first disagrees with the exchange's data.
 package main
 
-func main() {
-	println("itchdebug not supported at this time.")
-}
-
-/*
-
 import (
-	"bufio"
-	"bytes"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/fmstephe/fstrconv"
-	"github.com/fmstephe/matching_engine/matcher"
-	"github.com/fmstephe/matching_engine/msg"
-	"github.com/fmstephe/matching_engine/itch"
+	"io"
 	"os"
+	"strconv"
+	"strings"
 )
 
 var (
-	filePath = flag.String("f", "", "Relative path to an ITCH file to read")
-	mode     = flag.String("m", "step", "Running mode. Currently supporting 'step', 'exec' and 'list'")
-	line     = flag.Uint("l", 0, "First line to break on. Mode is ignored until line l is reached, then normal excution continues")
+	filePath     = flag.String("f", "", "Path to an ITCH capture to read")
+	binaryFile   = flag.Bool("b", false, "The capture is an ITCH 5.0 BinaryFILE, lines are numbered by message")
+	tolerant     = flag.Bool("t", false, "Skip malformed records in a text capture")
+	mode         = flag.String("m", "step", "Running mode, 'step', 'exec' and 'run' supported")
+	line         = flag.Uint("l", 0, "The line 'exec' runs to before stepping")
+	levels       = flag.Int("d", 5, "The number of limits shown on each side of the book")
+	lineBreaks   = flag.String("bl", "", "Comma separated lines to break on")
+	stockBreaks  = flag.String("bs", "", "Comma separated StockIds to break on")
+	traderBreaks = flag.String("bt", "", "Comma separated TraderIds to break on")
 )
 
 func main() {
 	flag.Parse()
-	loop()
-}
-
-func loop() {
-	l := *line
-	for {
-		ir := itch.NewItchReader(*filePath)
-		defer func() {
-			if r := recover(); r != nil {
-				println(fmt.Sprintf("Panic at line %d", ir.LineCount()))
-				print(fmt.Sprintf("%#v", r))
-				panic("Repanic")
-			}
-		}()
-		in := bufio.NewReader(os.Stdin)
-		dispatch := make(chan interface{}, 20)
-		orders := make(chan *msg.Message)
-		m := matcher.NewMatcher(1000)
-		m.SetDispatch(dispatch)
-		m.SetAppMsgs(appMsgs)
-		go m.Run()
-		//
-		var o *msg.OrderNode
-		var err error
-		for {
-			o, _, err = ir.ReadOrderNode()
-			if err != nil {
-				panic(err)
-			}
-			if o != nil && (o.Kind() == msg.BUY || o.Kind() == msg.SELL || o.Kind() == msg.CANCEL) {
-				orders<-o
-				clear(dispatch)
-			}
-			checkPrint(ir, o, m, l)
-			c := checkPause(in, ir, o, l)
-			if c == 'k' {
-				l = ir.LineCount() - 1
-				break
-			}
-		}
-	}
-}
-
-func checkPause(in *bufio.Reader, ir *ItchReader, o *msg.OrderNode, bLine uint) byte {
-	if bLine > ir.LineCount() {
-		return 'z'
-	}
-	if *mode == "step" {
-		return pause(in)
-	}
-	if *mode == "exec" && o == nil {
-		return pause(in)
+	if *filePath == "" {
+		flag.Usage()
+		os.Exit(2)
 	}
-	return 'z'
-}
-
-func pause(in *bufio.Reader) byte {
-	c, err := in.ReadByte()
+	f, err := os.Open(*filePath)
 	if err != nil {
 		println(err.Error())
 		os.Exit(1)
 	}
-	return c
-}
-
-func checkPrint(ir *ItchReader, o *msg.OrderNode, m *matcher.M, bLine uint) {
-	if bLine > ir.LineCount() {
-		return
+	defer f.Close()
+	var src source
+	if *binaryFile {
+		src = newBinarySource(f)
+	} else {
+		src = newTextSource(f, *tolerant)
 	}
-	if *mode == "step" {
-		printInfo(ir, o, m)
+	d, err := configure(src, os.Stdin, os.Stdout)
+	if err != nil {
+		println(err.Error())
+		os.Exit(2)
 	}
-	if *mode == "exec" && o == nil {
-		printInfo(ir, o, m)
+	if err := d.run(); err != nil {
+		println(err.Error())
+		os.Exit(1)
 	}
 }
 
-func printInfo(ir *ItchReader, o *msg.OrderNode, m *matcher.M) {
-	buys, sells, orders, executions := m.Survey()
-	println("OrderNode       ", o.String())
-	println("Line        ", ir.LineCount())
-	println("Max Buy     ", fstrconv.Itoa64Delim(int64(ir.MaxBuy()), ','))
-	println("Min Sell    ", fstrconv.Itoa64Delim(int64(ir.MinSell()), ','))
-	println("Executions  ", executions)
-	println("...")
-	println("Total       ", orders.Size())
-	println("Buy Limits  ", formatLimits(buys))
-	println("Sell Limits ", formatLimits(sells))
-	println()
-}
-
-func formatLimits(limits []*msg.SurveyLimit) string {
-	var b bytes.Buffer
-	for _, l := range limits {
-		b.WriteString(fmt.Sprintf("(%s, %s)", fstrconv.Itoa64Delim(int64(l.Price), ','), fstrconv.Itoa64Delim(int64(l.Size), ',')))
-		b.WriteString(", ")
+// Builds a debugger as configured by the command line flags
+func configure(src source, in io.Reader, out io.Writer) (*debugger, error) {
+	d := newDebugger(src, in, out, *levels)
+	switch *mode {
+	case "step":
+		d.mode = STEP
+	case "exec":
+		d.mode = EXEC
+		d.target = *line
+	case "run":
+		d.mode = RUN
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown mode %s", *mode))
 	}
-	return b.String()
-}
-
-func drain(c chan interface{}) {
-	for len(c) > 0 {
-		<-c
+	for on, ids := range map[string]string{"line": *lineBreaks, "stock": *stockBreaks, "trader": *traderBreaks} {
+		for _, s := range strings.Split(ids, ",") {
+			if s == "" {
+				continue
+			}
+			id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			if err := d.breaks.add(on, id); err != nil {
+				return nil, err
+			}
+		}
 	}
+	return d, nil
 }
-*/
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/fmstephe/matching_engine/itch"
+	"github.com/fmstephe/matching_engine/msg"
+	"io"
+	"strings"
+)
+
+// A record read from an ITCH capture, and the messages it is submitted to the matcher as
+type record struct {
+	line uint // The line of a text capture, or the message number of a binary capture
+	text string
+	msgs []msg.Message
+}
+
+// Indicates whether o answers a cancel of an order submitted earlier in the same record
+func (r *record) cancelsOwnOrder(o *msg.Message) bool {
+	for i := range r.msgs {
+		m := &r.msgs[i]
+		if (m.Kind == msg.BUY || m.Kind == msg.SELL) && m.TraderId == o.TraderId && m.TradeId == o.TradeId {
+			return true
+		}
+	}
+	return false
+}
+
+type source interface {
+	// Returns io.EOF once the capture has been read
+	next() (*record, error)
+	// The symbol of stockId, or "" if there is none
+	symbol(stockId uint64) string
+}
+
+type textSource struct {
+	r *itch.ItchReader
+}
+
+func newTextSource(r io.Reader, tolerant bool) *textSource {
+	ir := itch.NewItchReader(r)
+	ir.SetTolerant(tolerant)
+	return &textSource{r: ir}
+}
+
+func (s *textSource) next() (*record, error) {
+	o, line, err := s.r.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	return &record{line: s.r.LineCount(), text: strings.TrimSpace(line), msgs: []msg.Message{*o}}, nil
+}
+
+func (s *textSource) symbol(stockId uint64) string {
+	return ""
+}
+
+type binarySource struct {
+	r *itch.BinaryReader
+	c *itch.Converter
+}
+
+func newBinarySource(r io.Reader) *binarySource {
+	return &binarySource{r: itch.NewBinaryReader(r), c: itch.NewConverter()}
+}
+
+// Messages which don't change the book are skipped
+func (s *binarySource) next() (*record, error) {
+	for {
+		im, err := s.r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if ms := s.c.Convert(im, nil); len(ms) > 0 {
+			return &record{line: uint(s.r.Count()), text: im.String(), msgs: ms}, nil
+		}
+	}
+}
+
+func (s *binarySource) symbol(stockId uint64) string {
+	return s.c.Symbol(stockId)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/itch"
+	"github.com/fmstephe/matching_engine/msg"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const capture = "Time Seq Id Type Amount Price\n" +
+	"0 1 1 B 10 100\n" +
+	"0 2 2 S 5 101\n" +
+	"0 3 3 S 4 100\n" +
+	"0 4 1 D 10 100\n" +
+	"0 5 9 D 1 1\n"
+
+func debug(t *testing.T, commands string, configure func(d *debugger)) string {
+	return debugCapture(t, capture, commands, configure)
+}
+
+func debugCapture(t *testing.T, capture, commands string, configure func(d *debugger)) string {
+	out := &bytes.Buffer{}
+	d := newDebugger(newTextSource(strings.NewReader(capture), false), strings.NewReader(commands), out, 5)
+	configure(d)
+	if err := d.run(); err != nil {
+		t.Fatal(err.Error())
+	}
+	return out.String()
+}
+
+// Returns the lines of each record printed, ignoring reported mismatches and invalid messages
+func printed(out string) []string {
+	lines := make([]string, 0)
+	for _, l := range strings.Split(out, "\n") {
+		l = strings.TrimPrefix(l, "> ")
+		if strings.HasPrefix(l, "Line ") && !strings.Contains(l, "NOT_CANCELLED") && !strings.Contains(l, ": invalid ") {
+			lines = append(lines, strings.SplitN(l, ":", 2)[0])
+		}
+	}
+	return lines
+}
+
+func expectPrinted(t *testing.T, out string, expected ...string) {
+	if found := printed(out); strings.Join(found, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expecting %v printed, found %v\n%s", expected, found, out)
+	}
+}
+
+func TestStep(t *testing.T) {
+	out := debug(t, "s\n\nq\n", func(d *debugger) {})
+	expectPrinted(t, out, "Line 2", "Line 3", "Line 4")
+	// The sell crosses the buy at the touch
+	if !strings.Contains(out, "out  FULL, price 100, amount 4") {
+		t.Errorf("Expecting the execution to be printed\n%s", out)
+	}
+	if !strings.Contains(out, "* buy           100            6      1") {
+		t.Errorf("Expecting the touched buy limit to be marked\n%s", out)
+	}
+}
+
+func TestExec(t *testing.T) {
+	out := debug(t, "e 5\nq\n", func(d *debugger) {})
+	expectPrinted(t, out, "Line 2", "Line 5")
+	out = debug(t, "q\n", func(d *debugger) { d.mode, d.target = EXEC, 4 })
+	expectPrinted(t, out, "Line 4")
+}
+
+func TestRun(t *testing.T) {
+	out := debug(t, "", func(d *debugger) { d.mode = RUN })
+	expectPrinted(t, out)
+	// The cancel of an order which was never added is reported
+	if !strings.Contains(out, "Line 6: NOT_CANCELLED") {
+		t.Errorf("Expecting the mismatch to be reported\n%s", out)
+	}
+	if !strings.Contains(out, "Records 5, executions 2, mismatches 1") {
+		t.Errorf("Expecting a summary\n%s", out)
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	breaks := map[string]string{
+		"line 4":    "Line 4",
+		"stock 1":   "Line 2",
+		"trader 9":  "Line 6",
+		"trader 2":  "Line 3",
+		"stock 100": "",
+	}
+	for on, expected := range breaks {
+		out := debug(t, "q\n", func(d *debugger) {
+			d.mode = RUN
+			fields := strings.Fields(on)
+			id, _ := strconv.ParseUint(fields[1], 10, 64)
+			if err := d.breaks.add(fields[0], id); err != nil {
+				t.Fatal(err.Error())
+			}
+		})
+		if expected == "" {
+			expectPrinted(t, out)
+			continue
+		}
+		if !strings.Contains(out, "Breakpoint on "+on) {
+			t.Errorf("Expecting a breakpoint on %s\n%s", on, out)
+		}
+		expectPrinted(t, out, expected)
+	}
+	// Breakpoints can be added while stepping
+	out := debug(t, "b trader 9\nr\nq\n", func(d *debugger) {})
+	expectPrinted(t, out, "Line 2", "Line 6")
+}
+
+const oddCapture = "Time Seq Id Type Amount Price\n" +
+	"0 1 1 B 10 100\n" +
+	"0 2 2 X 5 101\n" +
+	"0 3 3 B 4 0\n" +
+	"0 4 4 S 10 100\n"
+
+// A buy at the market price is reported, rather than panicking the matcher
+func TestInvalidNotSubmitted(t *testing.T) {
+	out := debugCapture(t, oddCapture, "", func(d *debugger) { d.mode = RUN })
+	if !strings.Contains(out, "Line 4: invalid") {
+		t.Errorf("Expecting the invalid buy to be reported\n%s", out)
+	}
+	if !strings.Contains(out, "Records 3, executions 2, mismatches 0, invalid 1") {
+		t.Errorf("Expecting a summary\n%s", out)
+	}
+}
+
+// A breakpoint on a line without a record is hit by the next record
+func TestBreakpointOnSkippedLine(t *testing.T) {
+	out := debugCapture(t, oddCapture, "q\n", func(d *debugger) {
+		d.mode = RUN
+		d.breaks.add("line", 3)
+	})
+	if !strings.Contains(out, "Breakpoint on line 3, at the next record") {
+		t.Errorf("Expecting the breakpoint to be hit\n%s", out)
+	}
+	expectPrinted(t, out, "Line 4")
+}
+
+// Replays records prepared by a test
+type recordSource struct {
+	rs []*record
+}
+
+func (s *recordSource) next() (*record, error) {
+	if len(s.rs) == 0 {
+		return nil, io.EOF
+	}
+	r := s.rs[0]
+	s.rs = s.rs[1:]
+	return r, nil
+}
+
+func (s *recordSource) symbol(stockId uint64) string {
+	return ""
+}
+
+// An execution, as converted from a binary capture, with the aggressor cancelled after it
+func execution(line uint, tradeId uint32, amount uint64) *record {
+	a := msg.Message{Kind: msg.BUY, Price: 100, Amount: amount, StockId: 1, TraderId: itch.AggressorTraderId, TradeId: tradeId}
+	c := msg.Message{}
+	c.WriteCancelFor(&a)
+	return &record{line: line, text: "E", msgs: []msg.Message{a, c}}
+}
+
+// A filled aggressor's cancel is expected to fail, while an unfilled one is a mismatch
+func TestAggressorCancel(t *testing.T) {
+	src := &recordSource{rs: []*record{
+		{line: 1, text: "A", msgs: []msg.Message{{Kind: msg.SELL, Price: 100, Amount: 10, StockId: 1, TraderId: itch.BookTraderId, TradeId: 1}}},
+		execution(2, 2, 10),
+		execution(3, 3, 5),
+	}}
+	out := &bytes.Buffer{}
+	d := newDebugger(src, strings.NewReader(""), out, 5)
+	d.mode = RUN
+	if err := d.run(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.Contains(out.String(), "Line 3: CANCELLED") || strings.Contains(out.String(), "Line 2:") {
+		t.Errorf("Expecting only the unfilled aggressor to be reported\n%s", out)
+	}
+	if !strings.Contains(out.String(), "Records 3, executions 2, mismatches 1") {
+		t.Errorf("Expecting a summary\n%s", out)
+	}
+}
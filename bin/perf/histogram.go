@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Each power of two range is divided into this many buckets, so recorded
+// values are accurate to within 1/64th
+const (
+	subBucketBits = 7
+	subBuckets    = 1 << subBucketBits
+	halfBuckets   = subBuckets / 2
+)
+
+// An HDR style histogram of durations, in nanoseconds. Buckets are linear
+// within each power of two, giving a constant relative precision from
+// nanoseconds up to hours in a few thousand counters.
+type histogram struct {
+	counts []uint64
+	total  uint64
+	min    int64
+	max    int64
+}
+
+func newHistogram() *histogram {
+	// Enough buckets to hold the largest int64
+	return &histogram{counts: make([]uint64, bucketIdx(math.MaxInt64)+1)}
+}
+
+func (h *histogram) record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	h.counts[bucketIdx(uint64(v))]++
+	if h.total == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.total++
+}
+
+func (h *histogram) count() uint64 {
+	return h.total
+}
+
+// The smallest recorded value which p percent of recorded values are less than or equal to.
+// Values are reported as the highest value in their bucket, capped at the maximum recorded.
+func (h *histogram) percentile(p float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	target := uint64(p / 100 * float64(h.total))
+	if target == 0 {
+		target = 1
+	}
+	seen := uint64(0)
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			v := bucketHigh(i)
+			if v > h.max {
+				return h.max
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+func bucketIdx(v uint64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - subBucketBits
+	return exp*halfBuckets + int(v>>uint(exp))
+}
+
+// The highest value recorded in bucket i
+func bucketHigh(i int) int64 {
+	if i < subBuckets {
+		return int64(i)
+	}
+	exp := i/halfBuckets - 1
+	mantissa := i - exp*halfBuckets
+	return int64(mantissa+1)<<uint(exp) - 1
+}
+
+type latencies struct {
+	Count uint64        `json:"count"`
+	P50   time.Duration `json:"p50Ns"`
+	P99   time.Duration `json:"p99Ns"`
+	P999  time.Duration `json:"p999Ns"`
+	Max   time.Duration `json:"maxNs"`
+}
+
+func (h *histogram) latencies() latencies {
+	return latencies{
+		Count: h.total,
+		P50:   time.Duration(h.percentile(50)),
+		P99:   time.Duration(h.percentile(99)),
+		P999:  time.Duration(h.percentile(99.9)),
+		Max:   time.Duration(h.max),
+	}
+}
+
+func (l latencies) String() string {
+	return "p50 " + l.P50.String() + ", p99 " + l.P99.String() + ", p99.9 " + l.P999.String() + ", max " + l.Max.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/msg"
+	"time"
+)
+
+var epoch = time.Now()
+
+// Monotonic nanoseconds since the perf tool started
+func nanotime() int64 {
+	return int64(time.Since(epoch))
+}
+
+// Records the latency of each message, from being written to the matcher
+// until the matcher has written all of its outputs
+type recorder struct {
+	writes []int64
+	hist   *histogram
+}
+
+func newRecorder(msgCount int) *recorder {
+	return &recorder{writes: make([]int64, msgCount), hist: newHistogram()}
+}
+
+// Message i has been written to the matcher
+func (r *recorder) wrote(i int) {
+	r.writes[i] = nanotime()
+}
+
+// The matcher has finished processing message i
+func (r *recorder) done(i int) {
+	r.hist.record(nanotime() - r.writes[i])
+}
+
+// Placed in front of a running matcher's input. When the matcher reads a
+// message it has finished with the previous one, so that message is done.
+type timedReader struct {
+	in        coordinator.MsgReader
+	rec       *recorder
+	read      int
+	preloaded bool // The input is preloaded, so messages are written when read
+}
+
+func (tr *timedReader) Read() msg.Message {
+	if tr.read > 0 && tr.read <= len(tr.rec.writes) {
+		tr.rec.done(tr.read - 1)
+	}
+	if tr.preloaded && tr.read < len(tr.rec.writes) {
+		tr.rec.wrote(tr.read)
+	}
+	m := tr.in.Read()
+	tr.read++
+	return m
+}
@@ -4,11 +4,14 @@ import (
 	"flag"
 	"github.com/fmstephe/flib/fstrconv"
 	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/itch"
+	"github.com/fmstephe/matching_engine/journal"
 	"github.com/fmstephe/matching_engine/matcher"
 	"github.com/fmstephe/matching_engine/msg"
-	"log"
+	logger "log"
 	"math/rand"
 	"os"
+	"runtime"
 	"runtime/pprof"
 	"time"
 )
@@ -18,68 +21,203 @@ const (
 )
 
 var (
-	filePath   = flag.String("f", "", "Relative path to an ITCH file providing test data")
+	filePath   = flag.String("f", "", "Path to the ITCH file, or journal directory, read by '-s itch' and '-s journal'")
 	profile    = flag.String("p", "", "Write out a profile of this application, 'cpu' and 'mem' supported")
-	orderNum   = flag.Int("o", 1, "The number of orders to generate (in millions). Ignored if -f is provided")
+	orderNum   = flag.Int("o", 1, "The number of orders to generate (in millions) for '-s random'")
 	delDelay   = flag.Int("d", 10, "The number of orders generated before we begin deleting existing orders")
+	mode       = flag.String("m", "single", "Threading mode, 'single', 'batch', 'threaded' and 'preloaded' supported")
+	queue      = flag.String("q", "chan", "The queue between threads in '-m threaded', 'chan', 'spscq' and 'ring' supported")
+	source     = flag.String("s", "random", "Source of test data, 'random', 'itch' and 'journal' supported")
+	batchSize  = flag.Int("b", 1000, "The batch size used by '-m batch'")
+	ringWait   = flag.String("r", "park", "The wait strategy used by '-q ring', 'spin', 'yield' and 'park' supported")
+	latency    = flag.Bool("l", false, "Measure the latency of every message, from being written to the matcher until its outputs are written")
+	reportPath = flag.String("j", "", "Write a JSON report of the run to this file, '-' writes to stdout")
 	perfRand   = rand.New(rand.NewSource(1))
 	orderMaker = msg.NewMessageMaker(1)
 )
 
+// A single perf run, as selected by the command line flags
+type config struct {
+	mode      string
+	queue     string
+	wait      string
+	source    string
+	batchSize int
+	latency   bool
+}
+
+func flagConfig() config {
+	return config{mode: *mode, queue: *queue, wait: *ringWait, source: *source, batchSize: *batchSize, latency: *latency}
+}
+
 func main() {
 	doPerf(true)
 }
 
-func doPerf(log bool) {
+func doPerf(log bool) *report {
 	flag.Parse()
-	data := getData()
+	c := flagConfig()
+	data := getData(c.source)
 	if log {
 		orderCount := fstrconv.ItoaComma(int64(len(data)))
 		println(orderCount, "OrderNodes Built")
 	}
-	start := time.Now()
-	defer func() {
-		if log {
-			println("Running Time: ", time.Now().Sub(start).String())
+	r := runPerf(log, data, c)
+	if log {
+		println("Running Time: ", time.Duration(r.DurationNs).String())
+		if r.Latency != nil {
+			println("Latency: ", r.Latency.String())
 		}
-	}()
+	}
+	if *reportPath != "" {
+		writeReport(*reportPath, r)
+	}
+	return r
+}
+
+// Runs data through a matcher in the configured mode, and reports how it went
+func runPerf(log bool, data []msg.Message, c config) *report {
+	var rec *recorder
+	if c.latency {
+		rec = newRecorder(len(data))
+	}
+	run := perfMode(log, data, rec, c)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
 	startProfile()
-	defer endProfile()
-	singleThreaded(log, data)
+	start := time.Now()
+	run()
+	duration := time.Now().Sub(start)
+	endProfile()
+	runtime.ReadMemStats(&after)
+	return newReport(c, len(data), duration, after.Mallocs-before.Mallocs, rec)
+}
+
+// Selects the function which runs data through a matcher in the configured mode
+func perfMode(log bool, data []msg.Message, rec *recorder, c config) func() {
+	switch c.mode {
+	case "single":
+		return func() { singleThreaded(log, data, rec) }
+	case "batch":
+		if c.batchSize <= 0 {
+			logger.Fatalf("Batch size must be positive, found %d", c.batchSize)
+		}
+		return func() { singleThreadedBatch(log, data, rec, c.batchSize) }
+	case "preloaded":
+		return func() { multiThreadedPreloaded(log, data, rec) }
+	case "threaded":
+		switch c.queue {
+		case "chan":
+			return func() { multiThreadedChan(log, data, rec) }
+		case "spscq":
+			return func() { multiThreadedSPSCQ(log, data, rec) }
+		case "ring":
+			wait := waitStrategy(c.wait)
+			return func() { multiThreadedRing(log, data, rec, wait) }
+		}
+		logger.Fatalf("Unknown queue %s", c.queue)
+	}
+	logger.Fatalf("Unknown mode %s", c.mode)
+	return nil
 }
 
-func singleThreaded(log bool, data []msg.Message) {
+func singleThreaded(log bool, data []msg.Message, rec *recorder) {
 	inout := coordinator.NewNoopReaderWriter()
 	mchr := matcher.NewMatcher(*delDelay * 2)
 	mchr.Config("Perf Matcher", inout, inout)
+	if rec == nil {
+		for i := range data {
+			mchr.Submit(&data[i])
+		}
+		return
+	}
 	for i := range data {
+		rec.wrote(i)
 		mchr.Submit(&data[i])
+		rec.done(i)
+	}
+}
+
+func singleThreadedBatch(log bool, data []msg.Message, rec *recorder, batchSize int) {
+	inout := coordinator.NewNoopReaderWriter()
+	mchr := matcher.NewMatcher(*delDelay * 2)
+	mchr.Config("Perf Matcher", inout, inout)
+	out := make([]msg.Message, 0, batchSize*4)
+	for i := 0; i < len(data); i += batchSize {
+		end := i + batchSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if rec == nil {
+			out = mchr.SubmitBatch(data[i:end], out[:0])
+			continue
+		}
+		// Every message in a batch is written at the start of the batch, and done at the end
+		for j := i; j < end; j++ {
+			rec.wrote(j)
+		}
+		out = mchr.SubmitBatch(data[i:end], out[:0])
+		for j := i; j < end; j++ {
+			rec.done(j)
+		}
 	}
 }
 
-func multiThreadedChan(log bool, data []msg.Message) {
+func multiThreadedChan(log bool, data []msg.Message, rec *recorder) {
 	in := coordinator.NewChanReaderWriter(1024)
 	out := coordinator.NewChanReaderWriter(1024)
-	multiThreaded(log, data, in, out)
+	multiThreaded(log, data, rec, in, out)
 }
 
-func multiThreadedPreloaded(log bool, data []msg.Message) {
+// The input is preloaded, so latency is measured from the matcher reading each message
+func multiThreadedPreloaded(log bool, data []msg.Message, rec *recorder) {
 	in := coordinator.NewPreloadedReaderWriter(data)
 	out := coordinator.NewShutdownReaderWriter()
-	multiThreaded(log, data, in, out)
+	mchr := matcher.NewMatcher(*delDelay * 2)
+	if rec != nil {
+		mchr.Config("Perf Matcher", &timedReader{in: in, rec: rec, preloaded: true}, out)
+	} else {
+		mchr.Config("Perf Matcher", in, out)
+	}
+	go run(mchr)
+	read(out)
 }
 
-func multiThreadedSPSCQ(log bool, data []msg.Message) {
+func multiThreadedSPSCQ(log bool, data []msg.Message, rec *recorder) {
 	in := coordinator.NewSPSCQReaderWriter(1024 * 1024)
 	out := coordinator.NewSPSCQReaderWriter(1024 * 1024)
-	multiThreaded(log, data, in, out)
+	multiThreaded(log, data, rec, in, out)
+}
+
+func multiThreadedRing(log bool, data []msg.Message, rec *recorder, wait coordinator.WaitStrategy) {
+	in := coordinator.NewRingReaderWriter(1024*1024, wait)
+	out := coordinator.NewRingReaderWriter(1024*1024, wait)
+	multiThreaded(log, data, rec, in, out)
 }
 
-func multiThreaded(log bool, data []msg.Message, in, out coordinator.MsgReaderWriter) {
+func waitStrategy(name string) coordinator.WaitStrategy {
+	switch name {
+	case "spin":
+		return coordinator.SPIN
+	case "yield":
+		return coordinator.YIELD
+	case "park":
+		return coordinator.PARK
+	}
+	logger.Fatalf("Unknown wait strategy %s", name)
+	return coordinator.SPIN
+}
+
+func multiThreaded(log bool, data []msg.Message, rec *recorder, in, out coordinator.MsgReaderWriter) {
 	mchr := matcher.NewMatcher(*delDelay * 2)
-	mchr.Config("Perf Matcher", in, out)
+	if rec != nil {
+		mchr.Config("Perf Matcher", &timedReader{in: in, rec: rec}, out)
+	} else {
+		mchr.Config("Perf Matcher", in, out)
+	}
 	go run(mchr)
-	go write(in, data)
+	go write(in, data, rec)
 	// Read all messages coming out of the matching engine
 	read(out)
 }
@@ -101,8 +239,11 @@ func run(r runner) {
 	r.Run()
 }
 
-func write(in coordinator.MsgWriter, msgs []msg.Message) {
+func write(in coordinator.MsgWriter, msgs []msg.Message, rec *recorder) {
 	for i := range msgs {
+		if rec != nil {
+			rec.wrote(i)
+		}
 		in.Write(msgs[i])
 	}
 	in.Write(msg.Message{Kind: msg.SHUTDOWN})
@@ -112,7 +253,7 @@ func startProfile() {
 	if *profile == "cpu" {
 		f, err := os.Create("cpu.prof")
 		if err != nil {
-			log.Fatal(err)
+			logger.Fatal(err)
 		}
 		pprof.StartCPUProfile(f)
 	}
@@ -125,16 +266,66 @@ func endProfile() {
 	if *profile == "mem" {
 		f, err := os.Create("mem.prof")
 		if err != nil {
-			log.Fatal(err)
+			logger.Fatal(err)
 		}
 		pprof.WriteHeapProfile(f)
 	}
 }
 
-func getData() []msg.Message {
-	orders, err := orderMaker.RndTradeSet(*orderNum*1000*1000, *delDelay, 1000, 1500)
+func getData(source string) []msg.Message {
+	switch source {
+	case "random":
+		orders, err := orderMaker.RndTradeSet(*orderNum*1000*1000, *delDelay, 1000, 1500)
+		if err != nil {
+			panic(err.Error())
+		}
+		return orders
+	case "itch":
+		return itchData(*filePath)
+	case "journal":
+		return journalData(*filePath)
+	}
+	logger.Fatalf("Unknown source %s", source)
+	return nil
+}
+
+// Reads every valid order from an ITCH file
+func itchData(path string) []msg.Message {
+	if path == "" {
+		logger.Fatal("An ITCH file must be provided with -f")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer f.Close()
+	orders, err := itch.NewItchReader(f).ReadAll()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	data := make([]msg.Message, 0, len(orders))
+	for _, o := range orders {
+		if o.Valid() {
+			data = append(data, *o)
+		}
+	}
+	return data
+}
+
+// Reads every message recorded in a journal directory. The journal's SHUTDOWN is left out,
+// the perf modes write their own.
+func journalData(dir string) []msg.Message {
+	if dir == "" {
+		logger.Fatal("A journal directory must be provided with -f")
+	}
+	data := make([]msg.Message, 0)
+	_, err := journal.Replay(dir, 1, func(seq uint64, m *msg.Message) {
+		if m.Kind != msg.SHUTDOWN {
+			data = append(data, *m)
+		}
+	})
 	if err != nil {
-		panic(err.Error())
+		logger.Fatal(err)
 	}
-	return orders
+	return data
 }
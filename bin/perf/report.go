@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	logger "log"
+	"os"
+	"runtime"
+	"time"
+)
+
+// A machine readable summary of a single perf run
+type report struct {
+	Mode         string     `json:"mode"`
+	Queue        string     `json:"queue,omitempty"`
+	Wait         string     `json:"wait,omitempty"`
+	BatchSize    int        `json:"batchSize,omitempty"`
+	Source       string     `json:"source"`
+	GoMaxProcs   int        `json:"goMaxProcs"`
+	Messages     int        `json:"messages"`
+	DurationNs   int64      `json:"durationNs"`
+	MsgsPerSec   float64    `json:"msgsPerSec"`
+	Allocs       uint64     `json:"allocs"`
+	AllocsPerMsg float64    `json:"allocsPerMsg"`
+	Latency      *latencies `json:"latency,omitempty"`
+}
+
+func newReport(c config, msgCount int, duration time.Duration, allocs uint64, rec *recorder) *report {
+	r := &report{
+		Mode:       c.mode,
+		Source:     c.source,
+		GoMaxProcs: runtime.GOMAXPROCS(0),
+		Messages:   msgCount,
+		DurationNs: int64(duration),
+		Allocs:     allocs,
+	}
+	// Only record the settings which affected this run
+	switch c.mode {
+	case "batch":
+		r.BatchSize = c.batchSize
+	case "threaded":
+		r.Queue = c.queue
+		if c.queue == "ring" {
+			r.Wait = c.wait
+		}
+	}
+	if duration > 0 {
+		r.MsgsPerSec = float64(msgCount) / duration.Seconds()
+	}
+	if msgCount > 0 {
+		r.AllocsPerMsg = float64(allocs) / float64(msgCount)
+	}
+	if rec != nil {
+		l := rec.hist.latencies()
+		r.Latency = &l
+	}
+	return r
+}
+
+func writeReport(path string, r *report) {
+	bs, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		logger.Fatal(err)
+	}
+	bs = append(bs, '\n')
+	if path == "-" {
+		os.Stdout.Write(bs)
+		return
+	}
+	if err := os.WriteFile(path, bs, 0644); err != nil {
+		logger.Fatal(err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHistogramExactSmallValues(t *testing.T) {
+	h := newHistogram()
+	for v := int64(1); v <= 100; v++ {
+		h.record(v)
+	}
+	expectPercentile(t, h, 50, 50)
+	expectPercentile(t, h, 99, 99)
+	expectPercentile(t, h, 100, 100)
+	if h.count() != 100 || h.min != 1 || h.max != 100 {
+		t.Errorf("Expecting count 100, min 1 and max 100, found %d, %d and %d", h.count(), h.min, h.max)
+	}
+}
+
+func TestHistogramPrecision(t *testing.T) {
+	h := newHistogram()
+	for v := int64(1000); v <= 1000*1000*1000; v *= 3 {
+		h.record(v)
+		found := h.percentile(100)
+		// Values are reported as the highest value in their bucket, within 1/64th of the value recorded
+		if found < v || float64(found-v) > float64(v)/64 {
+			t.Errorf("Recorded %d, found %d", v, found)
+		}
+		h = newHistogram()
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	prevHigh := int64(-1)
+	for i := 0; i < len(newHistogram().counts); i++ {
+		high := bucketHigh(i)
+		if high <= prevHigh {
+			t.Fatalf("Bucket %d high %d not greater than previous %d", i, high, prevHigh)
+		}
+		if bucketIdx(uint64(prevHigh+1)) != i || bucketIdx(uint64(high)) != i {
+			t.Fatalf("Bucket %d does not hold %d-%d", i, prevHigh+1, high)
+		}
+		prevHigh = high
+	}
+}
+
+func TestHistogramEmpty(t *testing.T) {
+	expectPercentile(t, newHistogram(), 99, 0)
+}
+
+func expectPercentile(t *testing.T, h *histogram, p float64, expected int64) {
+	if found := h.percentile(p); found != expected {
+		t.Errorf("Expecting p%v to be %d, found %d", p, expected, found)
+	}
+}
@@ -1,6 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/msg"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -8,3 +15,134 @@ import (
 func TestPerf(t *testing.T) {
 	doPerf(false)
 }
+
+func TestPerfBatch(t *testing.T) {
+	*mode = "batch"
+	defer func() { *mode = "single" }()
+	doPerf(false)
+}
+
+func TestPerfRing(t *testing.T) {
+	*mode = "threaded"
+	*queue = "ring"
+	defer func() { *mode, *queue = "single", "chan" }()
+	doPerf(false)
+}
+
+// Every mode and queue reports every message, and only the settings it used
+func TestReportAllModes(t *testing.T) {
+	data := smallData(t)
+	configs := []config{
+		{mode: "single", source: "random"},
+		{mode: "batch", batchSize: 100, source: "random"},
+		{mode: "preloaded", source: "random"},
+		{mode: "threaded", queue: "chan", source: "random"},
+		{mode: "threaded", queue: "spscq", source: "random"},
+		{mode: "threaded", queue: "ring", wait: "park", source: "random", latency: true},
+	}
+	for _, c := range configs {
+		r := runPerf(false, data, c)
+		if r.Mode != c.mode || r.Queue != c.queue || r.Wait != c.wait || r.BatchSize != c.batchSize {
+			t.Errorf("%v: Report has the wrong settings %v", c, r)
+		}
+		if r.Messages != len(data) || r.DurationNs <= 0 || r.MsgsPerSec <= 0 {
+			t.Errorf("%v: Report has the wrong measurements %v", c, r)
+		}
+		if c.latency != (r.Latency != nil) {
+			t.Errorf("%v: Expecting latency %v, found %v", c, c.latency, r.Latency)
+		}
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	r := runPerf(false, smallData(t), config{mode: "single", source: "random", latency: true})
+	writeReport(path, r)
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	read := &report{}
+	if err := json.Unmarshal(bs, read); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(r, read) {
+		t.Errorf("Expecting %v, found %v", r, read)
+	}
+}
+
+func TestItchSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.itch")
+	lines := "Time Seq Id Type Amount Price\n" +
+		"0 1 1 B 10 100\n" +
+		"0 2 2 S 5 101\n" +
+		"\n" +
+		"0 3 1 D 10 100\n" +
+		"0 4 3 S 3 99"
+	if err := os.WriteFile(path, []byte(lines), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := []msg.Message{
+		{Kind: msg.BUY, Price: 100, Amount: 10, TraderId: 1, TradeId: 1, StockId: 1},
+		{Kind: msg.SELL, Price: 101, Amount: 5, TraderId: 2, TradeId: 2, StockId: 1},
+		{Kind: msg.CANCEL, Price: 100, Amount: 10, TraderId: 1, TradeId: 1, StockId: 1},
+		{Kind: msg.SELL, Price: 99, Amount: 3, TraderId: 3, TradeId: 3, StockId: 1},
+	}
+	*filePath = path
+	defer func() { *filePath = "" }()
+	data := getData("itch")
+	if !reflect.DeepEqual(expected, data) {
+		t.Errorf("Expecting %v, found %v", expected, data)
+	}
+	runPerf(false, data, config{mode: "single", source: "itch"})
+}
+
+func TestJournalSource(t *testing.T) {
+	dir := t.TempDir()
+	j, err := journal.Open(journal.Config{Dir: dir, SegmentSize: 100, Sync: journal.SYNC_NONE})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data := smallData(t)
+	for i := range data {
+		j.Write(data[i])
+	}
+	j.Write(msg.Message{Kind: msg.SHUTDOWN})
+	if err := j.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	*filePath = dir
+	defer func() { *filePath = "" }()
+	// The journal's SHUTDOWN is left out
+	if replayed := getData("journal"); !reflect.DeepEqual(data, replayed) {
+		t.Errorf("Expecting %d messages replayed from the journal, found %d", len(data), len(replayed))
+	}
+}
+
+func smallData(t *testing.T) []msg.Message {
+	data, err := msg.NewMessageMaker(1).RndTradeSet(1000, 10, 1000, 1500)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return data
+}
+
+// Every message has its latency recorded, in every mode
+func TestLatencyAllModes(t *testing.T) {
+	data := smallData(t)
+	modes := map[string]func(rec *recorder){
+		"singleThreaded":         func(rec *recorder) { singleThreaded(false, data, rec) },
+		"singleThreadedBatch":    func(rec *recorder) { singleThreadedBatch(false, data, rec, 100) },
+		"multiThreadedChan":      func(rec *recorder) { multiThreadedChan(false, data, rec) },
+		"multiThreadedPreloaded": func(rec *recorder) { multiThreadedPreloaded(false, data, rec) },
+		"multiThreadedSPSCQ":     func(rec *recorder) { multiThreadedSPSCQ(false, data, rec) },
+		"multiThreadedRing":      func(rec *recorder) { multiThreadedRing(false, data, rec, coordinator.PARK) },
+	}
+	for name, mode := range modes {
+		rec := newRecorder(len(data))
+		mode(rec)
+		if rec.hist.count() != uint64(len(data)) {
+			t.Errorf("%s: Expecting %d latencies, found %d", name, len(data), rec.hist.count())
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/tabwriter"
+)
+
+// The parts of a bin/perf JSON report which are compared
+type run struct {
+	Messages     int     `json:"messages"`
+	DurationNs   int64   `json:"durationNs"`
+	MsgsPerSec   float64 `json:"msgsPerSec"`
+	AllocsPerMsg float64 `json:"allocsPerMsg"`
+	Latency      latency `json:"latency"`
+}
+
+type latency struct {
+	P50Ns  int64 `json:"p50Ns"`
+	P99Ns  int64 `json:"p99Ns"`
+	P999Ns int64 `json:"p999Ns"`
+	MaxNs  int64 `json:"maxNs"`
+}
+
+type scenarioResults struct {
+	Name string   `json:"name"`
+	Args []string `json:"args"`
+	Runs []run    `json:"runs"`
+}
+
+type results struct {
+	Orders    int               `json:"orders"`
+	Latency   bool              `json:"latency"` // Latencies were measured, in runs separate from the throughput runs
+	Scenarios []scenarioResults `json:"scenarios"`
+}
+
+func (r *results) scenario(name string) *scenarioResults {
+	for i := range r.Scenarios {
+		if r.Scenarios[i].Name == name {
+			return &r.Scenarios[i]
+		}
+	}
+	return nil
+}
+
+func readResults(path string) (*results, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &results{}
+	if err := json.Unmarshal(bs, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func writeResults(path string, r *results) error {
+	bs, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(bs, '\n'), 0644)
+}
+
+type metric struct {
+	name           string
+	higherIsBetter bool
+	latency        bool
+	value          func(r *run) float64
+}
+
+var metrics = []metric{
+	{"msgs/sec", true, false, func(r *run) float64 { return r.MsgsPerSec }},
+	{"p50", false, true, func(r *run) float64 { return float64(r.Latency.P50Ns) }},
+	{"p99", false, true, func(r *run) float64 { return float64(r.Latency.P99Ns) }},
+	{"p99.9", false, true, func(r *run) float64 { return float64(r.Latency.P999Ns) }},
+	{"allocs/msg", false, false, func(r *run) float64 { return r.AllocsPerMsg }},
+}
+
+func (m metric) samples(s *scenarioResults) []float64 {
+	xs := make([]float64, len(s.Runs))
+	for i := range s.Runs {
+		xs[i] = m.value(&s.Runs[i])
+	}
+	return xs
+}
+
+// The comparison of one metric, for one scenario, between the baseline and current results
+type comparison struct {
+	scenario    string
+	metric      string
+	baseline    float64 // Mean of the baseline runs
+	current     float64 // Mean of the current runs
+	change      float64 // Relative change from the baseline, positive is an increase
+	p           float64
+	regression  bool
+	improvement bool
+}
+
+func (c comparison) verdict() string {
+	if c.regression {
+		return "REGRESSION"
+	}
+	if c.improvement {
+		return "improved"
+	}
+	return ""
+}
+
+// Compares every metric of every scenario found in both baseline and current.
+// Latencies are only compared if both measured them. A change is only flagged if it is significant at alpha and the means differ
+// by at least minChange, so that tiny but consistent differences are ignored.
+func compare(baseline, current *results, alpha, minChange float64) []comparison {
+	cs := make([]comparison, 0)
+	for i := range current.Scenarios {
+		cur := &current.Scenarios[i]
+		base := baseline.scenario(cur.Name)
+		if base == nil {
+			continue
+		}
+		for _, m := range metrics {
+			if m.latency && !(baseline.Latency && current.Latency) {
+				continue
+			}
+			bs, xs := m.samples(base), m.samples(cur)
+			mb, _ := meanVar(bs)
+			mc, _ := meanVar(xs)
+			c := comparison{scenario: cur.Name, metric: m.name, baseline: mb, current: mc, p: welch(bs, xs)}
+			c.change = relativeChange(mb, mc)
+			worse := mc < mb
+			if !m.higherIsBetter {
+				worse = mc > mb
+			}
+			significant := c.p < alpha && math.Abs(c.change) >= minChange
+			c.regression = significant && worse
+			c.improvement = significant && !worse
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+func relativeChange(from, to float64) float64 {
+	if from == 0 {
+		if to == 0 {
+			return 0
+		}
+		return math.Copysign(math.Inf(1), to)
+	}
+	return (to - from) / from
+}
+
+func regressions(cs []comparison) int {
+	n := 0
+	for _, c := range cs {
+		if c.regression {
+			n++
+		}
+	}
+	return n
+}
+
+func printComparisons(w io.Writer, cs []comparison) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "scenario\tmetric\tbaseline\tcurrent\tchange\tp\t")
+	for _, c := range cs {
+		fmt.Fprintf(tw, "%s\t%s\t%.4g\t%.4g\t%+.1f%%\t%.3f\t%s\n", c.scenario, c.metric, c.baseline, c.current, c.change*100, c.p, c.verdict())
+	}
+	tw.Flush()
+}
@@ -0,0 +1,169 @@
+// Runs a fixed set of bin/perf scenarios several times and compares the
+// results against a baseline.
+//
+// Record a baseline with -out, then after a change run again with -baseline.
+// Throughput and allocations per message, and with -l latency percentiles,
+// are compared using Welch's t-test, and the tool exits with status 1 if any
+// of them has significantly regressed.
+//
+// Timing every message slows the engine down, so latencies are measured in
+// runs of their own and throughput is always measured without them.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+var (
+	perfBin      = flag.String("bin", "", "Path to a built bin/perf. If empty bin/perf is built with 'go build'")
+	runCount     = flag.Int("n", 5, "The number of times each scenario is run")
+	orderNum     = flag.Int("o", 1, "The number of orders (in millions) used by each run")
+	measureLat   = flag.Bool("l", false, "Also measure latencies, in separate runs of each scenario")
+	inPath       = flag.String("in", "", "Read results from this file instead of running the scenarios")
+	outPath      = flag.String("out", "perf_results.json", "Write the results to this file")
+	baselinePath = flag.String("baseline", "", "Compare the results against the results in this file")
+	alpha        = flag.Float64("alpha", 0.05, "A change is significant if its p-value is below this")
+	minChange    = flag.Float64("min", 0.02, "Changes smaller than this fraction of the baseline are never flagged")
+)
+
+type scenario struct {
+	name string
+	args []string
+}
+
+var scenarios = []scenario{
+	{"single", []string{"-m", "single"}},
+	{"batch", []string{"-m", "batch", "-b", "1000"}},
+	{"preloaded", []string{"-m", "preloaded"}},
+	{"chan", []string{"-m", "threaded", "-q", "chan"}},
+	{"spscq", []string{"-m", "threaded", "-q", "spscq"}},
+	{"ring", []string{"-m", "threaded", "-q", "ring", "-r", "park"}},
+}
+
+func main() {
+	flag.Parse()
+	if *runCount < 2 && *inPath == "" && *baselinePath != "" {
+		println("At least 2 runs of each scenario are needed to compare against a baseline")
+		os.Exit(2)
+	}
+	res, err := getResults()
+	if err != nil {
+		println(err.Error())
+		os.Exit(1)
+	}
+	if *inPath == "" && *outPath != "" {
+		if err := writeResults(*outPath, res); err != nil {
+			println(err.Error())
+			os.Exit(1)
+		}
+	}
+	if *baselinePath == "" {
+		return
+	}
+	baseline, err := readResults(*baselinePath)
+	if err != nil {
+		println(err.Error())
+		os.Exit(1)
+	}
+	if baseline.Orders != res.Orders {
+		fmt.Printf("Warning: baseline used %dM orders, these results used %dM\n", baseline.Orders, res.Orders)
+	}
+	if baseline.Latency != res.Latency {
+		fmt.Printf("Warning: latencies were not measured by both the baseline and these results, and are not compared\n")
+	}
+	cs := compare(baseline, res, *alpha, *minChange)
+	printComparisons(os.Stdout, cs)
+	if n := regressions(cs); n > 0 {
+		fmt.Printf("%d regressions found\n", n)
+		os.Exit(1)
+	}
+}
+
+func getResults() (*results, error) {
+	if *inPath != "" {
+		return readResults(*inPath)
+	}
+	dir, err := os.MkdirTemp("", "perfcmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	bin := *perfBin
+	if bin == "" {
+		bin = filepath.Join(dir, "perf")
+		if err := buildPerf(bin); err != nil {
+			return nil, err
+		}
+	}
+	return runScenarios(bin, dir, scenarios, *runCount, *orderNum, *measureLat)
+}
+
+func buildPerf(bin string) error {
+	out, err := exec.Command("go", "build", "-o", bin, "github.com/fmstephe/matching_engine/bin/perf").CombinedOutput()
+	if err != nil {
+		return errors.New(fmt.Sprintf("Building bin/perf failed: %s\n%s", err.Error(), out))
+	}
+	return nil
+}
+
+// Runs every scenario n times, each in its own process. The scenarios take
+// turns, so a slow drift in the machine's performance affects them all equally.
+// If lat is true each throughput run is followed by a run measuring latencies.
+func runScenarios(bin, dir string, ss []scenario, n, orders int, lat bool) (*results, error) {
+	res := &results{Orders: orders, Latency: lat, Scenarios: make([]scenarioResults, len(ss))}
+	for i, s := range ss {
+		res.Scenarios[i] = scenarioResults{Name: s.name, Args: s.args, Runs: make([]run, 0, n)}
+	}
+	report := filepath.Join(dir, "report.json")
+	for i := 0; i < n; i++ {
+		for j, s := range ss {
+			r, err := runPerf(bin, runArgs(s, orders, report, false), s.name, report)
+			if err != nil {
+				return nil, err
+			}
+			if lat {
+				lr, err := runPerf(bin, runArgs(s, orders, report, true), s.name, report)
+				if err != nil {
+					return nil, err
+				}
+				r.Latency = lr.Latency
+			}
+			res.Scenarios[j].Runs = append(res.Scenarios[j].Runs, *r)
+		}
+	}
+	return res, nil
+}
+
+func runArgs(s scenario, orders int, report string, lat bool) []string {
+	args := []string{"-o", strconv.Itoa(orders), "-j", report}
+	if lat {
+		args = append(args, "-l")
+	}
+	return append(args, s.args...)
+}
+
+func runPerf(bin string, args []string, name, report string) (*run, error) {
+	if out, err := exec.Command(bin, args...).CombinedOutput(); err != nil {
+		return nil, errors.New(fmt.Sprintf("Scenario %s failed: %s\n%s", name, err.Error(), out))
+	}
+	return readRun(report)
+}
+
+func readRun(path string) (*run, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &run{}
+	if err := json.Unmarshal(bs, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func mkRuns(throughputs []float64, p99 int64, allocs float64) []run {
+	rs := make([]run, len(throughputs))
+	for i, tp := range throughputs {
+		// Latencies vary a little with each run
+		rs[i] = run{MsgsPerSec: tp, AllocsPerMsg: allocs, Latency: latency{P50Ns: 100 + int64(i), P99Ns: p99 + int64(i), P999Ns: 2000 + int64(i)}}
+	}
+	return rs
+}
+
+func mkResults(runs map[string][]run) *results {
+	r := &results{Orders: 1, Latency: true}
+	for _, name := range []string{"single", "batch", "ring"} {
+		if rs, ok := runs[name]; ok {
+			r.Scenarios = append(r.Scenarios, scenarioResults{Name: name, Runs: rs})
+		}
+	}
+	return r
+}
+
+func findComparison(t *testing.T, cs []comparison, scenario, metric string) comparison {
+	for _, c := range cs {
+		if c.scenario == scenario && c.metric == metric {
+			return c
+		}
+	}
+	t.Fatalf("No comparison of %s for %s", metric, scenario)
+	return comparison{}
+}
+
+func TestCompare(t *testing.T) {
+	steady := []float64{100, 101, 99, 100, 102}
+	baseline := mkResults(map[string][]run{
+		"single": mkRuns(steady, 300, 0),
+		"batch":  mkRuns(steady, 300, 0.5),
+		"ring":   mkRuns(steady, 300, 0),
+	})
+	current := mkResults(map[string][]run{
+		// Slower, with worse p99 latency
+		"single": mkRuns([]float64{90, 91, 89, 90, 92}, 400, 0),
+		// Faster, but allocating more
+		"batch": mkRuns([]float64{120, 121, 119, 120, 122}, 300, 1),
+		// Noisy, but not really different
+		"ring": mkRuns([]float64{80, 120, 95, 110, 100}, 300, 0),
+	})
+	cs := compare(baseline, current, 0.05, 0.02)
+	if len(cs) != 3*len(metrics) {
+		t.Fatalf("Expecting %d comparisons, found %d", 3*len(metrics), len(cs))
+	}
+	expected := map[string]map[string]string{
+		"single": {"msgs/sec": "REGRESSION", "p50": "", "p99": "REGRESSION", "p99.9": "", "allocs/msg": ""},
+		"batch":  {"msgs/sec": "improved", "p50": "", "p99": "", "p99.9": "", "allocs/msg": "REGRESSION"},
+		"ring":   {"msgs/sec": "", "p50": "", "p99": "", "p99.9": "", "allocs/msg": ""},
+	}
+	for scenario, verdicts := range expected {
+		for metric, verdict := range verdicts {
+			if c := findComparison(t, cs, scenario, metric); c.verdict() != verdict {
+				t.Errorf("%s %s: Expecting %q, found %q (p %v, change %v)", scenario, metric, verdict, c.verdict(), c.p, c.change)
+			}
+		}
+	}
+	if n := regressions(cs); n != 3 {
+		t.Errorf("Expecting 3 regressions, found %d", n)
+	}
+}
+
+// Significant changes smaller than minChange are not flagged
+func TestCompareMinChange(t *testing.T) {
+	baseline := mkResults(map[string][]run{"single": mkRuns([]float64{100, 100.1, 99.9}, 300, 0)})
+	current := mkResults(map[string][]run{"single": mkRuns([]float64{99, 99.1, 98.9}, 300, 0)})
+	c := findComparison(t, compare(baseline, current, 0.05, 0.02), "single", "msgs/sec")
+	if c.p >= 0.05 || c.regression {
+		t.Errorf("Expecting a significant change which isn't a regression, found p %v regression %v", c.p, c.regression)
+	}
+	c = findComparison(t, compare(baseline, current, 0.05, 0.005), "single", "msgs/sec")
+	if !c.regression {
+		t.Errorf("Expecting a regression, found p %v change %v", c.p, c.change)
+	}
+}
+
+// Latencies are only compared when both results measured them
+func TestCompareWithoutLatency(t *testing.T) {
+	baseline := mkResults(map[string][]run{"single": mkRuns([]float64{100, 101, 99}, 300, 0)})
+	current := mkResults(map[string][]run{"single": mkRuns([]float64{100, 101, 99}, 900, 0)})
+	current.Latency = false
+	cs := compare(baseline, current, 0.05, 0.02)
+	for _, c := range cs {
+		if c.metric != "msgs/sec" && c.metric != "allocs/msg" {
+			t.Errorf("Expecting no latency comparisons, found %s", c.metric)
+		}
+	}
+	if len(cs) != 2 {
+		t.Errorf("Expecting 2 comparisons, found %d", len(cs))
+	}
+}
+
+// Throughput is never measured while timing every message
+func TestRunArgs(t *testing.T) {
+	s := scenario{"single", []string{"-m", "single"}}
+	if args := runArgs(s, 2, "r.json", false); !reflect.DeepEqual(args, []string{"-o", "2", "-j", "r.json", "-m", "single"}) {
+		t.Errorf("Unexpected throughput args %v", args)
+	}
+	if args := runArgs(s, 2, "r.json", true); !reflect.DeepEqual(args, []string{"-o", "2", "-j", "r.json", "-l", "-m", "single"}) {
+		t.Errorf("Unexpected latency args %v", args)
+	}
+}
+
+// Scenarios missing from the baseline are not compared
+func TestCompareNewScenario(t *testing.T) {
+	baseline := mkResults(map[string][]run{"single": mkRuns([]float64{1, 2}, 300, 0)})
+	current := mkResults(map[string][]run{"single": mkRuns([]float64{1, 2}, 300, 0), "ring": mkRuns([]float64{1, 2}, 300, 0)})
+	for _, c := range compare(baseline, current, 0.05, 0.02) {
+		if c.scenario != "single" {
+			t.Errorf("Expecting only single to be compared, found %s", c.scenario)
+		}
+	}
+}
+
+func TestResultsRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.json")
+	r := mkResults(map[string][]run{"single": mkRuns([]float64{1, 2}, 300, 0.25), "batch": mkRuns([]float64{3, 4}, 500, 0)})
+	r.Scenarios[0].Args = []string{"-m", "single"}
+	if err := writeResults(path, r); err != nil {
+		t.Fatal(err.Error())
+	}
+	read, err := readResults(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(r, read) {
+		t.Errorf("Expecting %v, found %v", r, read)
+	}
+}
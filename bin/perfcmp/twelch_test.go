@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+const tolerance = 1e-9
+
+// Student's t-distribution has a closed form CDF for 1, 2 and 4 degrees of freedom
+func TestStudentCDF(t *testing.T) {
+	closed := map[float64]func(t float64) float64{
+		1: func(t float64) float64 { return 0.5 + math.Atan(t)/math.Pi },
+		2: func(t float64) float64 { return 0.5 + t/(2*math.Sqrt(2+t*t)) },
+		4: func(t float64) float64 {
+			a := t * t / (4 + t*t)
+			return 0.5 + 3/8.0*(t/math.Sqrt(1+t*t/4))*(1-a/3)
+		},
+	}
+	for df, cdf := range closed {
+		for _, x := range []float64{-10, -2.5, -1, -0.1, 0, 0.3, 1, 3.674, 50} {
+			expected, found := cdf(x), studentCDF(x, df)
+			if math.Abs(expected-found) > tolerance {
+				t.Errorf("df %v, t %v: Expecting %v, found %v", df, x, expected, found)
+			}
+		}
+	}
+}
+
+func TestWelch(t *testing.T) {
+	// Equal sizes and variances give 4 degrees of freedom, and t = -3/sqrt(2/3)
+	a, b := []float64{1, 2, 3}, []float64{4, 5, 6}
+	tv := -3 / math.Sqrt(2/3.0)
+	x := tv * tv / (4 + tv*tv)
+	expected := 2 * (0.5 - 3/8.0*(math.Abs(tv)/math.Sqrt(1+tv*tv/4))*(1-x/3))
+	if p := welch(a, b); math.Abs(p-expected) > tolerance {
+		t.Errorf("Expecting p of %v, found %v", expected, p)
+	}
+	if p := welch(a, a); p != 1 {
+		t.Errorf("Expecting identical samples to have p of 1, found %v", p)
+	}
+	// Samples which don't vary are only the same if they are equal
+	if p := welch([]float64{2, 2, 2}, []float64{3, 3, 3}); p != 0 {
+		t.Errorf("Expecting p of 0, found %v", p)
+	}
+	if p := welch([]float64{2, 2, 2}, []float64{2, 2}); p != 1 {
+		t.Errorf("Expecting p of 1, found %v", p)
+	}
+	// A single sample tells us nothing about its variance
+	if p := welch([]float64{1}, []float64{100, 101}); p != 1 {
+		t.Errorf("Expecting p of 1, found %v", p)
+	}
+}
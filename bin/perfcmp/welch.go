@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math"
+)
+
+// The mean and sample variance of xs
+func meanVar(xs []float64) (mean, variance float64) {
+	if len(xs) == 0 {
+		return 0, 0
+	}
+	for _, x := range xs {
+		mean += x
+	}
+	mean /= float64(len(xs))
+	if len(xs) == 1 {
+		return mean, 0
+	}
+	for _, x := range xs {
+		variance += (x - mean) * (x - mean)
+	}
+	variance /= float64(len(xs) - 1)
+	return mean, variance
+}
+
+// Welch's t-test of whether the means of a and b differ. Returns the two
+// tailed p-value, the probability of seeing a difference at least this large
+// if both samples came from distributions with the same mean.
+func welch(a, b []float64) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 1
+	}
+	ma, va := meanVar(a)
+	mb, vb := meanVar(b)
+	na, nb := float64(len(a)), float64(len(b))
+	sa, sb := va/na, vb/nb
+	if sa+sb == 0 {
+		// Neither sample varies, any difference is real
+		if ma == mb {
+			return 1
+		}
+		return 0
+	}
+	t := (ma - mb) / math.Sqrt(sa+sb)
+	// Welch-Satterthwaite degrees of freedom
+	df := (sa + sb) * (sa + sb) / (sa*sa/(na-1) + sb*sb/(nb-1))
+	return 2 * (1 - studentCDF(math.Abs(t), df))
+}
+
+// The cumulative distribution function of Student's t-distribution with df degrees of freedom
+func studentCDF(t, df float64) float64 {
+	x := df / (df + t*t)
+	tail := 0.5 * incBeta(df/2, 0.5, x)
+	if t > 0 {
+		return 1 - tail
+	}
+	return tail
+}
+
+// The regularised incomplete beta function I_x(a, b)
+func incBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+	// The continued fraction converges quickly only below this point, beyond it use the symmetry relation
+	if x < (a+1)/(a+b+2) {
+		return front * betaCF(a, b, x) / a
+	}
+	return 1 - front*betaCF(b, a, 1-x)/b
+}
+
+const (
+	cfIterations = 200
+	cfEpsilon    = 1e-14
+	cfTiny       = 1e-300
+)
+
+// Evaluates the continued fraction for the incomplete beta function, using Lentz's method
+func betaCF(a, b, x float64) float64 {
+	c := 1.0
+	d := 1 - (a+b)*x/(a+1)
+	if math.Abs(d) < cfTiny {
+		d = cfTiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= cfIterations; m++ {
+		fm := float64(m)
+		// The even step
+		num := fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm))
+		d = 1 + num*d
+		if math.Abs(d) < cfTiny {
+			d = cfTiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < cfTiny {
+			c = cfTiny
+		}
+		d = 1 / d
+		h *= d * c
+		// The odd step
+		num = -(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1))
+		d = 1 + num*d
+		if math.Abs(d) < cfTiny {
+			d = cfTiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < cfTiny {
+			c = cfTiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < cfEpsilon {
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,203 @@
+// Replays a recorded input journal through a matcher and hashes every output message.
+//
+// To prove that two matcher builds behave identically, record the outputs
+// of one build with -r and then replay the same journal through the second
+// build with -c. Alternatively -b names the bin/replay binary of another
+// build, which replays the journal first and records its outputs to compare
+// against. The first diverging output is reported along with the input
+// message which produced it.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/msg"
+	"hash"
+	"hash/fnv"
+	"os"
+	"os/exec"
+)
+
+var (
+	journalDir = flag.String("j", "", "Directory of the input journal to replay")
+	recordDir  = flag.String("r", "", "Directory to record the output log in")
+	compareDir = flag.String("c", "", "Directory of a recorded output log to compare against")
+	otherBuild = flag.String("b", "", "The bin/replay binary of another build to replay the journal through and compare against")
+	slabSize   = flag.Int("s", 1024*1024, "The initial size of the matcher's slab")
+)
+
+func main() {
+	flag.Parse()
+	if *journalDir == "" || (*compareDir != "" && *otherBuild != "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	os.Exit(run())
+}
+
+func run() int {
+	compare := *compareDir
+	if *otherBuild != "" {
+		dir, err := recordWith(*otherBuild, *journalDir)
+		if err != nil {
+			println(err.Error())
+			return 1
+		}
+		defer os.RemoveAll(dir)
+		compare = dir
+	}
+	res, err := replay(*journalDir, *recordDir, compare, *slabSize)
+	if err != nil {
+		println(err.Error())
+		return 1
+	}
+	fmt.Printf("Inputs:  %d\nOutputs: %d\nHash:    %016x\n", res.inputs, res.outputs, res.hash)
+	if res.divergence != nil {
+		fmt.Println(res.divergence.String())
+		return 1
+	}
+	return 0
+}
+
+// Replays the journal in journalDir through build, another build of this
+// command, returning a temporary directory holding the outputs it recorded
+func recordWith(build, journalDir string) (string, error) {
+	dir, err := os.MkdirTemp("", "replay")
+	if err != nil {
+		return "", err
+	}
+	if out, err := exec.Command(build, "-j", journalDir, "-r", dir).CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", errors.New(fmt.Sprintf("Replaying through %s failed: %s\n%s", build, err.Error(), out))
+	}
+	return dir, nil
+}
+
+type result struct {
+	inputs     uint64
+	outputs    uint64
+	hash       uint64
+	divergence *divergence
+}
+
+// The first output which differs from the recorded output log
+type divergence struct {
+	inputSeq  uint64
+	input     msg.Message
+	outputSeq uint64
+	expected  *msg.Message // nil if the recorded log has ended
+	found     *msg.Message // nil if the replay produced fewer outputs
+}
+
+func (d *divergence) String() string {
+	return fmt.Sprintf("Divergence at output %d\nInput %d:  %v\nExpected:  %v\nFound:     %v", d.outputSeq, d.inputSeq, &d.input, d.expected, d.found)
+}
+
+func replay(journalDir, recordDir, compareDir string, slabSize int) (*result, error) {
+	ow := &outputWriter{h: fnv.New64a(), b: make([]byte, msg.ByteSize)}
+	if recordDir != "" {
+		// One segment per million outputs
+		rec, err := journal.Open(journal.Config{Dir: recordDir, SegmentSize: 1024 * 1024})
+		if err != nil {
+			return nil, err
+		}
+		defer rec.Close()
+		ow.record = rec
+	}
+	if compareDir != "" {
+		recorded := make(chan msg.Message, 1024)
+		errc := make(chan error, 1)
+		go func() {
+			_, err := journal.Replay(compareDir, 1, func(seq uint64, m *msg.Message) {
+				recorded <- *m
+			})
+			errc <- err
+			close(recorded)
+		}()
+		defer func() {
+			// Drain the recorded log so its reader can finish
+			for range recorded {
+			}
+		}()
+		ow.recorded = recorded
+		ow.recordedErr = errc
+	}
+	m := matcher.NewMatcher(slabSize)
+	m.Config("Replay Matcher", coordinator.NewNoopReaderWriter(), ow)
+	res := &result{}
+	_, err := journal.Replay(journalDir, 1, func(seq uint64, in *msg.Message) {
+		res.inputs++
+		if in.Kind == msg.SHUTDOWN {
+			return
+		}
+		ow.inputSeq = seq
+		ow.input = *in
+		m.Submit(in)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ow.recorded != nil && ow.divergence == nil {
+		// The replay may have produced fewer outputs than were recorded
+		if e, ok := <-ow.recorded; ok {
+			ow.diverge(&e, nil)
+		}
+		if err := <-ow.recordedErr; err != nil {
+			return nil, err
+		}
+	}
+	if ow.err != nil {
+		return nil, ow.err
+	}
+	res.outputs = ow.outputs
+	res.hash = ow.h.Sum64()
+	res.divergence = ow.divergence
+	return res, nil
+}
+
+// Hashes, records and compares every message written by the matcher
+type outputWriter struct {
+	h           hash.Hash64
+	b           []byte
+	outputs     uint64
+	inputSeq    uint64
+	input       msg.Message
+	record      *journal.Journal
+	recorded    chan msg.Message
+	recordedErr chan error
+	divergence  *divergence
+	err         error
+}
+
+func (w *outputWriter) Write(m msg.Message) {
+	w.outputs++
+	m.Marshal(w.b)
+	w.h.Write(w.b)
+	if w.record != nil && w.err == nil {
+		_, w.err = w.record.Append(&m)
+	}
+	if w.recorded != nil && w.divergence == nil {
+		e, ok := <-w.recorded
+		if !ok {
+			w.diverge(nil, &m)
+			if err := <-w.recordedErr; err != nil {
+				w.err = err
+			}
+			return
+		}
+		if e != m {
+			w.diverge(&e, &m)
+		}
+	}
+}
+
+func (w *outputWriter) diverge(expected, found *msg.Message) {
+	w.divergence = &divergence{inputSeq: w.inputSeq, input: w.input, outputSeq: w.outputs, expected: expected, found: found}
+	if found == nil {
+		w.divergence.outputSeq++
+	}
+}
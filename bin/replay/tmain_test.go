@@ -0,0 +1,125 @@
+package main
+
+import (
+	"github.com/fmstephe/matching_engine/journal"
+	"github.com/fmstephe/matching_engine/msg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func writeJournal(t *testing.T, dir string, ms []msg.Message) {
+	j, err := journal.Open(journal.Config{Dir: dir, SegmentSize: 100})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := range ms {
+		j.Write(ms[i])
+	}
+	j.Close()
+}
+
+func testJournal(t *testing.T) []msg.Message {
+	ms, err := msg.NewMessageMaker(1).RndTradeSet(500, 50, 1, 20)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return append(ms, msg.Message{Kind: msg.SHUTDOWN})
+}
+
+func TestReplayMatchesRecording(t *testing.T) {
+	in, rec := t.TempDir(), t.TempDir()
+	writeJournal(t, in, testJournal(t))
+	recorded, err := replay(in, rec, "", 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	compared, err := replay(in, "", rec, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if compared.divergence != nil {
+		t.Errorf("Unexpected divergence\n%s", compared.divergence.String())
+	}
+	if recorded.hash != compared.hash || recorded.outputs != compared.outputs {
+		t.Errorf("Replays differ. Recorded %d outputs with hash %x, compared %d outputs with hash %x", recorded.outputs, recorded.hash, compared.outputs, compared.hash)
+	}
+}
+
+func readJournal(t *testing.T, dir string) []msg.Message {
+	ms := make([]msg.Message, 0)
+	if _, err := journal.Replay(dir, 1, func(seq uint64, m *msg.Message) { ms = append(ms, *m) }); err != nil {
+		t.Fatal(err.Error())
+	}
+	return ms
+}
+
+func TestReplayFindsDivergence(t *testing.T) {
+	in, rec := t.TempDir(), t.TempDir()
+	writeJournal(t, in, testJournal(t))
+	if _, err := replay(in, rec, "", 100); err != nil {
+		t.Fatal(err.Error())
+	}
+	outs := readJournal(t, rec)
+	// A recording whose 10th output differs
+	altered := append([]msg.Message{}, outs...)
+	altered[9].Amount++
+	alteredDir := t.TempDir()
+	writeJournal(t, alteredDir, altered)
+	res, err := replay(in, "", alteredDir, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	d := res.divergence
+	if d == nil || d.outputSeq != 10 || *d.expected != altered[9] || *d.found != outs[9] {
+		t.Errorf("Expecting divergence at output 10, found %v", d)
+	}
+	// A recording missing its final output
+	shortDir := t.TempDir()
+	writeJournal(t, shortDir, outs[:len(outs)-1])
+	res, err = replay(in, "", shortDir, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	d = res.divergence
+	if d == nil || d.outputSeq != uint64(len(outs)) || d.expected != nil {
+		t.Errorf("Expecting divergence at output %d, found %v", len(outs), d)
+	}
+	// A recording with an extra output
+	longDir := t.TempDir()
+	writeJournal(t, longDir, append(outs, outs[0]))
+	res, err = replay(in, "", longDir, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	d = res.divergence
+	if d == nil || d.outputSeq != uint64(len(outs)+1) || d.found != nil {
+		t.Errorf("Expecting divergence at output %d, found %v", len(outs)+1, d)
+	}
+}
+
+// A second build of bin/replay, here a build of this one, replays identically
+func TestReplayAgainstBuild(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "replay")
+	if out, err := exec.Command("go", "build", "-o", bin, "github.com/fmstephe/matching_engine/bin/replay").CombinedOutput(); err != nil {
+		t.Fatalf("Building replay failed: %s\n%s", err.Error(), out)
+	}
+	in := t.TempDir()
+	writeJournal(t, in, testJournal(t))
+	dir, err := recordWith(bin, in)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	res, err := replay(in, "", dir, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if res.divergence != nil || res.outputs == 0 {
+		t.Errorf("Expecting identical outputs, found %d outputs and divergence %v", res.outputs, res.divergence)
+	}
+	if _, err := recordWith(filepath.Join(t.TempDir(), "missing"), in); err == nil {
+		t.Errorf("Expecting an error replaying through a missing build")
+	}
+}
@@ -0,0 +1,128 @@
+package coordinator
+
+import (
+	"github.com/fmstephe/flib/fmath"
+	"github.com/fmstephe/matching_engine/msg"
+	"sync"
+	"sync/atomic"
+)
+
+type mpscSlot struct {
+	// Equal to the ticket which may write the slot next, and one greater once written
+	seq    int64
+	origin uint32
+	m      msg.Message
+}
+
+// A multi-producer/single-consumer queue of msg.Message values. Any number
+// of goroutines may Write, but only one may Read.
+//
+// Each write takes a ticket, which fixes its place in the total order of
+// messages before the message is copied in. Messages are read in ticket
+// order, so a slow writer delays the messages which took later tickets.
+type MPSCReaderWriter struct {
+	_ [cacheLine]byte
+	// Written by producers
+	ticket int64
+	_      [cacheLine - 8]byte
+	// Written by the consumer
+	readSeq int64
+	_       [cacheLine - 8]byte
+	slots   []mpscSlot
+	size    int64
+	mask    int64
+	waiter
+}
+
+// Creates a queue holding size messages, rounded up to a power of two
+func NewMPSCReaderWriter(size int64, wait WaitStrategy) *MPSCReaderWriter {
+	p2Size := fmath.NxtPowerOfTwo(size)
+	rw := &MPSCReaderWriter{
+		slots: make([]mpscSlot, p2Size),
+		size:  p2Size,
+		mask:  p2Size - 1,
+	}
+	for i := range rw.slots {
+		rw.slots[i].seq = int64(i)
+	}
+	rw.waiter.init(wait)
+	return rw
+}
+
+func (rw *MPSCReaderWriter) Write(m msg.Message) {
+	rw.WriteFrom(0, m)
+}
+
+// Writes m, tagged with the origin it came from
+func (rw *MPSCReaderWriter) WriteFrom(origin uint32, m msg.Message) {
+	t := atomic.AddInt64(&rw.ticket, 1) - 1
+	s := &rw.slots[t&rw.mask]
+	rw.await(&s.seq, t)
+	s.origin = origin
+	s.m = m
+	atomic.StoreInt64(&s.seq, t+1)
+	rw.notify()
+}
+
+func (rw *MPSCReaderWriter) Read() msg.Message {
+	_, m := rw.ReadFrom()
+	return m
+}
+
+// Reads the next message, and the origin it was written from
+func (rw *MPSCReaderWriter) ReadFrom() (origin uint32, m msg.Message) {
+	r := rw.readSeq
+	s := &rw.slots[r&rw.mask]
+	rw.await(&s.seq, r+1)
+	origin, m = s.origin, s.m
+	atomic.StoreInt64(&s.seq, r+rw.size)
+	rw.readSeq = r + 1
+	rw.notify()
+	return origin, m
+}
+
+// Returns a MsgWriter which writes to rw, tagging each message with origin
+func (rw *MPSCReaderWriter) From(origin uint32) MsgWriter {
+	return &originWriter{rw: rw, origin: origin}
+}
+
+type originWriter struct {
+	rw     *MPSCReaderWriter
+	origin uint32
+}
+
+func (w *originWriter) Write(m msg.Message) {
+	w.rw.WriteFrom(w.origin, m)
+}
+
+// Merges messages read from many sources into a single MPSCReaderWriter,
+// tagging each message with the origin of its source
+type FanIn struct {
+	out     *MPSCReaderWriter
+	running sync.WaitGroup
+}
+
+func NewFanIn(out *MPSCReaderWriter) *FanIn {
+	return &FanIn{out: out}
+}
+
+// Forwards every message read from in until it reads a SHUTDOWN. The
+// SHUTDOWN only ends this source, and is not forwarded.
+func (f *FanIn) Add(origin uint32, in MsgReader) {
+	f.running.Add(1)
+	go func() {
+		defer f.running.Done()
+		for {
+			m := in.Read()
+			if m.Kind == msg.SHUTDOWN {
+				return
+			}
+			f.out.WriteFrom(origin, m)
+		}
+	}()
+}
+
+// Waits until every source has shut down
+func (f *FanIn) Wait() {
+	f.running.Wait()
+}
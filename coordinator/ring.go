@@ -0,0 +1,120 @@
+package coordinator
+
+import (
+	"github.com/fmstephe/flib/fmath"
+	"github.com/fmstephe/matching_engine/msg"
+	"sync/atomic"
+)
+
+const cacheLine = 64
+
+// A single-producer/single-consumer ring buffer of msg.Message values.
+// Messages are copied into and out of the ring, so nothing escapes to the heap.
+//
+// Each side's sequence, and its cached copy of the other side's sequence,
+// share a cache line which the other side only reads.
+type RingReaderWriter struct {
+	_ [cacheLine]byte
+	// Written by the producer
+	writeSeq  int64
+	readCache int64
+	_         [cacheLine - 16]byte
+	// Written by the consumer
+	readSeq    int64
+	writeCache int64
+	_          [cacheLine - 16]byte
+	ring       []msg.Message
+	size       int64
+	mask       int64
+	waiter
+}
+
+// Creates a ring holding size messages, rounded up to a power of two
+func NewRingReaderWriter(size int64, wait WaitStrategy) *RingReaderWriter {
+	p2Size := fmath.NxtPowerOfTwo(size)
+	rw := &RingReaderWriter{
+		ring: make([]msg.Message, p2Size),
+		size: p2Size,
+		mask: p2Size - 1,
+	}
+	rw.waiter.init(wait)
+	return rw
+}
+
+func (rw *RingReaderWriter) Write(m msg.Message) {
+	w := atomic.LoadInt64(&rw.writeSeq)
+	rw.awaitFree(w)
+	rw.ring[w&rw.mask] = m
+	atomic.StoreInt64(&rw.writeSeq, w+1)
+	rw.notify()
+}
+
+// Writes every message in ms, waiting for space as needed
+func (rw *RingReaderWriter) WriteBatch(ms []msg.Message) {
+	for len(ms) > 0 {
+		w := atomic.LoadInt64(&rw.writeSeq)
+		rw.awaitFree(w)
+		n := rw.size - (w - rw.readCache)
+		if n > int64(len(ms)) {
+			n = int64(len(ms))
+		}
+		rw.copyIn(w, ms[:n])
+		atomic.StoreInt64(&rw.writeSeq, w+n)
+		rw.notify()
+		ms = ms[n:]
+	}
+}
+
+func (rw *RingReaderWriter) Read() msg.Message {
+	r := atomic.LoadInt64(&rw.readSeq)
+	rw.awaitAvailable(r)
+	m := rw.ring[r&rw.mask]
+	atomic.StoreInt64(&rw.readSeq, r+1)
+	rw.notify()
+	return m
+}
+
+// Reads at least one, and at most len(ms), messages into ms. Returns the number read.
+func (rw *RingReaderWriter) ReadBatch(ms []msg.Message) int {
+	if len(ms) == 0 {
+		return 0
+	}
+	r := atomic.LoadInt64(&rw.readSeq)
+	rw.awaitAvailable(r)
+	n := rw.writeCache - r
+	if n > int64(len(ms)) {
+		n = int64(len(ms))
+	}
+	rw.copyOut(r, ms[:n])
+	atomic.StoreInt64(&rw.readSeq, r+n)
+	rw.notify()
+	return int(n)
+}
+
+func (rw *RingReaderWriter) copyIn(w int64, ms []msg.Message) {
+	idx := w & rw.mask
+	first := copy(rw.ring[idx:], ms)
+	copy(rw.ring, ms[first:])
+}
+
+func (rw *RingReaderWriter) copyOut(r int64, ms []msg.Message) {
+	idx := r & rw.mask
+	first := copy(ms, rw.ring[idx:])
+	copy(ms[first:], rw.ring)
+}
+
+// Waits until there is space to write at least one message at w
+func (rw *RingReaderWriter) awaitFree(w int64) {
+	if w-rw.readCache < rw.size {
+		return
+	}
+	rw.readCache = rw.await(&rw.readSeq, w-rw.size+1)
+}
+
+// Waits until there is at least one message to read at r
+func (rw *RingReaderWriter) awaitAvailable(r int64) {
+	if rw.writeCache > r {
+		return
+	}
+	rw.writeCache = rw.await(&rw.writeSeq, r+1)
+}
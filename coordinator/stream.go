@@ -0,0 +1,196 @@
+package coordinator
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/fmstephe/matching_engine/msg"
+	"io"
+)
+
+// Each message on a stream is framed by a two byte little endian length,
+// followed by that many bytes holding the message in msg's binary encoding.
+const (
+	frameHeaderLen = 2
+	frameLen       = frameHeaderLen + msg.ByteSize
+	streamBufSize  = 64 * frameLen
+)
+
+// A CoordinatorFunc for byte streams, such as a net.Conn, where a Read may
+// return part of a message, or several. reader and writer may be the same
+// connection.
+//
+// When the stream reaches EOF the app is sent a SHUTDOWN, and when the app
+// writes a SHUTDOWN it is sent and the writer is closed.
+func Stream(reader io.ReadCloser, writer io.WriteCloser, app AppMsgRunner, unused uint32, name string, log bool) {
+	fromListener, toResponder := StreamListenerResponder(reader, writer, name, log)
+	app.Config(name, fromListener, toResponder)
+	go app.Run()
+}
+
+func StreamListenerResponder(reader io.ReadCloser, writer io.WriteCloser, name string, log bool) (MsgReader, MsgWriter) {
+	fromListener := NewChanReaderWriter(1000)
+	toResponder := NewChanReaderWriter(1000)
+	listener := newStreamListener(reader, fromListener, sameStream(reader, writer), name, log)
+	responder := newStreamResponder(writer, toResponder, name, log)
+	go listener.Run()
+	go responder.Run()
+	return fromListener, toResponder
+}
+
+func sameStream(reader io.ReadCloser, writer io.WriteCloser) bool {
+	w, ok := writer.(io.ReadCloser)
+	return ok && w == reader
+}
+
+type streamListener struct {
+	reader io.ReadCloser
+	r      *bufio.Reader
+	toApp  MsgWriter
+	shared bool // The reader is also the responder's writer, which the responder closes
+	name   string
+	log    bool
+}
+
+func newStreamListener(reader io.ReadCloser, toApp MsgWriter, shared bool, name string, log bool) *streamListener {
+	l := &streamListener{}
+	l.reader = reader
+	l.r = bufio.NewReaderSize(reader, streamBufSize)
+	l.toApp = toApp
+	l.shared = shared
+	l.name = name
+	l.log = log
+	return l
+}
+
+func (l *streamListener) Run() {
+	defer l.shutdown()
+	b := make([]byte, frameLen)
+	m := &msg.Message{}
+	for {
+		if err := readFrame(l.r, b, m); err != nil {
+			// The stream has ended, or can no longer be trusted to be framed correctly
+			if err != io.EOF && l.log {
+				println(l.name + ": " + err.Error())
+			}
+			l.toApp.Write(msg.Message{Kind: msg.SHUTDOWN})
+			return
+		}
+		shutdown := m.Kind == msg.SHUTDOWN
+		l.toApp.Write(*m)
+		if shutdown {
+			return
+		}
+	}
+}
+
+// Reads a single frame into m, using b as a buffer. io.EOF is only returned
+// if the stream ends between frames.
+func readFrame(r io.Reader, b []byte, m *msg.Message) error {
+	if _, err := io.ReadFull(r, b[:frameHeaderLen]); err != nil {
+		return err
+	}
+	if l := int(binary.LittleEndian.Uint16(b)); l != msg.ByteSize {
+		return errors.New(fmt.Sprintf("Bad frame length. Expecting %d, found %d", msg.ByteSize, l))
+	}
+	if _, err := io.ReadFull(r, b[frameHeaderLen:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return m.Unmarshal(b[frameHeaderLen:])
+}
+
+func (l *streamListener) shutdown() {
+	if !l.shared {
+		l.reader.Close()
+		return
+	}
+	// Stop reading, but leave the responder to finish writing
+	if cr, ok := l.reader.(interface {
+		CloseRead() error
+	}); ok {
+		cr.CloseRead()
+	}
+}
+
+type streamResponder struct {
+	writer  io.WriteCloser
+	w       *bufio.Writer
+	fromApp *ChanReaderWriter
+	failed  bool
+	name    string
+	log     bool
+}
+
+func newStreamResponder(writer io.WriteCloser, fromApp *ChanReaderWriter, name string, log bool) *streamResponder {
+	r := &streamResponder{}
+	r.writer = writer
+	r.w = bufio.NewWriterSize(writer, streamBufSize)
+	r.fromApp = fromApp
+	r.name = name
+	r.log = log
+	return r
+}
+
+// Messages are buffered while the app has more waiting, and flushed
+// as soon as it has none, coalescing bursts into fewer writes
+func (r *streamResponder) Run() {
+	defer r.shutdown()
+	m := &msg.Message{}
+	for {
+		select {
+		case *m = <-r.fromApp.inout:
+		default:
+			r.flush()
+			*m = r.fromApp.Read()
+		}
+		if r.log {
+			println(r.name + ": " + m.String())
+		}
+		shutdown := m.Kind == msg.SHUTDOWN
+		r.write(m)
+		if shutdown {
+			r.flush()
+			return
+		}
+	}
+}
+
+// Once a write has failed the app's messages are discarded, but still read
+// so that the app is never blocked writing them
+func (r *streamResponder) write(m *msg.Message) {
+	if r.failed {
+		return
+	}
+	var b [frameLen]byte
+	binary.LittleEndian.PutUint16(b[:], msg.ByteSize)
+	if err := m.Marshal(b[frameHeaderLen:]); err != nil {
+		panic(err.Error())
+	}
+	if _, err := r.w.Write(b[:]); err != nil {
+		r.fail(err)
+	}
+}
+
+func (r *streamResponder) flush() {
+	if r.failed || r.w.Buffered() == 0 {
+		return
+	}
+	if err := r.w.Flush(); err != nil {
+		r.fail(err)
+	}
+}
+
+func (r *streamResponder) fail(err error) {
+	if r.log {
+		println(r.name + ": " + err.Error())
+	}
+	r.failed = true
+}
+
+func (r *streamResponder) shutdown() {
+	r.writer.Close()
+}
@@ -0,0 +1,80 @@
+package coordinator
+
+import (
+	"github.com/fmstephe/matching_engine/msg"
+	"testing"
+)
+
+const (
+	mpscProducers = 4
+	mpscMsgs      = 10 * 1000
+)
+
+func TestMPSCManyWriters(t *testing.T) {
+	for _, wait := range []WaitStrategy{YIELD, PARK} {
+		// A small queue forces writers to wait for the reader
+		rw := NewMPSCReaderWriter(16, wait)
+		for p := 1; p <= mpscProducers; p++ {
+			go func(origin uint32) {
+				for i := 0; i < mpscMsgs; i++ {
+					rw.WriteFrom(origin, msg.Message{Kind: msg.BUY, TraderId: origin, TradeId: uint32(i)})
+				}
+			}(uint32(p))
+		}
+		expectFromEachOrigin(t, rw, mpscProducers, mpscMsgs)
+	}
+}
+
+func TestFanIn(t *testing.T) {
+	out := NewMPSCReaderWriter(16, PARK)
+	f := NewFanIn(out)
+	for p := 1; p <= mpscProducers; p++ {
+		in := NewChanReaderWriter(100)
+		f.Add(uint32(p), in)
+		go func(origin uint32, in MsgWriter) {
+			for i := 0; i < mpscMsgs; i++ {
+				in.Write(msg.Message{Kind: msg.BUY, TraderId: origin, TradeId: uint32(i)})
+			}
+			in.Write(msg.Message{Kind: msg.SHUTDOWN})
+		}(uint32(p), in)
+	}
+	expectFromEachOrigin(t, out, mpscProducers, mpscMsgs)
+	// Every source has shut down, and no SHUTDOWN was forwarded
+	f.Wait()
+	out.Write(msg.Message{Kind: msg.CANCEL})
+	if m := out.Read(); m.Kind != msg.CANCEL {
+		t.Errorf("Expecting CANCEL, found %v", &m)
+	}
+}
+
+func TestMPSCFrom(t *testing.T) {
+	rw := NewMPSCReaderWriter(4, SPIN)
+	rw.From(7).Write(msg.Message{Kind: msg.SELL})
+	rw.Write(msg.Message{Kind: msg.BUY})
+	if origin, m := rw.ReadFrom(); origin != 7 || m.Kind != msg.SELL {
+		t.Errorf("Expecting SELL from 7, found %v from %d", &m, origin)
+	}
+	if origin, m := rw.ReadFrom(); origin != 0 || m.Kind != msg.BUY {
+		t.Errorf("Expecting BUY from 0, found %v from %d", &m, origin)
+	}
+}
+
+// Each origin wrote msgs messages with ascending TradeIds, which must be read in the order written
+func expectFromEachOrigin(t *testing.T, rw *MPSCReaderWriter, origins, msgs int) {
+	next := make(map[uint32]uint32)
+	for i := 0; i < origins*msgs; i++ {
+		origin, m := rw.ReadFrom()
+		if m.TraderId != origin {
+			t.Fatalf("Expecting message from %d, found %v", origin, &m)
+		}
+		if m.TradeId != next[origin] {
+			t.Fatalf("Expecting trade %d from %d, found %v", next[origin], origin, &m)
+		}
+		next[origin]++
+	}
+	for p := 1; p <= origins; p++ {
+		if next[uint32(p)] != uint32(msgs) {
+			t.Errorf("Expecting %d messages from %d, found %d", msgs, p, next[uint32(p)])
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package coordinator
+
+import (
+	"github.com/fmstephe/matching_engine/msg"
+	"runtime"
+	"testing"
+)
+
+var waitStrategies = []WaitStrategy{SPIN, YIELD, PARK}
+
+// Each message carries its index in TradeId. SPIN only hands over to the
+// other side when preempted if there is a single CPU, so it gets fewer messages.
+func ringTestMsgs(wait WaitStrategy) []msg.Message {
+	n := 100 * 1000
+	if wait == SPIN && runtime.NumCPU() == 1 {
+		n = 200
+	}
+	ms := make([]msg.Message, n)
+	for i := range ms {
+		ms[i] = msg.Message{Kind: msg.BUY, Price: uint64(i), Amount: 1, TraderId: 1, TradeId: uint32(i), StockId: 1}
+	}
+	return ms
+}
+
+func TestRingSingle(t *testing.T) {
+	for _, wait := range waitStrategies {
+		// A small ring forces both sides to wait
+		rw := NewRingReaderWriter(8, wait)
+		ms := ringTestMsgs(wait)
+		go func() {
+			for i := range ms {
+				rw.Write(ms[i])
+			}
+		}()
+		for i := range ms {
+			if m := rw.Read(); m != ms[i] {
+				t.Fatalf("%v: Expecting %v, found %v", wait, &ms[i], &m)
+			}
+		}
+	}
+}
+
+func TestRingBatch(t *testing.T) {
+	for _, wait := range waitStrategies {
+		rw := NewRingReaderWriter(64, wait)
+		ms := ringTestMsgs(wait)
+		go func() {
+			// Uneven batches wrap around the end of the ring
+			for i := 0; i < len(ms); i += 37 {
+				end := i + 37
+				if end > len(ms) {
+					end = len(ms)
+				}
+				rw.WriteBatch(ms[i:end])
+			}
+		}()
+		buf := make([]msg.Message, 50)
+		for i := 0; i < len(ms); {
+			n := rw.ReadBatch(buf)
+			if n == 0 {
+				t.Fatalf("%v: ReadBatch returned no messages", wait)
+			}
+			for j := 0; j < n; j++ {
+				if buf[j] != ms[i+j] {
+					t.Fatalf("%v: Expecting %v, found %v", wait, &ms[i+j], &buf[j])
+				}
+			}
+			i += n
+		}
+	}
+}
+
+func TestRingSize(t *testing.T) {
+	rw := NewRingReaderWriter(5, SPIN)
+	if len(rw.ring) != 8 {
+		t.Errorf("Expecting ring of 8, found %d", len(rw.ring))
+	}
+	// A full ring can be written without a reader
+	for i := 0; i < 8; i++ {
+		rw.Write(msg.Message{TradeId: uint32(i)})
+	}
+	buf := make([]msg.Message, 20)
+	if n := rw.ReadBatch(buf); n != 8 {
+		t.Errorf("Expecting to read 8 messages, found %d", n)
+	}
+}
@@ -0,0 +1,121 @@
+package coordinator
+
+import (
+	"encoding/binary"
+	. "github.com/fmstephe/matching_engine/msg"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Returns both ends of a loopback TCP connection
+func loopback(t *testing.T) (client, server net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer l.Close()
+	client, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server, err = l.Accept()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return client, server
+}
+
+func frame(m *Message) []byte {
+	b := make([]byte, frameLen)
+	binary.LittleEndian.PutUint16(b, ByteSize)
+	m.Marshal(b[frameHeaderLen:])
+	return b
+}
+
+func TestStreamEcho(t *testing.T) {
+	client, server := loopback(t)
+	defer client.Close()
+	complete := make(chan bool)
+	Stream(client, client, newEchoClient(complete), clientOriginId, "Client", false)
+	Stream(server, server, &echoServer{}, serverOriginId, "Server", false)
+	select {
+	case <-complete:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for echoes")
+	}
+}
+
+// Messages split across, and packed into, arbitrary reads are all received
+func TestStreamPartialReads(t *testing.T) {
+	client, server := loopback(t)
+	defer client.Close()
+	in, out := StreamListenerResponder(server, server, "Server", false)
+	var b []byte
+	for i := uint32(1); i <= 100; i++ {
+		b = append(b, frame(&Message{Kind: SELL, TraderId: 1, TradeId: i, StockId: 1, Price: 7, Amount: 1})...)
+	}
+	go func() {
+		for len(b) > 0 {
+			n := 1 + len(b)%(frameLen+7)
+			if n > len(b) {
+				n = len(b)
+			}
+			client.Write(b[:n])
+			b = b[n:]
+		}
+	}()
+	for i := uint32(1); i <= 100; i++ {
+		if m := in.Read(); m.TradeId != i {
+			t.Fatalf("Expecting TradeId %d, found %v", i, &m)
+		}
+	}
+	out.Write(Message{Kind: SHUTDOWN})
+}
+
+func TestStreamShutdownOnEOF(t *testing.T) {
+	client, server := loopback(t)
+	in, out := StreamListenerResponder(server, server, "Server", false)
+	sent := Message{Kind: BUY, TraderId: 1, TradeId: 1, StockId: 1, Price: 7, Amount: 1}
+	client.Write(frame(&sent))
+	// A partial frame before EOF is discarded
+	client.Write(frame(&sent)[:10])
+	client.(*net.TCPConn).CloseWrite()
+	if m := in.Read(); m != sent {
+		t.Errorf("Expecting %v, found %v", &sent, &m)
+	}
+	if m := in.Read(); m.Kind != SHUTDOWN {
+		t.Errorf("Expecting SHUTDOWN, found %v", &m)
+	}
+	// The responder still sends everything written before the app's SHUTDOWN
+	for i := 0; i < 10; i++ {
+		out.Write(sent)
+	}
+	out.Write(Message{Kind: SHUTDOWN})
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, frameLen)
+	m := &Message{}
+	for i := 0; i < 10; i++ {
+		if err := readFrame(client, b, m); err != nil || *m != sent {
+			t.Fatalf("Expecting %v, found %v %v", &sent, m, err)
+		}
+	}
+	if err := readFrame(client, b, m); err != nil || m.Kind != SHUTDOWN {
+		t.Errorf("Expecting SHUTDOWN, found %v %v", m, err)
+	}
+	if err := readFrame(client, b, m); err != io.EOF {
+		t.Errorf("Expecting EOF, found %v", err)
+	}
+}
+
+func TestStreamBadFrame(t *testing.T) {
+	client, server := loopback(t)
+	defer client.Close()
+	in, out := StreamListenerResponder(server, server, "Server", false)
+	client.Write([]byte{ByteSize + 1, 0})
+	if m := in.Read(); m.Kind != SHUTDOWN {
+		t.Errorf("Expecting SHUTDOWN, found %v", &m)
+	}
+	out.Write(Message{Kind: SHUTDOWN})
+}
@@ -0,0 +1,80 @@
+package coordinator
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// How a queue waits for space to write, or messages to read
+type WaitStrategy int
+
+const (
+	SPIN  = WaitStrategy(iota) // Busy wait. Lowest latency, but burns a core
+	YIELD = WaitStrategy(iota) // Busy wait, yielding to the scheduler between attempts
+	PARK  = WaitStrategy(iota) // Spin briefly, then sleep until woken by the other side
+)
+
+func (w WaitStrategy) String() string {
+	switch w {
+	case SPIN:
+		return "SPIN"
+	case YIELD:
+		return "YIELD"
+	case PARK:
+		return "PARK"
+	}
+	return "UNKNOWN"
+}
+
+const parkSpins = 100 // Attempts made by PARK before sleeping
+
+// Waits for a sequence to advance, using a WaitStrategy
+type waiter struct {
+	wait WaitStrategy
+	// Used by PARK
+	parked int32
+	mutex  sync.Mutex
+	cond   *sync.Cond
+}
+
+func (wt *waiter) init(wait WaitStrategy) {
+	wt.wait = wait
+	wt.cond = sync.NewCond(&wt.mutex)
+}
+
+// Waits until seq reaches target, returning the value of seq
+func (wt *waiter) await(seq *int64, target int64) int64 {
+	for i := 0; ; i++ {
+		if v := atomic.LoadInt64(seq); v >= target {
+			return v
+		}
+		switch wt.wait {
+		case YIELD:
+			runtime.Gosched()
+		case PARK:
+			if i >= parkSpins {
+				wt.park(seq, target)
+			}
+		}
+	}
+}
+
+func (wt *waiter) park(seq *int64, target int64) {
+	wt.mutex.Lock()
+	atomic.AddInt32(&wt.parked, 1)
+	for atomic.LoadInt64(seq) < target {
+		wt.cond.Wait()
+	}
+	atomic.AddInt32(&wt.parked, -1)
+	wt.mutex.Unlock()
+}
+
+// Wakes anyone parked, to check whether the sequence they await has advanced
+func (wt *waiter) notify() {
+	if wt.wait == PARK && atomic.LoadInt32(&wt.parked) > 0 {
+		wt.mutex.Lock()
+		wt.cond.Broadcast()
+		wt.mutex.Unlock()
+	}
+}
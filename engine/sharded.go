@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/msg"
+)
+
+// Written by a shard after it has finished processing each message
+var doneMarker = msg.Message{Kind: msg.NO_KIND}
+
+// Routes messages to the merger, broadcast messages are processed by every shard
+const broadcast = -1
+
+// Runs several matchers, each on its own goroutine, with the books
+// partitioned between them by StockId. The outputs are merged so they appear
+// in exactly the order a single matcher would have produced them.
+//
+// The control messages, SHUTDOWN and NEW_TRADER, are broadcast to every
+// shard. The merger waits until every shard has processed a control message
+// before moving on to the next message, and a single SHUTDOWN is written once
+// every shard has shut down. Any other message without a StockId belongs to
+// no shard's books and is REJECTED.
+type Sharded struct {
+	coordinator.AppMsgHelper
+	shards []*shard
+	routes chan int
+	done   chan bool
+}
+
+type shard struct {
+	m   *matcher.M
+	in  *coordinator.ChanReaderWriter
+	out *coordinator.ChanReaderWriter
+}
+
+// Creates a Sharded engine with shardCount shards, each with a matcher
+// created by matcher.NewMatcher(slabSize)
+func NewSharded(shardCount, slabSize int) *Sharded {
+	if shardCount < 1 {
+		panic("A sharded engine needs at least one shard")
+	}
+	shards := make([]*shard, shardCount)
+	for i := range shards {
+		sh := &shard{
+			m:   matcher.NewMatcher(slabSize),
+			in:  coordinator.NewChanReaderWriter(1024),
+			out: coordinator.NewChanReaderWriter(1024),
+		}
+		sh.m.Config("Shard", coordinator.NewNoopReaderWriter(), sh.out)
+		shards[i] = sh
+	}
+	return &Sharded{shards: shards, routes: make(chan int, 1024), done: make(chan bool)}
+}
+
+// The matcher owning the books for stockId. It must not be used while the engine is running.
+func (s *Sharded) Matcher(stockId uint64) *matcher.M {
+	return s.shards[s.shardFor(stockId)].m
+}
+
+func (s *Sharded) shardFor(stockId uint64) int {
+	return int(stockId % uint64(len(s.shards)))
+}
+
+// Routes messages to the shards until a SHUTDOWN is read.
+// Returns once every shard has shut down and all outputs have been written.
+func (s *Sharded) Run() {
+	for _, sh := range s.shards {
+		go sh.run()
+	}
+	go s.merge()
+	for {
+		m := s.In.Read()
+		if m.Kind == msg.SHUTDOWN || m.Kind == msg.NEW_TRADER {
+			s.routes <- broadcast
+			for _, sh := range s.shards {
+				sh.in.Write(m)
+			}
+		} else {
+			idx := s.shardFor(m.StockId)
+			s.routes <- idx
+			s.shards[idx].in.Write(m)
+		}
+		if m.Kind == msg.SHUTDOWN {
+			<-s.done
+			return
+		}
+	}
+}
+
+func (s *Sharded) merge() {
+	defer close(s.done)
+	for idx := range s.routes {
+		if idx != broadcast {
+			s.drain(s.shards[idx])
+			continue
+		}
+		shutdown := false
+		for _, sh := range s.shards {
+			shutdown = s.drain(sh)
+		}
+		if shutdown {
+			s.Out.Write(msg.Message{Kind: msg.SHUTDOWN})
+			return
+		}
+	}
+}
+
+// Writes the outputs of sh's current message. Returns true if sh has shut down.
+func (s *Sharded) drain(sh *shard) bool {
+	for {
+		m := sh.out.Read()
+		switch {
+		case m == doneMarker:
+			return false
+		case m.Kind == msg.SHUTDOWN:
+			return true
+		default:
+			s.Out.Write(m)
+		}
+	}
+}
+
+func (sh *shard) run() {
+	for {
+		m := sh.in.Read()
+		if m.Kind == msg.SHUTDOWN {
+			sh.out.Write(m)
+			return
+		}
+		if m.StockId == 0 && m.Kind != msg.NEW_TRADER {
+			// Routed to the first shard, so the rejection is merged in order
+			m.Kind = msg.REJECTED
+			sh.out.Write(m)
+		} else {
+			sh.m.Submit(&m)
+		}
+		sh.out.Write(doneMarker)
+	}
+}
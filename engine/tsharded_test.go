@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/msg"
+	"testing"
+)
+
+var shardedMaker = msg.NewMessageMaker(1)
+
+// Interleaves random trade sets for several stocks, with some new traders mixed in
+func multiStockMsgs(t *testing.T, stocks int) []msg.Message {
+	sets := make([][]msg.Message, stocks)
+	for i := range sets {
+		set, err := shardedMaker.RndTradeSet(200, 20, 1, 30)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for j := range set {
+			set[j].StockId = uint64(i + 1)
+		}
+		sets[i] = set
+	}
+	ms := make([]msg.Message, 0)
+	for j := range sets[0] {
+		for i := range sets {
+			ms = append(ms, sets[i][j])
+		}
+		if j%100 == 0 {
+			nt := msg.Message{}
+			nt.WriteNewTrader(uint32(j + 1))
+			ms = append(ms, nt)
+		}
+	}
+	return ms
+}
+
+func drain(r coordinator.MsgReader) []msg.Message {
+	ms := make([]msg.Message, 0)
+	for {
+		m := r.Read()
+		ms = append(ms, m)
+		if m.Kind == msg.SHUTDOWN {
+			return ms
+		}
+	}
+}
+
+func TestShardedMatchesSingleMatcher(t *testing.T) {
+	ms := multiStockMsgs(t, 7)
+	single := matcher.NewMatcher(100)
+	singleOut := coordinator.NewChanReaderWriter(len(ms) * 4)
+	single.Config("Single", coordinator.NewPreloadedReaderWriter(ms), singleOut)
+	go single.Run()
+	expected := drain(singleOut)
+	for _, shardCount := range []int{1, 2, 3, 8} {
+		sharded := NewSharded(shardCount, 100)
+		out := coordinator.NewChanReaderWriter(1)
+		sharded.Config("Sharded", coordinator.NewPreloadedReaderWriter(ms), out)
+		go sharded.Run()
+		found := drain(out)
+		if len(expected) != len(found) {
+			t.Errorf("%d shards: expecting %d messages, found %d", shardCount, len(expected), len(found))
+			continue
+		}
+		for i := range expected {
+			if expected[i] != found[i] {
+				t.Errorf("%d shards: expecting %v, found %v", shardCount, &expected[i], &found[i])
+				break
+			}
+		}
+	}
+}
+
+func TestShardedShutdownWaitsForShards(t *testing.T) {
+	sharded := NewSharded(4, 100)
+	in := coordinator.NewChanReaderWriter(10)
+	out := coordinator.NewChanReaderWriter(10)
+	sharded.Config("Sharded", in, out)
+	done := make(chan bool)
+	go func() {
+		sharded.Run()
+		done <- true
+	}()
+	in.Write(msg.Message{Kind: msg.SELL, Price: 7, Amount: 1, TraderId: 1, TradeId: 1, StockId: 3})
+	in.Write(msg.Message{Kind: msg.BUY, Price: 7, Amount: 1, TraderId: 2, TradeId: 1, StockId: 3})
+	in.Write(msg.Message{Kind: msg.SHUTDOWN})
+	<-done
+	found := drain(out)
+	if len(found) != 3 || found[0].Kind != msg.FULL || found[1].Kind != msg.FULL {
+		t.Errorf("Unexpected outputs %v", found)
+	}
+}
+
+func TestShardedRejectsMissingStockId(t *testing.T) {
+	sharded := NewSharded(3, 100)
+	in := coordinator.NewChanReaderWriter(10)
+	out := coordinator.NewChanReaderWriter(10)
+	sharded.Config("Sharded", in, out)
+	go sharded.Run()
+	nt := msg.Message{}
+	nt.WriteNewTrader(1)
+	in.Write(nt)
+	in.Write(msg.Message{Kind: msg.BUY, Price: 7, Amount: 1, TraderId: 1, TradeId: 1})
+	in.Write(msg.Message{Kind: msg.SELL, Price: 7, Amount: 1, TraderId: 2, TradeId: 1, StockId: 2})
+	in.Write(msg.Message{Kind: msg.BUY, Price: 7, Amount: 1, TraderId: 1, TradeId: 2, StockId: 2})
+	in.Write(msg.Message{Kind: msg.SHUTDOWN})
+	found := drain(out)
+	expected := []msg.Message{
+		{Kind: msg.REJECTED, Price: 7, Amount: 1, TraderId: 1, TradeId: 1},
+		{Kind: msg.FULL, Price: 7, Amount: 1, TraderId: 1, TradeId: 2, StockId: 2},
+		{Kind: msg.FULL, Price: 7, Amount: 1, TraderId: 2, TradeId: 1, StockId: 2},
+		{Kind: msg.SHUTDOWN},
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("Expecting %v, found %v", expected, found)
+	}
+	for i := range expected {
+		if expected[i] != found[i] {
+			t.Errorf("Expecting %v, found %v", &expected[i], &found[i])
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package fix
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fmstephe/matching_engine/msg"
+	"strconv"
+	"sync"
+)
+
+const (
+	sideBuy  = "1"
+	sideSell = "2"
+	ordLimit = "2"
+)
+
+// ExecType and OrdStatus values
+const (
+	statusNew       = "0"
+	statusPartial   = "1"
+	statusFilled    = "2"
+	statusCancelled = "4"
+	statusReplaced  = "5"
+	statusRejected  = "8"
+	execTrade       = "F" // FIX 4.4 only, FIX 4.2 reports the order's status
+)
+
+// OrdRejReason and CxlRejReason values
+const (
+	rejectOther        = 0 // Broker option, or too late to cancel
+	rejectUnknown      = 1 // Unknown symbol, or unknown order
+	rejectPending      = 3 // Already pending cancel or replace
+	rejectDuplicate    = 6 // Duplicate order
+	cxlRejResponseCxl  = "1"
+	cxlRejResponseRepl = "2"
+)
+
+type order struct {
+	orderID     string
+	clOrdID     string
+	origClOrdID string
+	symbol      string
+	stockId     uint64
+	side        string
+	qty         uint64
+	cumQty      uint64
+	price       uint64 // In ticks
+	cost        uint64 // The sum of price*shares for every execution
+}
+
+func (o *order) status() string {
+	switch {
+	case o.cumQty >= o.qty:
+		return statusFilled
+	case o.cumQty > 0:
+		return statusPartial
+	}
+	return statusNew
+}
+
+// A cancel or replace waiting for the matcher to cancel the order
+type cancelRequest struct {
+	clOrdID string
+	replace bool
+	qty     uint64
+	price   uint64
+	o       *order
+}
+
+// The state of a single SenderCompID, which trades as a single TraderId
+type account struct {
+	g           *Gateway
+	compID      string
+	traderId    uint32
+	s           *session // nil while logged out, reports are then dropped
+	nextTradeId uint32
+	orders      map[uint32]*order
+	clOrdIDs    map[string]uint32 // The TradeId of each live order
+	cancels     map[uint32]*cancelRequest
+	lock        sync.Mutex
+}
+
+func newAccount(g *Gateway, compID string) *account {
+	a := &account{
+		g:           g,
+		compID:      compID,
+		nextTradeId: 1,
+		orders:      make(map[uint32]*order),
+		clOrdIDs:    make(map[string]uint32),
+		cancels:     make(map[uint32]*cancelRequest),
+	}
+	a.traderId = g.hub.Join(a)
+	return a
+}
+
+func (a *account) detach(s *session) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.s == s {
+		a.s = nil
+	}
+}
+
+func (a *account) newOrder(m *Message) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	clOrdID, _ := m.Get(tagClOrdID)
+	symbol, _ := m.Get(tagSymbol)
+	side, _ := m.Get(tagSide)
+	o := &order{orderID: "NONE", clOrdID: clOrdID, symbol: symbol, side: side}
+	if clOrdID == "" {
+		a.rejectOrder(o, rejectOther, "ClOrdID missing")
+		return
+	}
+	if _, ok := a.clOrdIDs[clOrdID]; ok {
+		a.rejectOrder(o, rejectDuplicate, "Duplicate ClOrdID")
+		return
+	}
+	stockId, ok := a.g.cfg.Symbols[symbol]
+	if !ok {
+		a.rejectOrder(o, rejectUnknown, "Unknown Symbol")
+		return
+	}
+	o.stockId = stockId
+	if side != sideBuy && side != sideSell {
+		a.rejectOrder(o, rejectOther, "Side must be buy or sell")
+		return
+	}
+	if ordType, _ := m.Get(tagOrdType); ordType != ordLimit {
+		a.rejectOrder(o, rejectOther, "Only limit orders are supported")
+		return
+	}
+	var err error
+	if o.qty, o.price, err = a.qtyPrice(m); err != nil {
+		a.rejectOrder(o, rejectOther, err.Error())
+		return
+	}
+	tradeId := a.add(o)
+	o.orderID = fmt.Sprintf("%d.%d", a.traderId, tradeId)
+	a.send(a.report(o, statusNew, statusNew))
+	a.g.hub.Submit(a.message(o, tradeId))
+}
+
+func (a *account) qtyPrice(m *Message) (qty, price uint64, err error) {
+	qtyStr, _ := m.Get(tagOrderQty)
+	qty, err = strconv.ParseUint(qtyStr, 10, 64)
+	if err != nil || qty == 0 {
+		return 0, 0, errors.New(fmt.Sprintf("Bad OrderQty %q", qtyStr))
+	}
+	priceStr, _ := m.Get(tagPrice)
+	price, err = parsePrice(priceStr, a.g.cfg.PriceDecimals)
+	if err != nil {
+		return 0, 0, err
+	}
+	if price == 0 {
+		return 0, 0, errors.New("Price must be positive")
+	}
+	return qty, price, nil
+}
+
+// Cancels, or cancels and replaces, a live order. A replaced order loses its time priority.
+func (a *account) cancel(m *Message, replace bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	clOrdID, _ := m.Get(tagClOrdID)
+	origClOrdID, _ := m.Get(tagOrigClOrdID)
+	req := &cancelRequest{clOrdID: clOrdID, replace: replace}
+	tradeId, ok := a.clOrdIDs[origClOrdID]
+	if !ok {
+		a.cancelReject(req, &order{orderID: "NONE", clOrdID: origClOrdID}, statusRejected, rejectUnknown, "Unknown order")
+		return
+	}
+	o := a.orders[tradeId]
+	req.o = o
+	if _, ok := a.cancels[tradeId]; ok {
+		a.cancelReject(req, o, o.status(), rejectPending, "Cancel or replace already pending")
+		return
+	}
+	if _, ok := a.clOrdIDs[clOrdID]; ok || clOrdID == "" {
+		a.cancelReject(req, o, o.status(), rejectOther, "ClOrdID missing or duplicated")
+		return
+	}
+	if replace {
+		var err error
+		if req.qty, req.price, err = a.qtyPrice(m); err != nil {
+			a.cancelReject(req, o, o.status(), rejectOther, err.Error())
+			return
+		}
+		if req.qty <= o.cumQty {
+			a.cancelReject(req, o, o.status(), rejectOther, "OrderQty must exceed CumQty")
+			return
+		}
+	}
+	a.cancels[tradeId] = req
+	cm := a.message(o, tradeId)
+	cm.Kind = msg.CANCEL
+	a.g.hub.Submit(cm)
+}
+
+// Receives the matcher's outputs for this account's orders
+func (a *account) Deliver(m *msg.Message) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	switch m.Kind {
+	case msg.FULL, msg.PARTIAL:
+		o := a.orders[m.TradeId]
+		if o == nil {
+			return
+		}
+		o.cumQty += m.Amount
+		o.cost += m.Price * m.Amount
+		execType := execTrade
+		if a.s != nil && a.s.begin == FIX42 {
+			execType = o.status()
+		}
+		r := a.report(o, execType, o.status())
+		r.SetInt(tagLastShares, m.Amount).Set(tagLastPx, formatPrice(m.Price, a.g.cfg.PriceDecimals))
+		a.send(r)
+		if o.status() == statusFilled {
+			a.remove(m.TradeId, o)
+		}
+	case msg.CANCELLED:
+		req := a.cancels[m.TradeId]
+		delete(a.cancels, m.TradeId)
+		o := a.orders[m.TradeId]
+		if o == nil {
+			return
+		}
+		a.remove(m.TradeId, o)
+		if req != nil && req.replace {
+			a.replaced(o, req)
+			return
+		}
+		if req != nil {
+			o.origClOrdID, o.clOrdID = o.clOrdID, req.clOrdID
+		}
+		a.send(a.report(o, statusCancelled, statusCancelled))
+	case msg.NOT_CANCELLED:
+		req := a.cancels[m.TradeId]
+		delete(a.cancels, m.TradeId)
+		if req == nil {
+			return
+		}
+		a.cancelReject(req, req.o, req.o.status(), rejectOther, "Too late to cancel")
+	case msg.REJECTED:
+		o := a.orders[m.TradeId]
+		if o == nil {
+			return
+		}
+		a.remove(m.TradeId, o)
+		a.rejectOrder(o, rejectOther, "Price is outside the book's band")
+	}
+}
+
+// Adds the replacement for o, which the matcher has cancelled
+func (a *account) replaced(o *order, req *cancelRequest) {
+	no := *o
+	no.origClOrdID, no.clOrdID = o.clOrdID, req.clOrdID
+	no.qty, no.price = req.qty, req.price
+	status := no.status()
+	if a.s != nil && a.s.begin == FIX42 {
+		status = statusReplaced
+	}
+	if no.status() == statusFilled {
+		// Executions while the replace was pending have filled the new quantity
+		a.send(a.report(&no, statusReplaced, statusFilled))
+		return
+	}
+	tradeId := a.add(&no)
+	a.send(a.report(&no, statusReplaced, status))
+	a.g.hub.Submit(a.message(&no, tradeId))
+}
+
+func (a *account) add(o *order) uint32 {
+	tradeId := a.nextTradeId
+	a.nextTradeId++
+	a.orders[tradeId] = o
+	a.clOrdIDs[o.clOrdID] = tradeId
+	return tradeId
+}
+
+func (a *account) remove(tradeId uint32, o *order) {
+	delete(a.orders, tradeId)
+	delete(a.clOrdIDs, o.clOrdID)
+}
+
+// The message submitting the unfilled part of o to the matcher
+func (a *account) message(o *order, tradeId uint32) msg.Message {
+	kind := msg.BUY
+	if o.side == sideSell {
+		kind = msg.SELL
+	}
+	return msg.Message{Kind: kind, Price: o.price, Amount: o.qty - o.cumQty, StockId: o.stockId, TraderId: a.traderId, TradeId: tradeId}
+}
+
+func (a *account) report(o *order, execType, ordStatus string) *Message {
+	r := NewMessage(msgExecutionReport)
+	r.Set(tagOrderID, o.orderID).Set(tagClOrdID, o.clOrdID)
+	if o.origClOrdID != "" {
+		r.Set(tagOrigClOrdID, o.origClOrdID)
+	}
+	r.Set(tagExecID, a.g.nextExecId())
+	if a.s != nil && a.s.begin == FIX42 {
+		r.Set(tagExecTransType, "0")
+	}
+	r.Set(tagExecType, execType).Set(tagOrdStatus, ordStatus)
+	r.Set(tagSymbol, o.symbol).Set(tagSide, o.side)
+	r.SetInt(tagOrderQty, o.qty).Set(tagPrice, formatPrice(o.price, a.g.cfg.PriceDecimals))
+	leaves := uint64(0)
+	live := ordStatus == statusNew || ordStatus == statusPartial || ordStatus == statusReplaced
+	if live && o.qty > o.cumQty {
+		leaves = o.qty - o.cumQty
+	}
+	r.SetInt(tagLeavesQty, leaves).SetInt(tagCumQty, o.cumQty)
+	r.Set(tagAvgPx, formatAvgPrice(o.cost, o.cumQty, a.g.cfg.PriceDecimals))
+	return r
+}
+
+func (a *account) rejectOrder(o *order, reason int, text string) {
+	r := a.report(o, statusRejected, statusRejected)
+	r.Set(tagOrdRejReason, strconv.Itoa(reason)).Set(tagText, text)
+	a.send(r)
+}
+
+func (a *account) cancelReject(req *cancelRequest, o *order, ordStatus string, reason int, text string) {
+	r := NewMessage(msgOrderCancelReject)
+	r.Set(tagOrderID, o.orderID).Set(tagClOrdID, req.clOrdID).Set(tagOrigClOrdID, o.clOrdID)
+	r.Set(tagOrdStatus, ordStatus)
+	responseTo := cxlRejResponseCxl
+	if req.replace {
+		responseTo = cxlRejResponseRepl
+	}
+	r.Set(tagCxlRejResponseTo, responseTo).Set(tagCxlRejReason, strconv.Itoa(reason)).Set(tagText, text)
+	a.send(r)
+}
+
+func (a *account) send(m *Message) {
+	if a.s != nil {
+		a.s.send(m)
+	}
+}
@@ -0,0 +1,113 @@
+package fix
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fmstephe/matching_engine/gateway"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+type Config struct {
+	CompID        string            // The gateway's CompID, clients must use it as their TargetCompID
+	Symbols       map[string]uint64 // The StockId of each symbol which can be traded
+	PriceDecimals int               // Prices are submitted as integers with this many implied decimal places
+}
+
+// A FIX 4.2 and 4.4 order entry gateway, accepting sessions over TCP.
+//
+// Each SenderCompID is a trader, keeping its TraderId and live orders between
+// sessions. A session may only be logged on once at a time, and both sides'
+// sequence numbers start at 1 for each session. Messages are not stored, so
+// a sequence gap ends the session rather than requesting a resend.
+type Gateway struct {
+	hub      *gateway.Hub
+	cfg      Config
+	lock     sync.Mutex
+	accounts map[string]*account
+	conns    map[net.Conn]bool
+	listener net.Listener
+	running  sync.WaitGroup
+	execId   uint64
+}
+
+func NewGateway(hub *gateway.Hub, cfg Config) *Gateway {
+	return &Gateway{hub: hub, cfg: cfg, accounts: make(map[string]*account), conns: make(map[net.Conn]bool)}
+}
+
+// Starts accepting sessions on addr, e.g. "127.0.0.1:0"
+func (g *Gateway) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	g.listener = l
+	g.running.Add(1)
+	go g.accept()
+	return nil
+}
+
+func (g *Gateway) Addr() net.Addr {
+	return g.listener.Addr()
+}
+
+// Stops accepting sessions, disconnects every session and waits for them to end
+func (g *Gateway) Close() {
+	g.listener.Close()
+	g.lock.Lock()
+	for conn := range g.conns {
+		conn.Close()
+	}
+	g.lock.Unlock()
+	g.running.Wait()
+}
+
+func (g *Gateway) accept() {
+	defer g.running.Done()
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			return
+		}
+		g.lock.Lock()
+		g.conns[conn] = true
+		g.lock.Unlock()
+		g.running.Add(1)
+		go g.serve(conn)
+	}
+}
+
+func (g *Gateway) serve(conn net.Conn) {
+	defer g.running.Done()
+	defer func() {
+		conn.Close()
+		g.lock.Lock()
+		delete(g.conns, conn)
+		g.lock.Unlock()
+	}()
+	newSession(g, conn).run()
+}
+
+// Logs compID on with s, sending reply before anything else can be sent to s
+func (g *Gateway) attach(compID string, s *session, reply *Message) (*account, error) {
+	g.lock.Lock()
+	a := g.accounts[compID]
+	if a == nil {
+		a = newAccount(g, compID)
+		g.accounts[compID] = a
+	}
+	g.lock.Unlock()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.s != nil {
+		return nil, errors.New(fmt.Sprintf("%s is already logged on", compID))
+	}
+	a.s = s
+	s.send(reply)
+	return a, nil
+}
+
+func (g *Gateway) nextExecId() string {
+	return fmt.Sprintf("%d", atomic.AddUint64(&g.execId, 1))
+}
@@ -0,0 +1,254 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const soh = '\x01'
+
+const (
+	FIX42 = "FIX.4.2"
+	FIX44 = "FIX.4.4"
+)
+
+// The tags used by the gateway
+const (
+	tagAvgPx               = 6
+	tagBeginString         = 8
+	tagBodyLength          = 9
+	tagCheckSum            = 10
+	tagClOrdID             = 11
+	tagCumQty              = 14
+	tagExecID              = 17
+	tagExecTransType       = 20
+	tagLastPx              = 31
+	tagLastShares          = 32
+	tagMsgSeqNum           = 34
+	tagMsgType             = 35
+	tagOrderID             = 37
+	tagOrderQty            = 38
+	tagOrdStatus           = 39
+	tagOrdType             = 40
+	tagOrigClOrdID         = 41
+	tagPrice               = 44
+	tagRefSeqNum           = 45
+	tagSenderCompID        = 49
+	tagSendingTime         = 52
+	tagSide                = 54
+	tagSymbol              = 55
+	tagTargetCompID        = 56
+	tagText                = 58
+	tagEncryptMethod       = 98
+	tagCxlRejReason        = 102
+	tagOrdRejReason        = 103
+	tagHeartBtInt          = 108
+	tagTestReqID           = 112
+	tagExecType            = 150
+	tagLeavesQty           = 151
+	tagSessionRejectReason = 373
+	tagCxlRejResponseTo    = 434
+)
+
+// The message types used by the gateway
+const (
+	msgHeartbeat          = "0"
+	msgTestRequest        = "1"
+	msgReject             = "3"
+	msgLogout             = "5"
+	msgExecutionReport    = "8"
+	msgOrderCancelReject  = "9"
+	msgLogon              = "A"
+	msgNewOrderSingle     = "D"
+	msgOrderCancelRequest = "F"
+	msgCancelReplace      = "G"
+)
+
+type field struct {
+	tag   int
+	value string
+}
+
+// A FIX message, as an ordered list of fields. BeginString, BodyLength and
+// CheckSum are added when the message is encoded, and removed when it is read.
+type Message struct {
+	fields []field
+}
+
+func NewMessage(msgType string) *Message {
+	m := &Message{}
+	return m.Set(tagMsgType, msgType)
+}
+
+func (m *Message) Type() string {
+	t, _ := m.Get(tagMsgType)
+	return t
+}
+
+func (m *Message) Get(tag int) (string, bool) {
+	for _, f := range m.fields {
+		if f.tag == tag {
+			return f.value, true
+		}
+	}
+	return "", false
+}
+
+// Sets the value of tag, adding the field if it is not already present
+func (m *Message) Set(tag int, value string) *Message {
+	for i := range m.fields {
+		if m.fields[i].tag == tag {
+			m.fields[i].value = value
+			return m
+		}
+	}
+	m.fields = append(m.fields, field{tag: tag, value: value})
+	return m
+}
+
+func (m *Message) SetInt(tag int, value uint64) *Message {
+	return m.Set(tag, strconv.FormatUint(value, 10))
+}
+
+func (m *Message) String() string {
+	return strings.Replace(string(m.Encode(FIX44)), string(soh), "|", -1)
+}
+
+// Written at the start of the body, in this order, ahead of every other field
+var headerTags = []int{tagMsgType, tagSenderCompID, tagTargetCompID, tagMsgSeqNum, tagSendingTime}
+
+func isHeader(tag int) bool {
+	for _, h := range headerTags {
+		if tag == h {
+			return true
+		}
+	}
+	return false
+}
+
+// Encodes m with begin as its BeginString. MsgType and the other header fields
+// are written first, followed by the remaining fields in the order they were set.
+func (m *Message) Encode(begin string) []byte {
+	var body bytes.Buffer
+	for _, tag := range headerTags {
+		if v, ok := m.Get(tag); ok {
+			writeField(&body, tag, v)
+		}
+	}
+	for _, f := range m.fields {
+		if !isHeader(f.tag) {
+			writeField(&body, f.tag, f.value)
+		}
+	}
+	var b bytes.Buffer
+	writeField(&b, tagBeginString, begin)
+	writeField(&b, tagBodyLength, strconv.Itoa(body.Len()))
+	b.Write(body.Bytes())
+	writeField(&b, tagCheckSum, fmt.Sprintf("%03d", checkSum(b.Bytes())))
+	return b.Bytes()
+}
+
+func writeField(b *bytes.Buffer, tag int, value string) {
+	b.WriteString(strconv.Itoa(tag))
+	b.WriteByte('=')
+	b.WriteString(value)
+	b.WriteByte(soh)
+}
+
+func checkSum(bs []byte) int {
+	sum := 0
+	for _, b := range bs {
+		sum += int(b)
+	}
+	return sum % 256
+}
+
+const maxBodyLength = 64 * 1024
+
+// Reads the next message from r, returning its BeginString
+func ReadMessage(r *bufio.Reader) (begin string, m *Message, err error) {
+	var raw bytes.Buffer
+	begin, err = readField(r, &raw, tagBeginString)
+	if err != nil {
+		return "", nil, err
+	}
+	lenStr, err := readField(r, &raw, tagBodyLength)
+	if err != nil {
+		return "", nil, err
+	}
+	bodyLen, err := strconv.Atoi(lenStr)
+	if err != nil || bodyLen <= 0 || bodyLen > maxBodyLength {
+		return "", nil, errors.New(fmt.Sprintf("Bad BodyLength %q", lenStr))
+	}
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return "", nil, err
+	}
+	raw.Write(body)
+	sumStr, err := readField(r, nil, tagCheckSum)
+	if err != nil {
+		return "", nil, err
+	}
+	if sum, err := strconv.Atoi(sumStr); err != nil || sum != checkSum(raw.Bytes()) {
+		return "", nil, errors.New(fmt.Sprintf("Bad CheckSum %q, expecting %03d", sumStr, checkSum(raw.Bytes())))
+	}
+	m, err = parseBody(body)
+	if err != nil {
+		return "", nil, err
+	}
+	return begin, m, nil
+}
+
+// Reads a single tag=value field, which must have the expected tag
+func readField(r *bufio.Reader, raw *bytes.Buffer, expected int) (string, error) {
+	f, err := r.ReadString(soh)
+	if err != nil {
+		return "", err
+	}
+	if raw != nil {
+		raw.WriteString(f)
+	}
+	tag, value, err := splitField(f[:len(f)-1])
+	if err != nil {
+		return "", err
+	}
+	if tag != expected {
+		return "", errors.New(fmt.Sprintf("Expecting tag %d, found %d", expected, tag))
+	}
+	return value, nil
+}
+
+func parseBody(body []byte) (*Message, error) {
+	if body[len(body)-1] != soh {
+		return nil, errors.New("Body must end with a field delimiter")
+	}
+	m := &Message{}
+	for _, f := range strings.Split(string(body[:len(body)-1]), string(soh)) {
+		tag, value, err := splitField(f)
+		if err != nil {
+			return nil, err
+		}
+		m.fields = append(m.fields, field{tag: tag, value: value})
+	}
+	if len(m.fields) == 0 || m.fields[0].tag != tagMsgType {
+		return nil, errors.New("MsgType must be the first field of the body")
+	}
+	return m, nil
+}
+
+func splitField(f string) (int, string, error) {
+	eq := strings.IndexByte(f, '=')
+	if eq <= 0 {
+		return 0, "", errors.New(fmt.Sprintf("Bad field %q", f))
+	}
+	tag, err := strconv.Atoi(f[:eq])
+	if err != nil {
+		return 0, "", errors.New(fmt.Sprintf("Bad tag in field %q", f))
+	}
+	return tag, f[eq+1:], nil
+}
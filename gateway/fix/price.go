@@ -0,0 +1,56 @@
+package fix
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Converts a decimal price into an integer number of ticks, with decimals implied decimal places
+func parsePrice(s string, decimals int) (uint64, error) {
+	whole, frac := s, ""
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		whole, frac = s[:dot], s[dot+1:]
+	}
+	// Trailing zeros beyond the price's precision are harmless
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > decimals {
+		return 0, errors.New(fmt.Sprintf("Price %s has more than %d decimal places", s, decimals))
+	}
+	frac += strings.Repeat("0", decimals-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+	p, err := strconv.ParseUint(whole+frac, 10, 64)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Bad price %q", s))
+	}
+	return p, nil
+}
+
+func formatPrice(ticks uint64, decimals int) string {
+	s := strconv.FormatUint(ticks, 10)
+	if decimals == 0 {
+		return s
+	}
+	if len(s) <= decimals {
+		s = strings.Repeat("0", decimals-len(s)+1) + s
+	}
+	return s[:len(s)-decimals] + "." + s[len(s)-decimals:]
+}
+
+// The average price of cost/qty ticks, in decimal
+func formatAvgPrice(cost, qty uint64, decimals int) string {
+	if qty == 0 {
+		return "0"
+	}
+	avg := float64(cost) / float64(qty) / math.Pow10(decimals)
+	// Two more places than a price has, without trailing zeros
+	s := strconv.FormatFloat(avg, 'f', decimals+2, 64)
+	if strings.IndexByte(s, '.') >= 0 {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
@@ -0,0 +1,187 @@
+package fix
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/fmstephe/matching_engine/gateway"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	logonTimeout = 10 * time.Second
+	timeFormat   = "20060102-15:04:05.000"
+	// A client which falls this many messages behind, or stops reading for
+	// writeTimeout, is disconnected
+	outQueueLen  = 4096
+	writeTimeout = 10 * time.Second
+)
+
+// A single connection, from Logon to Logout
+type session struct {
+	g         *Gateway
+	conn      net.Conn
+	r         *bufio.Reader
+	out       *gateway.Outbox
+	begin     string
+	compID    string
+	heartbeat time.Duration
+	inSeq     uint64 // The next MsgSeqNum expected
+	lock      sync.Mutex
+	outSeq    uint64
+	lastSent  time.Time
+}
+
+func newSession(g *Gateway, conn net.Conn) *session {
+	return &session{g: g, conn: conn, r: bufio.NewReader(conn), out: gateway.NewOutbox(conn, outQueueLen, writeTimeout), inSeq: 1, outSeq: 1}
+}
+
+func (s *session) run() {
+	// Everything sent, including a final Logout, is written before the connection is closed
+	defer s.out.Close()
+	a, err := s.logon()
+	if err != nil {
+		return
+	}
+	defer a.detach(s)
+	stop := make(chan struct{})
+	defer close(stop)
+	if s.heartbeat > 0 {
+		go s.heartbeats(stop)
+	}
+	for {
+		if s.heartbeat > 0 {
+			// A client which has sent nothing, not even a heartbeat, for two intervals has gone
+			s.conn.SetReadDeadline(time.Now().Add(2*s.heartbeat + time.Second))
+		}
+		m, err := s.read()
+		if err != nil {
+			return
+		}
+		switch m.Type() {
+		case msgHeartbeat:
+		case msgTestRequest:
+			reqId, _ := m.Get(tagTestReqID)
+			s.send(NewMessage(msgHeartbeat).Set(tagTestReqID, reqId))
+		case msgLogout:
+			s.send(NewMessage(msgLogout))
+			return
+		case msgNewOrderSingle:
+			a.newOrder(m)
+		case msgOrderCancelRequest:
+			a.cancel(m, false)
+		case msgCancelReplace:
+			a.cancel(m, true)
+		default:
+			s.reject(m, 11, "Unsupported MsgType")
+		}
+	}
+}
+
+func (s *session) logon() (*account, error) {
+	s.conn.SetReadDeadline(time.Now().Add(logonTimeout))
+	begin, m, err := ReadMessage(s.r)
+	if err != nil {
+		return nil, err
+	}
+	if begin != FIX42 && begin != FIX44 {
+		return nil, errors.New(fmt.Sprintf("Unsupported BeginString %s", begin))
+	}
+	s.begin = begin
+	if m.Type() != msgLogon {
+		return nil, s.logout("First message must be a Logon")
+	}
+	s.compID, _ = m.Get(tagSenderCompID)
+	if s.compID == "" {
+		return nil, s.logout("SenderCompID missing")
+	}
+	if target, _ := m.Get(tagTargetCompID); target != s.g.cfg.CompID {
+		return nil, s.logout(fmt.Sprintf("Unknown TargetCompID %s", target))
+	}
+	if err := s.checkSeq(m); err != nil {
+		return nil, err
+	}
+	hbStr, _ := m.Get(tagHeartBtInt)
+	hb, err := strconv.Atoi(hbStr)
+	if err != nil || hb < 0 {
+		return nil, s.logout(fmt.Sprintf("Bad HeartBtInt %q", hbStr))
+	}
+	s.heartbeat = time.Duration(hb) * time.Second
+	s.conn.SetReadDeadline(time.Time{})
+	reply := NewMessage(msgLogon).Set(tagEncryptMethod, "0").Set(tagHeartBtInt, hbStr)
+	a, err := s.g.attach(s.compID, s, reply)
+	if err != nil {
+		return nil, s.logout(err.Error())
+	}
+	return a, nil
+}
+
+// Reads the next message, which must have the expected MsgSeqNum
+func (s *session) read() (*Message, error) {
+	_, m, err := ReadMessage(s.r)
+	if err != nil {
+		return nil, err
+	}
+	return m, s.checkSeq(m)
+}
+
+func (s *session) checkSeq(m *Message) error {
+	seqStr, _ := m.Get(tagMsgSeqNum)
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil {
+		return s.logout(fmt.Sprintf("Bad MsgSeqNum %q", seqStr))
+	}
+	if seq != s.inSeq {
+		return s.logout(fmt.Sprintf("MsgSeqNum %d, expecting %d", seq, s.inSeq))
+	}
+	s.inSeq++
+	return nil
+}
+
+// Sends a Logout, returning text as an error to end the session
+func (s *session) logout(text string) error {
+	s.send(NewMessage(msgLogout).Set(tagText, text))
+	return errors.New(text)
+}
+
+// A session level Reject of m
+func (s *session) reject(m *Message, reason int, text string) {
+	seq, _ := m.Get(tagMsgSeqNum)
+	s.send(NewMessage(msgReject).Set(tagRefSeqNum, seq).Set(tagSessionRejectReason, strconv.Itoa(reason)).Set(tagText, text))
+}
+
+// Sends a heartbeat whenever nothing else has been sent for an interval
+func (s *session) heartbeats(stop chan struct{}) {
+	t := time.NewTicker(s.heartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			s.lock.Lock()
+			idle := time.Since(s.lastSent) >= s.heartbeat
+			s.lock.Unlock()
+			if idle {
+				s.send(NewMessage(msgHeartbeat))
+			}
+		}
+	}
+}
+
+// Adds the header fields to m and queues it to be sent. Never blocks, a
+// client which can't keep up is disconnected and its reader ends the session.
+func (s *session) send(m *Message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	m.Set(tagSenderCompID, s.g.cfg.CompID)
+	m.Set(tagTargetCompID, s.compID)
+	m.SetInt(tagMsgSeqNum, s.outSeq)
+	m.Set(tagSendingTime, time.Now().UTC().Format(timeFormat))
+	s.outSeq++
+	s.lastSent = time.Now()
+	s.out.Write(m.Encode(s.begin))
+}
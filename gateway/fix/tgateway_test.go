@@ -0,0 +1,255 @@
+package fix
+
+import (
+	"bufio"
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/gateway"
+	"github.com/fmstephe/matching_engine/matcher"
+	"net"
+	"testing"
+	"time"
+)
+
+func startGateway(t *testing.T) (*Gateway, func()) {
+	in := coordinator.NewChanReaderWriter(100)
+	hub := gateway.NewHub(in)
+	m := matcher.NewMatcher(100)
+	m.Config("FIX", in, hub)
+	go m.Run()
+	g := NewGateway(hub, Config{CompID: "ME", Symbols: map[string]uint64{"AAPL": 1}, PriceDecimals: 2})
+	if err := g.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err.Error())
+	}
+	return g, func() {
+		g.Close()
+		hub.Shutdown()
+	}
+}
+
+type client struct {
+	t     *testing.T
+	conn  net.Conn
+	r     *bufio.Reader
+	begin string
+	comp  string
+	seq   uint64
+}
+
+func logon(t *testing.T, g *Gateway, begin, comp string) *client {
+	conn, err := net.Dial("tcp", g.Addr().String())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn), begin: begin, comp: comp, seq: 1}
+	c.send(NewMessage(msgLogon).Set(tagEncryptMethod, "0").Set(tagHeartBtInt, "30"))
+	c.expect(msgLogon)
+	return c
+}
+
+func (c *client) send(m *Message) {
+	m.Set(tagSenderCompID, c.comp).Set(tagTargetCompID, "ME").SetInt(tagMsgSeqNum, c.seq)
+	c.seq++
+	if _, err := c.conn.Write(m.Encode(c.begin)); err != nil {
+		c.t.Fatal(err.Error())
+	}
+}
+
+func (c *client) read() *Message {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, m, err := ReadMessage(c.r)
+	if err != nil {
+		c.t.Fatal(err.Error())
+	}
+	return m
+}
+
+// Reads the next message, which must be of msgType and have each of the tag/value pairs in fields
+func (c *client) expect(msgType string, fields ...interface{}) *Message {
+	c.t.Helper()
+	m := c.read()
+	if m.Type() != msgType {
+		c.t.Fatalf("Expecting MsgType %s, found %s", msgType, m)
+	}
+	for i := 0; i < len(fields); i += 2 {
+		tag, value := fields[i].(int), fields[i+1].(string)
+		if v, _ := m.Get(tag); v != value {
+			c.t.Errorf("Expecting %d=%s, found %s", tag, value, m)
+		}
+	}
+	return m
+}
+
+func (c *client) order(clOrdID, side, qty, price string) {
+	c.send(NewMessage(msgNewOrderSingle).Set(tagClOrdID, clOrdID).Set(tagSymbol, "AAPL").Set(tagSide, side).Set(tagOrdType, ordLimit).Set(tagOrderQty, qty).Set(tagPrice, price))
+}
+
+func (c *client) cancel(clOrdID, origClOrdID string) {
+	c.send(NewMessage(msgOrderCancelRequest).Set(tagClOrdID, clOrdID).Set(tagOrigClOrdID, origClOrdID).Set(tagSymbol, "AAPL"))
+}
+
+func TestHeartbeat(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	c := logon(t, g, FIX44, "A")
+	c.send(NewMessage(msgTestRequest).Set(tagTestReqID, "ping"))
+	c.expect(msgHeartbeat, tagTestReqID, "ping", tagMsgSeqNum, "2")
+	c.send(NewMessage(msgLogout))
+	c.expect(msgLogout)
+}
+
+func TestFills(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	buyer := logon(t, g, FIX44, "BUYER")
+	seller := logon(t, g, FIX44, "SELLER")
+	buyer.order("b1", sideBuy, "100", "10.10")
+	buyer.expect(msgExecutionReport, tagClOrdID, "b1", tagExecType, statusNew, tagLeavesQty, "100")
+	seller.order("s1", sideSell, "60", "10.00")
+	seller.expect(msgExecutionReport, tagClOrdID, "s1", tagExecType, statusNew)
+	// The execution price is the midpoint of the two orders
+	buyer.expect(msgExecutionReport, tagClOrdID, "b1", tagExecType, execTrade, tagOrdStatus, statusPartial, tagLastShares, "60", tagLastPx, "10.05", tagCumQty, "60", tagLeavesQty, "40")
+	seller.expect(msgExecutionReport, tagClOrdID, "s1", tagExecType, execTrade, tagOrdStatus, statusFilled, tagCumQty, "60", tagLeavesQty, "0", tagAvgPx, "10.05")
+	seller.order("s2", sideSell, "40", "10.10")
+	seller.expect(msgExecutionReport, tagClOrdID, "s2", tagExecType, statusNew)
+	buyer.expect(msgExecutionReport, tagClOrdID, "b1", tagOrdStatus, statusFilled, tagLastPx, "10.10", tagCumQty, "100", tagAvgPx, "10.07")
+	seller.expect(msgExecutionReport, tagClOrdID, "s2", tagOrdStatus, statusFilled)
+}
+
+func TestCancel(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	c := logon(t, g, FIX44, "A")
+	c.order("1", sideBuy, "10", "5")
+	c.expect(msgExecutionReport, tagExecType, statusNew)
+	c.cancel("2", "1")
+	c.expect(msgExecutionReport, tagClOrdID, "2", tagOrigClOrdID, "1", tagExecType, statusCancelled, tagLeavesQty, "0")
+	// The order is no longer live
+	c.cancel("3", "1")
+	c.expect(msgOrderCancelReject, tagClOrdID, "3", tagCxlRejReason, "1")
+}
+
+func TestReplace(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	c := logon(t, g, FIX44, "A")
+	c.order("1", sideBuy, "10", "5")
+	c.expect(msgExecutionReport, tagExecType, statusNew)
+	c.send(NewMessage(msgCancelReplace).Set(tagClOrdID, "2").Set(tagOrigClOrdID, "1").Set(tagSymbol, "AAPL").Set(tagSide, sideBuy).Set(tagOrdType, ordLimit).Set(tagOrderQty, "20").Set(tagPrice, "6"))
+	c.expect(msgExecutionReport, tagClOrdID, "2", tagOrigClOrdID, "1", tagExecType, statusReplaced, tagOrdStatus, statusNew, tagOrderQty, "20", tagPrice, "6.00", tagLeavesQty, "20")
+	c.order("3", sideSell, "20", "6")
+	c.expect(msgExecutionReport, tagClOrdID, "3", tagExecType, statusNew)
+	first := c.expect(msgExecutionReport, tagExecType, execTrade, tagLastShares, "20", tagLastPx, "6.00")
+	second := c.expect(msgExecutionReport, tagExecType, execTrade, tagLastShares, "20")
+	ids := map[string]bool{}
+	for _, m := range []*Message{first, second} {
+		id, _ := m.Get(tagClOrdID)
+		ids[id] = true
+	}
+	if !ids["2"] || !ids["3"] {
+		t.Errorf("Expecting fills for ClOrdIDs 2 and 3, found %v", ids)
+	}
+}
+
+func TestRejects(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	c := logon(t, g, FIX44, "A")
+	c.send(NewMessage(msgNewOrderSingle).Set(tagClOrdID, "1").Set(tagSymbol, "MSFT").Set(tagSide, sideBuy).Set(tagOrdType, ordLimit).Set(tagOrderQty, "1").Set(tagPrice, "1"))
+	c.expect(msgExecutionReport, tagExecType, statusRejected, tagOrdRejReason, "1")
+	c.order("2", sideBuy, "1", "1.001")
+	c.expect(msgExecutionReport, tagExecType, statusRejected, tagOrdRejReason, "0")
+	c.order("3", sideBuy, "1", "1")
+	c.expect(msgExecutionReport, tagExecType, statusNew)
+	c.order("3", sideBuy, "1", "1")
+	c.expect(msgExecutionReport, tagExecType, statusRejected, tagOrdRejReason, "6")
+	c.cancel("4", "unknown")
+	c.expect(msgOrderCancelReject, tagCxlRejReason, "1", tagCxlRejResponseTo, cxlRejResponseCxl)
+	c.send(NewMessage("V"))
+	c.expect(msgReject, tagSessionRejectReason, "11")
+}
+
+func TestCancelTooLate(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	c := logon(t, g, FIX44, "A")
+	c.order("1", sideBuy, "10", "5")
+	c.expect(msgExecutionReport, tagExecType, statusNew)
+	// Submitted before the fills reach the account, the cancel arrives after the order has gone
+	c.order("2", sideSell, "10", "5")
+	c.cancel("3", "1")
+	c.expect(msgExecutionReport, tagClOrdID, "2", tagExecType, statusNew)
+	for i := 0; i < 2; i++ {
+		c.expect(msgExecutionReport, tagOrdStatus, statusFilled)
+	}
+	// Rejected as too late by the matcher, or as unknown if the fill reached the account first
+	c.expect(msgOrderCancelReject, tagClOrdID, "3", tagOrigClOrdID, "1")
+}
+
+func TestFIX42(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	c := logon(t, g, FIX42, "A")
+	c.order("1", sideBuy, "10", "5")
+	c.expect(msgExecutionReport, tagExecTransType, "0", tagExecType, statusNew)
+	c.order("2", sideSell, "4", "5")
+	c.expect(msgExecutionReport, tagClOrdID, "2", tagExecType, statusNew)
+	for i := 0; i < 2; i++ {
+		m := c.read()
+		id, _ := m.Get(tagClOrdID)
+		execType, _ := m.Get(tagExecType)
+		if id == "1" && execType != statusPartial || id == "2" && execType != statusFilled {
+			t.Errorf("Expecting FIX 4.2 ExecTypes, found %s", m)
+		}
+	}
+}
+
+func TestSeqGap(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	c := logon(t, g, FIX44, "A")
+	c.seq++
+	c.send(NewMessage(msgHeartbeat))
+	c.expect(msgLogout)
+	// The account can log on again once the session has ended
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", g.Addr().String())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		c = &client{t: t, conn: conn, r: bufio.NewReader(conn), begin: FIX44, comp: "A", seq: 1}
+		c.send(NewMessage(msgLogon).Set(tagEncryptMethod, "0").Set(tagHeartBtInt, "30"))
+		if m := c.read(); m.Type() == msgLogon {
+			return
+		} else if i == 10 {
+			t.Fatalf("Expecting a Logon, found %s", m)
+		}
+		conn.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Sending to a client which has stopped reading never blocks, the client is disconnected instead
+func TestSlowClientDisconnected(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	client, server := net.Pipe()
+	defer client.Close()
+	s := newSession(g, server)
+	s.begin, s.compID = FIX44, "SLOW"
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 2*outQueueLen; i++ {
+			s.send(NewMessage(msgHeartbeat))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sending to a client which doesn't read blocked")
+	}
+	if !s.out.Failed() {
+		t.Errorf("Expecting the client to be disconnected")
+	}
+	s.out.Close()
+}
@@ -0,0 +1,78 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	m := NewMessage(msgHeartbeat).Set(tagTestReqID, "abc").Set(tagSenderCompID, "A").Set(tagTargetCompID, "B").Set(tagMsgSeqNum, "2")
+	expected := "8=FIX.4.4|9=28|35=0|49=A|56=B|34=2|112=abc|10="
+	if s := m.String(); !strings.HasPrefix(s, expected) {
+		t.Errorf("Expecting %s..., found %s", expected, s)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	m := NewMessage(msgNewOrderSingle).Set(tagClOrdID, "a=b").Set(tagSymbol, "AAPL").SetInt(tagOrderQty, 100)
+	var b bytes.Buffer
+	b.Write(m.Encode(FIX42))
+	b.Write(NewMessage(msgLogout).Encode(FIX44))
+	r := bufio.NewReader(&b)
+	begin, read, err := ReadMessage(r)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if begin != FIX42 || read.Type() != msgNewOrderSingle {
+		t.Errorf("Expecting a %s NewOrderSingle, found %s %s", FIX42, begin, read.Type())
+	}
+	// Values may contain '='
+	if v, _ := read.Get(tagClOrdID); v != "a=b" {
+		t.Errorf("Expecting ClOrdID a=b, found %s", v)
+	}
+	if v, _ := read.Get(tagOrderQty); v != "100" {
+		t.Errorf("Expecting OrderQty 100, found %s", v)
+	}
+	begin, read, err = ReadMessage(r)
+	if err != nil || begin != FIX44 || read.Type() != msgLogout {
+		t.Errorf("Expecting a %s Logout, found %s %v %v", FIX44, begin, read, err)
+	}
+}
+
+func TestReadBadMessages(t *testing.T) {
+	good := string(NewMessage(msgHeartbeat).Set(tagMsgSeqNum, "1").Encode(FIX44))
+	bad := map[string]string{
+		"checksum":    good[:len(good)-4] + "999\x01",
+		"body length": strings.Replace(good, "9=", "9=1", 1),
+		"begin":       "9=5\x0135=0\x0110=000\x01",
+		"field":       "8=FIX.4.4\x019=6\x01350\x0110=000\x01",
+	}
+	for name, s := range bad {
+		if _, _, err := ReadMessage(bufio.NewReader(strings.NewReader(s))); err == nil {
+			t.Errorf("Expecting an error for a bad %s", name)
+		}
+	}
+}
+
+func TestPrices(t *testing.T) {
+	for s, expected := range map[string]uint64{"10": 1000, "10.5": 1050, "10.25": 1025, "10.250": 1025, ".5": 50, "0.01": 1} {
+		if p, err := parsePrice(s, 2); err != nil || p != expected {
+			t.Errorf("%s: Expecting %d, found %d %v", s, expected, p, err)
+		}
+	}
+	for _, s := range []string{"10.255", "-1", "abc", "1.2.3", ""} {
+		if _, err := parsePrice(s, 2); err == nil && s != "" {
+			t.Errorf("%s: Expecting an error", s)
+		}
+	}
+	for ticks, expected := range map[uint64]string{1000: "10.00", 1: "0.01", 1025: "10.25", 0: "0.00"} {
+		if s := formatPrice(ticks, 2); s != expected {
+			t.Errorf("%d: Expecting %s, found %s", ticks, expected, s)
+		}
+	}
+	if s := formatAvgPrice(1000*60+1010*40, 100, 2); s != "10.04" {
+		t.Errorf("Expecting 10.04, found %s", s)
+	}
+}
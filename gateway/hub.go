@@ -0,0 +1,99 @@
+package gateway
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/msg"
+	"sync"
+)
+
+// Receives the matcher's outputs for a single trader
+type Trader interface {
+	// Called from the goroutine writing the matcher's outputs, so should not block for long.
+	// Deliver may Submit further messages.
+	Deliver(m *msg.Message)
+}
+
+// Connects the order entry gateways to a single matcher. Messages submitted
+// by every trader are written, in the order they were submitted, to the
+// matcher's input. The Hub is the matcher's output, and delivers each
+// message to the trader it belongs to.
+//
+// Submit never blocks, so a Trader may submit while a message is being
+// delivered to it without deadlocking against the matcher.
+type Hub struct {
+	in      coordinator.MsgWriter
+	lock    sync.Mutex
+	cond    *sync.Cond
+	queue   []msg.Message
+	traders map[uint32]Trader
+	next    uint32
+	done    chan struct{}
+}
+
+func NewHub(in coordinator.MsgWriter) *Hub {
+	h := &Hub{in: in, traders: make(map[uint32]Trader), next: 1, done: make(chan struct{})}
+	h.cond = sync.NewCond(&h.lock)
+	go h.run()
+	return h
+}
+
+// Registers t, returning the TraderId its orders must be submitted with
+func (h *Hub) Join(t Trader) uint32 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	id := h.next
+	h.next++
+	h.traders[id] = t
+	return id
+}
+
+// Stops delivering outputs to the trader with traderId
+func (h *Hub) Leave(traderId uint32) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.traders, traderId)
+}
+
+// Queues m to be written to the matcher's input
+func (h *Hub) Submit(m msg.Message) {
+	h.lock.Lock()
+	h.queue = append(h.queue, m)
+	h.lock.Unlock()
+	h.cond.Signal()
+}
+
+// Submits a SHUTDOWN, after every message already submitted, and waits until it is written
+func (h *Hub) Shutdown() {
+	h.Submit(msg.Message{Kind: msg.SHUTDOWN})
+	<-h.done
+}
+
+func (h *Hub) run() {
+	defer close(h.done)
+	var batch []msg.Message
+	for {
+		h.lock.Lock()
+		for len(h.queue) == 0 {
+			h.cond.Wait()
+		}
+		batch, h.queue = h.queue, batch[:0]
+		h.lock.Unlock()
+		for i := range batch {
+			h.in.Write(batch[i])
+			if batch[i].Kind == msg.SHUTDOWN {
+				return
+			}
+		}
+	}
+}
+
+// Delivers an output of the matcher to the trader it belongs to.
+// Outputs for unknown traders, and the matcher's SHUTDOWN, are dropped.
+func (h *Hub) Write(m msg.Message) {
+	h.lock.Lock()
+	t := h.traders[m.TraderId]
+	h.lock.Unlock()
+	if t != nil {
+		t.Deliver(&m)
+	}
+}
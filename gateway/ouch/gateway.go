@@ -0,0 +1,79 @@
+package ouch
+
+import (
+	"github.com/fmstephe/matching_engine/gateway"
+	"net"
+	"sync"
+)
+
+// An OUCH style binary order entry gateway, accepting sessions over TCP.
+//
+// There is no logon, each connection trades as a new TraderId. When a
+// connection closes every one of its live orders is cancelled.
+type Gateway struct {
+	hub      *gateway.Hub
+	lock     sync.Mutex
+	conns    map[net.Conn]bool
+	listener net.Listener
+	running  sync.WaitGroup
+}
+
+func NewGateway(hub *gateway.Hub) *Gateway {
+	return &Gateway{hub: hub, conns: make(map[net.Conn]bool)}
+}
+
+// Starts accepting sessions on addr, e.g. "127.0.0.1:0"
+func (g *Gateway) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	g.listener = l
+	g.running.Add(1)
+	go g.accept()
+	return nil
+}
+
+func (g *Gateway) Addr() net.Addr {
+	return g.listener.Addr()
+}
+
+// Stops accepting sessions, disconnects every session and waits for them to end
+func (g *Gateway) Close() {
+	g.listener.Close()
+	g.lock.Lock()
+	for conn := range g.conns {
+		conn.Close()
+	}
+	g.lock.Unlock()
+	g.running.Wait()
+}
+
+func (g *Gateway) accept() {
+	defer g.running.Done()
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			return
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetNoDelay(true)
+		}
+		g.lock.Lock()
+		g.conns[conn] = true
+		g.lock.Unlock()
+		g.running.Add(1)
+		go g.serve(conn)
+	}
+}
+
+func (g *Gateway) serve(conn net.Conn) {
+	defer g.running.Done()
+	defer func() {
+		conn.Close()
+		g.lock.Lock()
+		delete(g.conns, conn)
+		g.lock.Unlock()
+	}()
+	newSession(g, conn).run()
+}
@@ -0,0 +1,222 @@
+package ouch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/fmstephe/matching_engine/msg"
+	"io"
+)
+
+type OuchType byte
+
+const (
+	// Sent by clients
+	ENTER_ORDER   = OuchType('O')
+	REPLACE_ORDER = OuchType('U')
+	CANCEL_ORDER  = OuchType('X')
+	// Sent by the gateway
+	ACCEPTED = OuchType('A')
+	REPLACED = OuchType('R')
+	EXECUTED = OuchType('E')
+	CANCELED = OuchType('C')
+	REJECTED = OuchType('J')
+)
+
+func (t OuchType) String() string {
+	switch t {
+	case ENTER_ORDER:
+		return "ENTER_ORDER"
+	case REPLACE_ORDER:
+		return "REPLACE_ORDER"
+	case CANCEL_ORDER:
+		return "CANCEL_ORDER"
+	case ACCEPTED:
+		return "ACCEPTED"
+	case REPLACED:
+		return "REPLACED"
+	case EXECUTED:
+		return "EXECUTED"
+	case CANCELED:
+		return "CANCELED"
+	case REJECTED:
+		return "REJECTED"
+	}
+	return fmt.Sprintf("UNKNOWN(%q)", byte(t))
+}
+
+func (t OuchType) hasToken() bool {
+	return t == REPLACE_ORDER || t == CANCEL_ORDER || t == REPLACED
+}
+
+func (t OuchType) hasOrder() bool {
+	return t != CANCEL_ORDER
+}
+
+type RejectReason byte
+
+const (
+	NO_REASON       = RejectReason(0)
+	REJECT_TOKEN    = RejectReason('T') // The token is not greater than every token already used
+	REJECT_INVALID  = RejectReason('I') // The order is not a valid BUY or SELL, or a replace changes its side or stock
+	REJECT_UNKNOWN  = RejectReason('U') // No live order has the token being cancelled or replaced
+	REJECT_PENDING  = RejectReason('P') // The order already has a cancel or replace pending
+	REJECT_TOO_LATE = RejectReason('L') // The order was filled before it could be cancelled
+	REJECT_BAND     = RejectReason('B') // The matcher rejected the order's price
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case NO_REASON:
+		return "NO_REASON"
+	case REJECT_TOKEN:
+		return "REJECT_TOKEN"
+	case REJECT_INVALID:
+		return "REJECT_INVALID"
+	case REJECT_UNKNOWN:
+		return "REJECT_UNKNOWN"
+	case REJECT_PENDING:
+		return "REJECT_PENDING"
+	case REJECT_TOO_LATE:
+		return "REJECT_TOO_LATE"
+	case REJECT_BAND:
+		return "REJECT_BAND"
+	}
+	return fmt.Sprintf("UNKNOWN(%q)", byte(r))
+}
+
+// A single OUCH message. Orders are identified by their token, which is
+// the Order's TradeId. The client chooses each token, and every new token
+// must be greater than the last. The gateway sets the Order's TraderId.
+//
+//	ENTER_ORDER   Order is a BUY or SELL
+//	REPLACE_ORDER Token is the order being replaced, Order is its replacement.
+//	              The replacement's Amount is its whole unfilled quantity.
+//	CANCEL_ORDER  Token is the order being cancelled
+//	ACCEPTED      Order has been entered
+//	REPLACED      Token has been cancelled and Order entered in its place
+//	EXECUTED      Order is the matcher's PARTIAL or FULL
+//	CANCELED      Order is the matcher's CANCELLED, carrying the cancelled quantity
+//	REJECTED      Reason is why Order, a BUY, SELL or CANCEL, was rejected
+type Message struct {
+	Type   OuchType
+	Token  uint32
+	Reason RejectReason
+	Order  msg.Message
+}
+
+// Messages are framed by a two byte length, followed by that many bytes
+// holding the type, then, depending on the type, the token, the reject
+// reason and the order in msg's fixed-width encoding. Like msg, integers
+// are little endian.
+const (
+	lengthLen = 2
+	typeLen   = 1
+	tokenLen  = 4
+	reasonLen = 1
+	orderLen  = msg.ByteSize
+	// The largest frame, a REPLACE_ORDER or REPLACED
+	MaxFrameLen = lengthLen + typeLen + tokenLen + orderLen
+)
+
+var coder = binary.LittleEndian
+
+// The length of the frame's payload, excluding the length itself
+func payloadLen(t OuchType) int {
+	l := typeLen
+	if t.hasToken() {
+		l += tokenLen
+	}
+	if t == REJECTED {
+		l += reasonLen
+	}
+	if t.hasOrder() {
+		l += orderLen
+	}
+	return l
+}
+
+func validType(t OuchType) bool {
+	switch t {
+	case ENTER_ORDER, REPLACE_ORDER, CANCEL_ORDER, ACCEPTED, REPLACED, EXECUTED, CANCELED, REJECTED:
+		return true
+	}
+	return false
+}
+
+// Appends m, framed, to b
+func (m *Message) AppendFrame(b []byte) []byte {
+	l := payloadLen(m.Type)
+	start := len(b)
+	for i := 0; i < lengthLen+l; i++ {
+		b = append(b, 0)
+	}
+	f := b[start:]
+	coder.PutUint16(f, uint16(l))
+	f[lengthLen] = byte(m.Type)
+	p := f[lengthLen+typeLen:]
+	if m.Type.hasToken() {
+		coder.PutUint32(p, m.Token)
+		p = p[tokenLen:]
+	}
+	if m.Type == REJECTED {
+		p[0] = byte(m.Reason)
+		p = p[reasonLen:]
+	}
+	if m.Type.hasOrder() {
+		m.Order.Marshal(p[:orderLen])
+	}
+	return b
+}
+
+// Reads the next framed message from r. A frame whose length does not match
+// its type is an error.
+func ReadMessage(r io.Reader, m *Message) error {
+	var b [MaxFrameLen]byte
+	if _, err := io.ReadFull(r, b[:lengthLen]); err != nil {
+		return err
+	}
+	l := int(coder.Uint16(b[:lengthLen]))
+	if l == 0 || l > MaxFrameLen-lengthLen {
+		return errors.New(fmt.Sprintf("Bad frame length %d", l))
+	}
+	p := b[:l]
+	if _, err := io.ReadFull(r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	*m = Message{Type: OuchType(p[0])}
+	if !validType(m.Type) {
+		return errors.New(fmt.Sprintf("Unknown message type %v", m.Type))
+	}
+	if expected := payloadLen(m.Type); l != expected {
+		return errors.New(fmt.Sprintf("Bad frame length %d for %v, expecting %d", l, m.Type, expected))
+	}
+	p = p[typeLen:]
+	if m.Type.hasToken() {
+		m.Token = coder.Uint32(p)
+		p = p[tokenLen:]
+	}
+	if m.Type == REJECTED {
+		m.Reason = RejectReason(p[0])
+		p = p[reasonLen:]
+	}
+	if m.Type.hasOrder() {
+		return m.Order.Unmarshal(p)
+	}
+	return nil
+}
+
+func (m *Message) String() string {
+	switch {
+	case m.Type == CANCEL_ORDER:
+		return fmt.Sprintf("%v, token %d", m.Type, m.Token)
+	case m.Type.hasToken():
+		return fmt.Sprintf("%v, token %d, %v", m.Type, m.Token, &m.Order)
+	case m.Type == REJECTED:
+		return fmt.Sprintf("%v, %v, %v", m.Type, m.Reason, &m.Order)
+	}
+	return fmt.Sprintf("%v, %v", m.Type, &m.Order)
+}
@@ -0,0 +1,238 @@
+package ouch
+
+import (
+	"bufio"
+	"github.com/fmstephe/matching_engine/gateway"
+	"github.com/fmstephe/matching_engine/msg"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// A client which falls this many messages behind, or stops reading for
+	// writeTimeout, is disconnected
+	outQueueLen  = 4096
+	writeTimeout = 10 * time.Second
+)
+
+// A cancel or replace waiting for the matcher to cancel the order
+type pendingCancel struct {
+	replace *msg.Message // nil for a cancel
+}
+
+// A single connection, trading as a single TraderId
+type session struct {
+	g         *Gateway
+	conn      net.Conn
+	traderId  uint32
+	lock      sync.Mutex
+	closed    bool
+	lastToken uint32
+	orders    map[uint32]*msg.Message // Live orders, with their unfilled Amount
+	pending   map[uint32]*pendingCancel
+	out       *gateway.Outbox
+}
+
+func newSession(g *Gateway, conn net.Conn) *session {
+	s := &session{
+		g:       g,
+		conn:    conn,
+		orders:  make(map[uint32]*msg.Message),
+		pending: make(map[uint32]*pendingCancel),
+		out:     gateway.NewOutbox(conn, outQueueLen, writeTimeout),
+	}
+	s.traderId = g.hub.Join(s)
+	return s
+}
+
+// Reads messages until the connection fails or the client sends something
+// which is not ENTER_ORDER, REPLACE_ORDER or CANCEL_ORDER
+func (s *session) run() {
+	defer s.close()
+	r := bufio.NewReader(s.conn)
+	m := &Message{}
+	for {
+		if err := ReadMessage(r, m); err != nil {
+			return
+		}
+		switch m.Type {
+		case ENTER_ORDER:
+			s.enter(&m.Order)
+		case REPLACE_ORDER:
+			s.replace(m.Token, &m.Order)
+		case CANCEL_ORDER:
+			s.cancel(m.Token)
+		default:
+			return
+		}
+	}
+}
+
+// Cancels every live order, stops receiving the matcher's outputs and waits
+// for everything already sent to be written
+func (s *session) close() {
+	s.lock.Lock()
+	s.closed = true
+	for token, o := range s.orders {
+		if s.pending[token] == nil {
+			s.submitCancel(o)
+		}
+	}
+	s.lock.Unlock()
+	s.g.hub.Leave(s.traderId)
+	s.out.Close()
+}
+
+func (s *session) enter(o *msg.Message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	o.TraderId = s.traderId
+	if !s.newToken(o.TradeId) {
+		s.reject(REJECT_TOKEN, o)
+		return
+	}
+	if !validOrder(o) {
+		s.reject(REJECT_INVALID, o)
+		return
+	}
+	no := *o
+	s.orders[no.TradeId] = &no
+	s.send(&Message{Type: ACCEPTED, Order: no})
+	s.g.hub.Submit(no)
+}
+
+// Replaces a live order, by cancelling it and then entering r. A replaced order loses its time priority.
+func (s *session) replace(token uint32, r *msg.Message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r.TraderId = s.traderId
+	if !s.newToken(r.TradeId) {
+		s.reject(REJECT_TOKEN, r)
+		return
+	}
+	o := s.orders[token]
+	if o == nil {
+		s.reject(REJECT_UNKNOWN, r)
+		return
+	}
+	if !validOrder(r) || r.Kind != o.Kind || r.StockId != o.StockId {
+		s.reject(REJECT_INVALID, r)
+		return
+	}
+	if s.pending[token] != nil {
+		s.reject(REJECT_PENDING, r)
+		return
+	}
+	nr := *r
+	s.pending[token] = &pendingCancel{replace: &nr}
+	s.submitCancel(o)
+}
+
+func (s *session) cancel(token uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	o := s.orders[token]
+	if o == nil {
+		s.reject(REJECT_UNKNOWN, &msg.Message{Kind: msg.CANCEL, TraderId: s.traderId, TradeId: token})
+		return
+	}
+	if s.pending[token] != nil {
+		c := *o
+		c.Kind = msg.CANCEL
+		s.reject(REJECT_PENDING, &c)
+		return
+	}
+	s.pending[token] = &pendingCancel{}
+	s.submitCancel(o)
+}
+
+// Tokens must increase, so that no two orders ever share one
+func (s *session) newToken(token uint32) bool {
+	if token <= s.lastToken {
+		return false
+	}
+	s.lastToken = token
+	return true
+}
+
+// Only BUYs and SELLs can be entered, and only a SELL may be at the market price
+func validOrder(o *msg.Message) bool {
+	return (o.Kind == msg.BUY || o.Kind == msg.SELL) && o.Valid()
+}
+
+func (s *session) submitCancel(o *msg.Message) {
+	c := *o
+	c.Kind = msg.CANCEL
+	s.g.hub.Submit(c)
+}
+
+// Receives the matcher's outputs for this session's orders
+func (s *session) Deliver(m *msg.Message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	switch m.Kind {
+	case msg.FULL, msg.PARTIAL:
+		o := s.orders[m.TradeId]
+		if o == nil {
+			return
+		}
+		if o.Amount > m.Amount {
+			o.Amount -= m.Amount
+		} else {
+			o.Amount = 0
+		}
+		if m.Kind == msg.FULL {
+			delete(s.orders, m.TradeId)
+		}
+		s.send(&Message{Type: EXECUTED, Order: *m})
+	case msg.CANCELLED:
+		p := s.pending[m.TradeId]
+		delete(s.pending, m.TradeId)
+		if s.orders[m.TradeId] == nil {
+			return
+		}
+		delete(s.orders, m.TradeId)
+		if p != nil && p.replace != nil {
+			r := *p.replace
+			s.orders[r.TradeId] = &r
+			s.send(&Message{Type: REPLACED, Token: m.TradeId, Order: r})
+			s.g.hub.Submit(r)
+			return
+		}
+		s.send(&Message{Type: CANCELED, Order: *m})
+	case msg.NOT_CANCELLED:
+		p := s.pending[m.TradeId]
+		delete(s.pending, m.TradeId)
+		if p == nil {
+			return
+		}
+		if p.replace != nil {
+			s.reject(REJECT_TOO_LATE, p.replace)
+			return
+		}
+		c := *m
+		c.Kind = msg.CANCEL
+		s.reject(REJECT_TOO_LATE, &c)
+	case msg.REJECTED:
+		o := s.orders[m.TradeId]
+		if o == nil {
+			return
+		}
+		delete(s.orders, m.TradeId)
+		s.reject(REJECT_BAND, o)
+	}
+}
+
+func (s *session) reject(reason RejectReason, o *msg.Message) {
+	s.send(&Message{Type: REJECTED, Reason: reason, Order: *o})
+}
+
+// Queues m to be written. Never blocks, a client which can't keep up is
+// disconnected and its reader ends the session.
+func (s *session) send(m *Message) {
+	s.out.Write(m.AppendFrame(make([]byte, 0, MaxFrameLen)))
+}
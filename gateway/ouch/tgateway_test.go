@@ -0,0 +1,212 @@
+package ouch
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/gateway"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/msg"
+	"net"
+	"testing"
+	"time"
+)
+
+const stockId = 1
+
+func startGateway(t *testing.T) (*Gateway, func()) {
+	in := coordinator.NewChanReaderWriter(100)
+	hub := gateway.NewHub(in)
+	m := matcher.NewMatcher(100)
+	m.Config("OUCH", in, hub)
+	go m.Run()
+	g := NewGateway(hub)
+	if err := g.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err.Error())
+	}
+	return g, func() {
+		g.Close()
+		hub.Shutdown()
+	}
+}
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func connect(t *testing.T, g *Gateway) *client {
+	conn, err := net.Dial("tcp", g.Addr().String())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return &client{t: t, conn: conn}
+}
+
+func (c *client) send(m *Message) {
+	if _, err := c.conn.Write(m.AppendFrame(nil)); err != nil {
+		c.t.Fatal(err.Error())
+	}
+}
+
+func (c *client) enter(kind msg.MsgKind, token uint32, price, amount uint64) {
+	c.send(&Message{Type: ENTER_ORDER, Order: msg.Message{Kind: kind, Price: price, Amount: amount, StockId: stockId, TradeId: token}})
+}
+
+// Reads the next message, which must be of type t for the token, with amount if it is not 0
+func (c *client) expect(t OuchType, token uint32, amount uint64) *Message {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	m := &Message{}
+	if err := ReadMessage(c.conn, m); err != nil {
+		c.t.Fatal(err.Error())
+	}
+	if m.Type != t || m.Order.TradeId != token || (amount != 0 && m.Order.Amount != amount) {
+		c.t.Fatalf("Expecting %v for token %d amount %d, found %v", t, token, amount, m)
+	}
+	return m
+}
+
+func (c *client) expectReject(reason RejectReason, token uint32) {
+	c.t.Helper()
+	if m := c.expect(REJECTED, token, 0); m.Reason != reason {
+		c.t.Errorf("Expecting %v, found %v", reason, m)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	buyer := connect(t, g)
+	seller := connect(t, g)
+	buyer.enter(msg.BUY, 1, 10, 100)
+	buyer.expect(ACCEPTED, 1, 100)
+	seller.enter(msg.SELL, 1, 8, 60)
+	seller.expect(ACCEPTED, 1, 60)
+	if m := buyer.expect(EXECUTED, 1, 60); m.Order.Kind != msg.PARTIAL || m.Order.Price != 9 {
+		t.Errorf("Expecting a PARTIAL at 9, found %v", m)
+	}
+	if m := seller.expect(EXECUTED, 1, 60); m.Order.Kind != msg.FULL {
+		t.Errorf("Expecting a FULL, found %v", m)
+	}
+	// The rest of the buy is cancelled
+	buyer.send(&Message{Type: CANCEL_ORDER, Token: 1})
+	buyer.expect(CANCELED, 1, 40)
+	buyer.send(&Message{Type: CANCEL_ORDER, Token: 1})
+	buyer.expectReject(REJECT_UNKNOWN, 1)
+}
+
+func TestReplace(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	c := connect(t, g)
+	c.enter(msg.BUY, 1, 10, 10)
+	c.expect(ACCEPTED, 1, 10)
+	c.send(&Message{Type: REPLACE_ORDER, Token: 1, Order: msg.Message{Kind: msg.BUY, Price: 11, Amount: 20, StockId: stockId, TradeId: 2}})
+	if m := c.expect(REPLACED, 2, 20); m.Token != 1 || m.Order.Price != 11 {
+		t.Errorf("Expecting token 1 replaced at 11, found %v", m)
+	}
+	// A replace may not change the side
+	c.send(&Message{Type: REPLACE_ORDER, Token: 2, Order: msg.Message{Kind: msg.SELL, Price: 11, Amount: 20, StockId: stockId, TradeId: 3}})
+	c.expectReject(REJECT_INVALID, 3)
+	c.enter(msg.SELL, 4, 11, 20)
+	c.expect(ACCEPTED, 4, 20)
+	c.expect(EXECUTED, 2, 20)
+	c.expect(EXECUTED, 4, 20)
+}
+
+func TestRejects(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	c := connect(t, g)
+	c.enter(msg.BUY, 5, 10, 10)
+	c.expect(ACCEPTED, 5, 10)
+	// Tokens must increase
+	c.enter(msg.BUY, 5, 10, 10)
+	c.expectReject(REJECT_TOKEN, 5)
+	c.enter(msg.BUY, 4, 10, 10)
+	c.expectReject(REJECT_TOKEN, 4)
+	// A buy at the market price
+	c.enter(msg.BUY, 6, 0, 10)
+	c.expectReject(REJECT_INVALID, 6)
+	c.enter(msg.CANCEL, 7, 10, 10)
+	c.expectReject(REJECT_INVALID, 7)
+	c.send(&Message{Type: REPLACE_ORDER, Token: 99, Order: msg.Message{Kind: msg.BUY, Price: 11, Amount: 20, StockId: stockId, TradeId: 8}})
+	c.expectReject(REJECT_UNKNOWN, 8)
+}
+
+func TestCancelTooLate(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	c := connect(t, g)
+	c.enter(msg.BUY, 1, 10, 10)
+	c.expect(ACCEPTED, 1, 10)
+	c.enter(msg.SELL, 2, 10, 10)
+	c.send(&Message{Type: CANCEL_ORDER, Token: 1})
+	c.expect(ACCEPTED, 2, 10)
+	c.expect(EXECUTED, 1, 10)
+	c.expect(EXECUTED, 2, 10)
+	// Rejected as too late by the matcher, or as unknown if the fill reached the session first
+	m := c.expect(REJECTED, 1, 0)
+	if m.Reason != REJECT_TOO_LATE && m.Reason != REJECT_UNKNOWN {
+		t.Errorf("Expecting a cancel rejected as too late, found %v", m)
+	}
+}
+
+func TestCancelOnDisconnect(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	c := connect(t, g)
+	c.enter(msg.BUY, 1, 10, 10)
+	c.expect(ACCEPTED, 1, 10)
+	c.conn.Close()
+	// Once the session has ended its cancels have been submitted
+	for {
+		g.lock.Lock()
+		n := len(g.conns)
+		g.lock.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c = connect(t, g)
+	c.enter(msg.SELL, 1, 10, 10)
+	c.expect(ACCEPTED, 1, 10)
+	c.send(&Message{Type: CANCEL_ORDER, Token: 1})
+	c.expect(CANCELED, 1, 10)
+}
+
+func TestProtocolViolation(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	c := connect(t, g)
+	c.send(&Message{Type: ACCEPTED})
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := ReadMessage(c.conn, &Message{}); err == nil {
+		t.Errorf("Expecting the gateway to disconnect")
+	}
+}
+
+// Sending to a client which has stopped reading never blocks, the client is disconnected instead
+func TestSlowClientDisconnected(t *testing.T) {
+	g, stop := startGateway(t)
+	defer stop()
+	client, server := net.Pipe()
+	defer client.Close()
+	s := newSession(g, server)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 2*outQueueLen; i++ {
+			s.send(&Message{Type: CANCELED, Order: msg.Message{Kind: msg.CANCELLED, TradeId: uint32(i + 1)}})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sending to a client which doesn't read blocked")
+	}
+	if !s.out.Failed() {
+		t.Errorf("Expecting the client to be disconnected")
+	}
+	s.close()
+}
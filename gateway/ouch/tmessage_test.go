@@ -0,0 +1,69 @@
+package ouch
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/msg"
+	"io"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	o := msg.Message{Kind: msg.BUY, Price: 7, Amount: 100, StockId: 3, TraderId: 2, TradeId: 9}
+	ms := []Message{
+		{Type: ENTER_ORDER, Order: o},
+		{Type: REPLACE_ORDER, Token: 8, Order: o},
+		{Type: CANCEL_ORDER, Token: 8},
+		{Type: ACCEPTED, Order: o},
+		{Type: REPLACED, Token: 8, Order: o},
+		{Type: EXECUTED, Order: o},
+		{Type: CANCELED, Order: o},
+		{Type: REJECTED, Reason: REJECT_TOKEN, Order: o},
+	}
+	var b []byte
+	for i := range ms {
+		b = ms[i].AppendFrame(b)
+	}
+	// Every frame is read, even when the stream delivers a single byte at a time
+	r := &trickleReader{b: b}
+	for i := range ms {
+		read := Message{}
+		if err := ReadMessage(r, &read); err != nil {
+			t.Fatal(err.Error())
+		}
+		if read != ms[i] {
+			t.Errorf("Expecting %v, found %v", &ms[i], &read)
+		}
+	}
+	if err := ReadMessage(r, &Message{}); err != io.EOF {
+		t.Errorf("Expecting EOF, found %v", err)
+	}
+}
+
+func TestReadBadFrames(t *testing.T) {
+	good := (&Message{Type: CANCEL_ORDER, Token: 1}).AppendFrame(nil)
+	bad := map[string][]byte{
+		"short":  good[:len(good)-1],
+		"type":   {1, 0, 'Z'},
+		"length": {2, 0, byte(CANCEL_ORDER), 1},
+		"empty":  {0, 0},
+		"long":   {0xFF, 0xFF},
+	}
+	for name, b := range bad {
+		if err := ReadMessage(bytes.NewReader(b), &Message{}); err == nil || err == io.EOF {
+			t.Errorf("Expecting an error for a %s frame, found %v", name, err)
+		}
+	}
+}
+
+type trickleReader struct {
+	b []byte
+}
+
+func (r *trickleReader) Read(p []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	p[0] = r.b[0]
+	r.b = r.b[1:]
+	return 1, nil
+}
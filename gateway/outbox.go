@@ -0,0 +1,87 @@
+package gateway
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Writes to a client's connection from a goroutine of its own, so that a slow
+// client never blocks the goroutine delivering the matcher's outputs. A client
+// which falls more than queueLen writes behind, or doesn't accept a write
+// within timeout, is disconnected.
+type Outbox struct {
+	conn    net.Conn
+	timeout time.Duration
+	queue   chan []byte
+	lock    sync.Mutex
+	closed  bool
+	failed  bool
+	done    chan struct{}
+}
+
+func NewOutbox(conn net.Conn, queueLen int, timeout time.Duration) *Outbox {
+	o := &Outbox{conn: conn, timeout: timeout, queue: make(chan []byte, queueLen), done: make(chan struct{})}
+	go o.run()
+	return o
+}
+
+// Queues b to be written, b must not be modified afterwards. Never blocks.
+// Returns false if b was dropped, because the outbox is closed or the client
+// has been disconnected.
+func (o *Outbox) Write(b []byte) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.closed || o.failed {
+		return false
+	}
+	select {
+	case o.queue <- b:
+		return true
+	default:
+		o.fail()
+		return false
+	}
+}
+
+// Indicates whether the client has been disconnected, after a write failed or the queue overflowed
+func (o *Outbox) Failed() bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.failed
+}
+
+// Stops accepting writes, and waits until everything queued has been written
+// or the client has been disconnected. The connection is left open otherwise.
+func (o *Outbox) Close() {
+	o.lock.Lock()
+	if !o.closed {
+		o.closed = true
+		close(o.queue)
+	}
+	o.lock.Unlock()
+	<-o.done
+}
+
+func (o *Outbox) run() {
+	defer close(o.done)
+	failed := false
+	for b := range o.queue {
+		if failed {
+			continue // Drained, so that Close never waits on a dead connection
+		}
+		o.conn.SetWriteDeadline(time.Now().Add(o.timeout))
+		if _, err := o.conn.Write(b); err != nil {
+			failed = true
+			o.lock.Lock()
+			o.fail()
+			o.lock.Unlock()
+		}
+	}
+}
+
+// Called holding o.lock. Closing the connection also ends the session reading from it
+func (o *Outbox) fail() {
+	o.failed = true
+	o.conn.Close()
+}
@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/msg"
+	"testing"
+	"time"
+)
+
+// Collects the messages delivered to it, optionally submitting a reply to each
+type recorder struct {
+	h     *Hub
+	ms    chan msg.Message
+	reply bool
+}
+
+func newRecorder(h *Hub, reply bool) *recorder {
+	return &recorder{h: h, ms: make(chan msg.Message, 100), reply: reply}
+}
+
+func (r *recorder) Deliver(m *msg.Message) {
+	r.ms <- *m
+	if r.reply {
+		c := *m
+		c.Kind = msg.CANCEL
+		r.h.Submit(c)
+	}
+}
+
+func (r *recorder) expect(t *testing.T, expected msg.Message) {
+	t.Helper()
+	select {
+	case m := <-r.ms:
+		if m != expected {
+			t.Errorf("Expecting %v, found %v", &expected, &m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %v", &expected)
+	}
+}
+
+func (r *recorder) expectNone(t *testing.T) {
+	t.Helper()
+	select {
+	case m := <-r.ms:
+		t.Errorf("Expecting nothing delivered, found %v", &m)
+	default:
+	}
+}
+
+func TestHubJoinAndWrite(t *testing.T) {
+	h := NewHub(coordinator.NewChanReaderWriter(10))
+	defer h.Shutdown()
+	r1, r2 := newRecorder(h, false), newRecorder(h, false)
+	id1, id2 := h.Join(r1), h.Join(r2)
+	if id1 == 0 || id1 == id2 {
+		t.Fatalf("Expecting distinct non-zero TraderIds, found %d and %d", id1, id2)
+	}
+	m1 := msg.Message{Kind: msg.FULL, Price: 7, Amount: 1, StockId: 1, TraderId: id1, TradeId: 1}
+	m2 := msg.Message{Kind: msg.PARTIAL, Price: 7, Amount: 1, StockId: 1, TraderId: id2, TradeId: 1}
+	h.Write(m1)
+	h.Write(m2)
+	r1.expect(t, m1)
+	r2.expect(t, m2)
+	// Unknown traders, and the matcher's SHUTDOWN, are dropped
+	h.Write(msg.Message{Kind: msg.FULL, Price: 7, Amount: 1, StockId: 1, TraderId: id2 + 1, TradeId: 1})
+	h.Write(msg.Message{Kind: msg.SHUTDOWN})
+	// Nothing is delivered after leaving
+	h.Leave(id1)
+	h.Write(m1)
+	r1.expectNone(t)
+	r2.expectNone(t)
+}
+
+// Submitted messages reach the matcher's input in order, followed by the SHUTDOWN
+func TestHubSubmitOrder(t *testing.T) {
+	in := coordinator.NewChanReaderWriter(200)
+	h := NewHub(in)
+	for i := uint32(1); i <= 100; i++ {
+		h.Submit(msg.Message{Kind: msg.BUY, Price: 7, Amount: 1, StockId: 1, TraderId: 1, TradeId: i})
+	}
+	h.Shutdown()
+	for i := uint32(1); i <= 100; i++ {
+		if m := in.Read(); m.TradeId != i {
+			t.Fatalf("Expecting TradeId %d, found %v", i, &m)
+		}
+	}
+	if m := in.Read(); m.Kind != msg.SHUTDOWN {
+		t.Errorf("Expecting SHUTDOWN, found %v", &m)
+	}
+}
+
+// A trader may submit while a message is delivered to it, even when the matcher's input is full
+func TestHubSubmitWhileDelivering(t *testing.T) {
+	in := coordinator.NewChanReaderWriter(1)
+	h := NewHub(in)
+	r := newRecorder(h, true)
+	id := h.Join(r)
+	done := make(chan bool)
+	go func() {
+		for i := uint32(1); i <= 10; i++ {
+			h.Write(msg.Message{Kind: msg.FULL, Price: 7, Amount: 1, StockId: 1, TraderId: id, TradeId: i})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Delivering blocked on submitting")
+	}
+	for i := uint32(1); i <= 10; i++ {
+		if m := in.Read(); m.Kind != msg.CANCEL || m.TradeId != i {
+			t.Fatalf("Expecting a CANCEL of %d, found %v", i, &m)
+		}
+	}
+	h.Shutdown()
+}
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOutboxWritesInOrder(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	o := NewOutbox(server, 10, 5*time.Second)
+	go func() {
+		for _, s := range []string{"a", "bc", "def"} {
+			o.Write([]byte(s))
+		}
+		// Close waits until everything queued is written
+		o.Close()
+		server.Close()
+	}()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(b) != "abcdef" {
+		t.Errorf("Expecting abcdef, found %q", b)
+	}
+	if o.Write([]byte("g")) {
+		t.Errorf("Expecting a write after Close to be dropped")
+	}
+}
+
+// A client which doesn't read never blocks a writer, it is disconnected once the queue is full
+func TestOutboxOverflowDisconnects(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	o := NewOutbox(server, 10, time.Minute)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			o.Write([]byte("x"))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Writing to a client which doesn't read blocked")
+	}
+	if !o.Failed() {
+		t.Errorf("Expecting the client to be disconnected")
+	}
+	o.Close()
+	// At most a write already in progress is read before the connection ends
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if b, err := io.ReadAll(client); err != nil || len(b) > 1 {
+		t.Errorf("Expecting the connection to be closed, found %q %v", b, err)
+	}
+}
+
+// A write which doesn't complete within the timeout disconnects the client
+func TestOutboxWriteTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	o := NewOutbox(server, 10, 10*time.Millisecond)
+	o.Write([]byte("x"))
+	closed := make(chan bool)
+	go func() {
+		o.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited on a client which doesn't read")
+	}
+	if !o.Failed() {
+		t.Errorf("Expecting the client to be disconnected")
+	}
+}
@@ -2,4 +2,4 @@ module github.com/fmstephe/matching_engine
 
 go 1.18
 
-require github.com/fmstephe/flib v0.0.0-20170802081819-76e5765dde32 // indirect
+require github.com/fmstephe/flib v0.0.0-20170802081819-76e5765dde32
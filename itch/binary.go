@@ -0,0 +1,198 @@
+package itch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The ITCH 5.0 message types which are decoded. Every other type is read and skipped.
+type ItchType byte
+
+const (
+	STOCK_DIRECTORY      = ItchType('R')
+	ADD_ORDER            = ItchType('A')
+	ADD_ORDER_MPID       = ItchType('F')
+	ORDER_EXECUTED       = ItchType('E')
+	ORDER_EXECUTED_PRICE = ItchType('C')
+	ORDER_CANCEL         = ItchType('X')
+	ORDER_DELETE         = ItchType('D')
+	ORDER_REPLACE        = ItchType('U')
+)
+
+func (t ItchType) String() string {
+	switch t {
+	case STOCK_DIRECTORY:
+		return "STOCK_DIRECTORY"
+	case ADD_ORDER:
+		return "ADD_ORDER"
+	case ADD_ORDER_MPID:
+		return "ADD_ORDER_MPID"
+	case ORDER_EXECUTED:
+		return "ORDER_EXECUTED"
+	case ORDER_EXECUTED_PRICE:
+		return "ORDER_EXECUTED_PRICE"
+	case ORDER_CANCEL:
+		return "ORDER_CANCEL"
+	case ORDER_DELETE:
+		return "ORDER_DELETE"
+	case ORDER_REPLACE:
+		return "ORDER_REPLACE"
+	}
+	return fmt.Sprintf("UNKNOWN(%q)", byte(t))
+}
+
+// The length of each decoded message type, as defined by the ITCH 5.0 specification
+var itchLengths = map[ItchType]int{
+	STOCK_DIRECTORY:      39,
+	ADD_ORDER:            36,
+	ADD_ORDER_MPID:       40,
+	ORDER_EXECUTED:       31,
+	ORDER_EXECUTED_PRICE: 36,
+	ORDER_CANCEL:         23,
+	ORDER_DELETE:         19,
+	ORDER_REPLACE:        35,
+}
+
+const (
+	headerLen  = 11 // Type, stock locate, tracking number and timestamp
+	maxItchLen = 1 << 16
+)
+
+// A decoded ITCH 5.0 message. Only the fields carried by Type are set.
+type ItchMessage struct {
+	Type        ItchType
+	Locate      uint16 // Identifies the stock for the day
+	Tracking    uint16
+	Timestamp   uint64 // Nanoseconds since midnight
+	OrderRef    uint64
+	NewOrderRef uint64 // ORDER_REPLACE only
+	Buy         bool
+	Shares      uint32 // Added, executed, cancelled or replacing shares
+	Price       uint32 // With four implied decimal places
+	Symbol      string
+	Attribution string // ADD_ORDER_MPID only
+	MatchNumber uint64
+}
+
+func (m *ItchMessage) String() string {
+	if m == nil {
+		return "<nil>"
+	}
+	side := "S"
+	if m.Buy {
+		side = "B"
+	}
+	return fmt.Sprintf("(%v, locate %d, ref %d, new ref %d, %s, shares %d, price %d, %q)", m.Type, m.Locate, m.OrderRef, m.NewOrderRef, side, m.Shares, m.Price, m.Symbol)
+}
+
+// Reads ITCH 5.0 messages, in NASDAQ's BinaryFILE format, where each message
+// is preceded by its length as a two byte big endian integer.
+type BinaryReader struct {
+	r      *bufio.Reader
+	buf    []byte
+	offset uint64 // Of the next message in the stream
+	count  uint64
+}
+
+func NewBinaryReader(r io.Reader) *BinaryReader {
+	return &BinaryReader{r: bufio.NewReader(r), buf: make([]byte, maxItchLen)}
+}
+
+// The number of messages read, including those which are skipped
+func (br *BinaryReader) Count() uint64 {
+	return br.count
+}
+
+// Reads the next message. Messages of types which are not decoded are
+// returned with only their Type and header fields set. Returns io.EOF once
+// every message has been read, and io.ErrUnexpectedEOF if the stream ends
+// part way through a message.
+func (br *BinaryReader) Next() (*ItchMessage, error) {
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(br.r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	l := int(binary.BigEndian.Uint16(lenBuf[:]))
+	if l == 0 {
+		return nil, errors.New(fmt.Sprintf("Empty ITCH message at offset %d", br.offset))
+	}
+	bs := br.buf[:l]
+	if _, err := io.ReadFull(br.r, bs); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	m, err := decode(bs)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s at offset %d", err.Error(), br.offset))
+	}
+	br.offset += uint64(l + 2)
+	br.count++
+	return m, nil
+}
+
+func decode(bs []byte) (*ItchMessage, error) {
+	t := ItchType(bs[0])
+	expected, known := itchLengths[t]
+	if !known {
+		if len(bs) < headerLen {
+			return &ItchMessage{Type: t}, nil
+		}
+		return decodeHeader(bs), nil
+	}
+	if len(bs) != expected {
+		return nil, errors.New(fmt.Sprintf("%v message must have length %d, found %d", t, expected, len(bs)))
+	}
+	m := decodeHeader(bs)
+	body := bs[headerLen:]
+	switch t {
+	case STOCK_DIRECTORY:
+		m.Symbol = symbol(body[0:8])
+	case ADD_ORDER, ADD_ORDER_MPID:
+		m.OrderRef = binary.BigEndian.Uint64(body[0:8])
+		m.Buy = body[8] == 'B'
+		m.Shares = binary.BigEndian.Uint32(body[9:13])
+		m.Symbol = symbol(body[13:21])
+		m.Price = binary.BigEndian.Uint32(body[21:25])
+		if t == ADD_ORDER_MPID {
+			m.Attribution = symbol(body[25:29])
+		}
+	case ORDER_EXECUTED, ORDER_EXECUTED_PRICE:
+		m.OrderRef = binary.BigEndian.Uint64(body[0:8])
+		m.Shares = binary.BigEndian.Uint32(body[8:12])
+		m.MatchNumber = binary.BigEndian.Uint64(body[12:20])
+		if t == ORDER_EXECUTED_PRICE {
+			m.Price = binary.BigEndian.Uint32(body[21:25])
+		}
+	case ORDER_CANCEL:
+		m.OrderRef = binary.BigEndian.Uint64(body[0:8])
+		m.Shares = binary.BigEndian.Uint32(body[8:12])
+	case ORDER_DELETE:
+		m.OrderRef = binary.BigEndian.Uint64(body[0:8])
+	case ORDER_REPLACE:
+		m.OrderRef = binary.BigEndian.Uint64(body[0:8])
+		m.NewOrderRef = binary.BigEndian.Uint64(body[8:16])
+		m.Shares = binary.BigEndian.Uint32(body[16:20])
+		m.Price = binary.BigEndian.Uint32(body[20:24])
+	}
+	return m, nil
+}
+
+func decodeHeader(bs []byte) *ItchMessage {
+	return &ItchMessage{
+		Type:      ItchType(bs[0]),
+		Locate:    binary.BigEndian.Uint16(bs[1:3]),
+		Tracking:  binary.BigEndian.Uint16(bs[3:5]),
+		Timestamp: uint64(binary.BigEndian.Uint16(bs[5:7]))<<32 | uint64(binary.BigEndian.Uint32(bs[7:11])),
+	}
+}
+
+// Symbols are left aligned and padded with spaces
+func symbol(bs []byte) string {
+	return strings.TrimRight(string(bs), " ")
+}
@@ -0,0 +1,203 @@
+package itch
+
+import (
+	"github.com/fmstephe/matching_engine/msg"
+	"io"
+)
+
+const (
+	// Every resting order in the feed is anonymous, so all are given to this trader
+	BookTraderId = uint32(1)
+	// Executions in the feed are replayed as orders from this trader, crossing the resting order
+	AggressorTraderId = uint32(2)
+)
+
+// Converts ITCH 5.0 messages into the msg.Messages which, submitted to a
+// matcher, rebuild the exchange's book.
+//
+// Each stock's locate code is its StockId. Added orders are given a fresh
+// TradeId, and are cancelled and re-added when partially cancelled or
+// replaced, which loses their time priority. An execution becomes an order
+// from AggressorTraderId at the resting order's price, for the executed shares,
+// so the matcher makes the same execution if its book agrees with the exchange's.
+// The aggressor is cancelled straight away, so that if the books disagree no
+// unfilled remainder is left resting. The matcher answers this cancel with
+// NOT_CANCELLED when the execution was made in full.
+type Converter struct {
+	symbols     map[uint16]string
+	stocks      map[string]uint64
+	orders      map[uint64]*msg.Message // Resting orders by ITCH order reference
+	nextTradeId uint32
+	unknown     uint64
+}
+
+func NewConverter() *Converter {
+	return &Converter{
+		symbols:     make(map[uint16]string),
+		stocks:      make(map[string]uint64),
+		orders:      make(map[uint64]*msg.Message),
+		nextTradeId: 1,
+	}
+}
+
+// The symbol of the stock with stockId, or "" if it has not been seen
+func (c *Converter) Symbol(stockId uint64) string {
+	return c.symbols[uint16(stockId)]
+}
+
+// The StockId of symbol
+func (c *Converter) StockId(symbol string) (uint64, bool) {
+	id, ok := c.stocks[symbol]
+	return id, ok
+}
+
+// The number of messages which referred to an order which was never added,
+// e.g. because it was added before the capture started. These are skipped.
+func (c *Converter) Unknown() uint64 {
+	return c.unknown
+}
+
+// The number of orders resting in the exchange's book
+func (c *Converter) Resting() int {
+	return len(c.orders)
+}
+
+// Appends the messages which apply m to the book to out
+func (c *Converter) Convert(m *ItchMessage, out []msg.Message) []msg.Message {
+	switch m.Type {
+	case STOCK_DIRECTORY:
+		c.addSymbol(m.Locate, m.Symbol)
+	case ADD_ORDER, ADD_ORDER_MPID:
+		c.addSymbol(m.Locate, m.Symbol)
+		kind := msg.SELL
+		if m.Buy {
+			kind = msg.BUY
+		}
+		o := &msg.Message{Kind: kind, Price: uint64(m.Price), Amount: uint64(m.Shares), StockId: uint64(m.Locate), TraderId: BookTraderId}
+		out = c.add(m.OrderRef, o, out)
+	case ORDER_EXECUTED, ORDER_EXECUTED_PRICE:
+		o := c.order(m.OrderRef)
+		if o == nil {
+			return out
+		}
+		kind := msg.BUY
+		if o.Kind == msg.BUY {
+			kind = msg.SELL
+		}
+		a := msg.Message{Kind: kind, Price: o.Price, Amount: uint64(m.Shares), StockId: o.StockId, TraderId: AggressorTraderId, TradeId: c.tradeId()}
+		ac := msg.Message{}
+		ac.WriteCancelFor(&a)
+		out = append(out, a, ac)
+		c.reduce(m.OrderRef, o, uint64(m.Shares))
+	case ORDER_CANCEL:
+		o := c.order(m.OrderRef)
+		if o == nil {
+			return out
+		}
+		out = c.cancel(m.OrderRef, o, out)
+		if o.Amount > uint64(m.Shares) {
+			o.Amount -= uint64(m.Shares)
+			out = c.add(m.OrderRef, o, out)
+		}
+	case ORDER_DELETE:
+		if o := c.order(m.OrderRef); o != nil {
+			out = c.cancel(m.OrderRef, o, out)
+		}
+	case ORDER_REPLACE:
+		o := c.order(m.OrderRef)
+		if o == nil {
+			return out
+		}
+		out = c.cancel(m.OrderRef, o, out)
+		o.Price = uint64(m.Price)
+		o.Amount = uint64(m.Shares)
+		out = c.add(m.NewOrderRef, o, out)
+	}
+	return out
+}
+
+func (c *Converter) addSymbol(locate uint16, symbol string) {
+	c.symbols[locate] = symbol
+	c.stocks[symbol] = uint64(locate)
+}
+
+func (c *Converter) order(ref uint64) *msg.Message {
+	o := c.orders[ref]
+	if o == nil {
+		c.unknown++
+	}
+	return o
+}
+
+func (c *Converter) tradeId() uint32 {
+	id := c.nextTradeId
+	c.nextTradeId++
+	return id
+}
+
+func (c *Converter) add(ref uint64, o *msg.Message, out []msg.Message) []msg.Message {
+	o.TradeId = c.tradeId()
+	c.orders[ref] = o
+	return append(out, *o)
+}
+
+func (c *Converter) cancel(ref uint64, o *msg.Message, out []msg.Message) []msg.Message {
+	delete(c.orders, ref)
+	cm := msg.Message{}
+	cm.WriteCancelFor(o)
+	return append(out, cm)
+}
+
+func (c *Converter) reduce(ref uint64, o *msg.Message, shares uint64) {
+	if o.Amount > shares {
+		o.Amount -= shares
+		return
+	}
+	delete(c.orders, ref)
+}
+
+// Reads an ITCH 5.0 BinaryFILE stream as a sequence of msg.Messages
+type Feed struct {
+	r       *BinaryReader
+	c       *Converter
+	pending []msg.Message
+	next    int
+}
+
+func NewFeed(r io.Reader) *Feed {
+	return &Feed{r: NewBinaryReader(r), c: NewConverter()}
+}
+
+func (f *Feed) Converter() *Converter {
+	return f.c
+}
+
+// Returns the next message, or io.EOF when the stream has ended
+func (f *Feed) Read() (msg.Message, error) {
+	for f.next == len(f.pending) {
+		m, err := f.r.Next()
+		if err != nil {
+			return msg.Message{}, err
+		}
+		f.pending = f.c.Convert(m, f.pending[:0])
+		f.next = 0
+	}
+	m := f.pending[f.next]
+	f.next++
+	return m, nil
+}
+
+// Reads every message in the stream
+func (f *Feed) ReadAll() ([]msg.Message, error) {
+	ms := make([]msg.Message, 0)
+	for {
+		m, err := f.Read()
+		if err == io.EOF {
+			return ms, nil
+		}
+		if err != nil {
+			return ms, err
+		}
+		ms = append(ms, m)
+	}
+}
@@ -2,70 +2,133 @@ package itch
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"github.com/fmstephe/matching_engine/msg"
 	"io"
 	"math"
-	"os"
 	"strconv"
 	"strings"
 )
 
+// A record which could not be parsed
+type ParseError struct {
+	Line   uint
+	Record string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s: %q", e.Line, e.Err.Error(), strings.TrimSpace(e.Record))
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Counts of the records read, by record type. Records too short to have a type are counted under "".
+type Counts struct {
+	Parsed      map[string]uint64
+	Unsupported map[string]uint64 // Record types which don't describe an order, these are always skipped
+	Skipped     map[string]uint64 // Malformed records, skipped in tolerant mode
+}
+
+// Reads whitespace separated text records, one per line, following a line of column headers.
+// Only buy (B), sell (S) and delete (D) records are converted into messages.
 type ItchReader struct {
-	lineCount uint
-	maxBuy    uint64
-	minSell   uint64
-	r         *bufio.Reader
+	lineCount  uint
+	headerRead bool
+	tolerant   bool
+	maxBuy     uint64
+	minSell    uint64
+	counts     Counts
+	r          *bufio.Reader
 }
 
-func NewItchReader(fName string) *ItchReader {
-	f, err := os.Open(fName)
-	if err != nil {
-		panic(err.Error())
-	}
-	r := bufio.NewReader(f)
-	// Clear column headers
-	if _, err := r.ReadString('\n'); err != nil {
-		panic(err.Error())
-	}
-	return &ItchReader{lineCount: 1, minSell: math.MaxInt32, r: r}
+func NewItchReader(r io.Reader) *ItchReader {
+	counts := Counts{Parsed: make(map[string]uint64), Unsupported: make(map[string]uint64), Skipped: make(map[string]uint64)}
+	return &ItchReader{minSell: math.MaxInt32, counts: counts, r: bufio.NewReader(r)}
+}
+
+// In tolerant mode malformed records are counted and skipped, rather than returned as a *ParseError
+func (i *ItchReader) SetTolerant(tolerant bool) {
+	i.tolerant = tolerant
 }
 
+// Returns the next order, and the line it was read from. A *ParseError is
+// returned for a malformed record, after which reading may carry on with the
+// next line. Returns io.EOF once every line has been read.
 func (i *ItchReader) ReadMessage() (o *msg.Message, line string, err error) {
-	i.lineCount++
+	if !i.headerRead {
+		// Clear column headers
+		if _, err = i.readLine(); err != nil {
+			return nil, "", err
+		}
+		i.headerRead = true
+	}
 	for {
-		line, err = i.r.ReadString('\n')
+		line, err = i.readLine()
 		if err != nil {
-			return
+			return nil, "", err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
 		}
-		if line != "" {
-			break
+		var kind string
+		o, kind, err = mkMessage(fields)
+		if err != nil {
+			if !i.tolerant {
+				return nil, line, &ParseError{Line: i.lineCount, Record: line, Err: err}
+			}
+			i.counts.Skipped[kind]++
+			continue
 		}
+		if o == nil {
+			i.counts.Unsupported[kind]++
+			continue
+		}
+		i.counts.Parsed[kind]++
+		break
 	}
-	o, err = mkMessage(line)
-	if o != nil && o.Kind == msg.BUY && o.Price > i.maxBuy {
+	if o.Kind == msg.BUY && o.Price > i.maxBuy {
 		i.maxBuy = o.Price
 	}
-	if o != nil && o.Kind == msg.SELL && o.Price < i.minSell {
+	if o.Kind == msg.SELL && o.Price < i.minSell {
 		i.minSell = o.Price
 	}
-	return
+	return o, line, nil
 }
 
-func (i *ItchReader) ReadAll() (orders []*msg.Message, err error) {
-	orders = make([]*msg.Message, 0)
-	var o *msg.Message
-	for err == nil {
-		o, _, err = i.ReadMessage()
-		if o != nil {
-			orders = append(orders, o)
-		}
-	}
-	if err == io.EOF {
+// Reads a line, including a final line without a newline
+func (i *ItchReader) readLine() (string, error) {
+	line, err := i.r.ReadString('\n')
+	if err == io.EOF && line != "" {
 		err = nil
 	}
-	return
+	if err != nil {
+		return "", err
+	}
+	i.lineCount++
+	return line, nil
+}
+
+// Reads every order, stopping at the first error
+func (i *ItchReader) ReadAll() ([]*msg.Message, error) {
+	orders := make([]*msg.Message, 0)
+	for {
+		o, _, err := i.ReadMessage()
+		if err == io.EOF {
+			return orders, nil
+		}
+		if err != nil {
+			return orders, err
+		}
+		orders = append(orders, o)
+	}
 }
 
+// The number of lines read, including the column headers
 func (i *ItchReader) LineCount() uint {
 	return i.lineCount
 }
@@ -78,45 +141,52 @@ func (i *ItchReader) MinSell() uint64 {
 	return i.minSell
 }
 
-func mkMessage(line string) (o *msg.Message, err error) {
-	ss := strings.Split(line, " ")
-	var useful []string
-	for _, w := range ss {
-		if w != "" && w != "\n" {
-			useful = append(useful, w)
-		}
+// The counts are updated as records are read
+func (i *ItchReader) Counts() Counts {
+	return i.counts
+}
+
+// Returns a nil message, without an error, for records of an unsupported type
+func mkMessage(fields []string) (o *msg.Message, kind string, err error) {
+	if len(fields) < 4 {
+		return nil, "", errors.New(fmt.Sprintf("Expecting at least 4 fields, found %d", len(fields)))
+	}
+	kind = fields[3]
+	if kind != "B" && kind != "S" && kind != "D" {
+		return nil, kind, nil
 	}
-	m, err := mkData(useful)
-	*o = *m
+	if len(fields) < 6 {
+		return nil, kind, errors.New(fmt.Sprintf("Expecting at least 6 fields, found %d", len(fields)))
+	}
+	o, err = mkData(fields)
 	if err != nil {
-		return
+		return nil, kind, err
 	}
-	switch useful[3] {
+	switch kind {
 	case "B":
 		o.Kind = msg.BUY
-		return
 	case "S":
 		o.Kind = msg.SELL
-		return
 	case "D":
 		o.WriteCancelFor(o)
-		return
-	default:
-		return
 	}
-	panic("Unreachable")
+	return o, kind, nil
 }
 
-func mkData(useful []string) (m *msg.Message, err error) {
+func mkData(useful []string) (*msg.Message, error) {
 	//      print("ID: ", useful[2], " Type: ", useful[3], " Price: ",  useful[4], " Amount: ", useful[5])
 	//      println()
-	var price, amount, traderId, tradeId int
-	amount, err = strconv.Atoi(useful[4])
-	price, err = strconv.Atoi(useful[5])
-	traderId, err = strconv.Atoi(useful[2])
-	tradeId, err = strconv.Atoi(useful[2])
+	amount, err := strconv.ParseUint(useful[4], 10, 64)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Bad amount %q", useful[4]))
+	}
+	price, err := strconv.ParseUint(useful[5], 10, 64)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Bad price %q", useful[5]))
+	}
+	id, err := strconv.ParseUint(useful[2], 10, 32)
 	if err != nil {
-		return nil, err
+		return nil, errors.New(fmt.Sprintf("Bad id %q", useful[2]))
 	}
-	return &msg.Message{Price: uint64(price), Amount: uint64(amount), TraderId: uint32(traderId), TradeId: uint32(tradeId), StockId: uint64(1)}, nil
+	return &msg.Message{Price: price, Amount: amount, TraderId: uint32(id), TradeId: uint32(id), StockId: uint64(1)}, nil
 }
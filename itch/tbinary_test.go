@@ -0,0 +1,225 @@
+package itch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/msg"
+	"io"
+	"reflect"
+	"testing"
+)
+
+const (
+	aapl = uint16(5)
+)
+
+// Encodes m as it would appear in a BinaryFILE, preceded by its length
+func encodeItch(m *ItchMessage) []byte {
+	l, ok := itchLengths[m.Type]
+	if !ok {
+		l = headerLen + 1 // Stands in for a message type which isn't decoded
+	}
+	bs := make([]byte, l+2)
+	binary.BigEndian.PutUint16(bs, uint16(l))
+	b := bs[2:]
+	b[0] = byte(m.Type)
+	binary.BigEndian.PutUint16(b[1:], m.Locate)
+	binary.BigEndian.PutUint16(b[3:], m.Tracking)
+	binary.BigEndian.PutUint16(b[5:], uint16(m.Timestamp>>32))
+	binary.BigEndian.PutUint32(b[7:], uint32(m.Timestamp))
+	body := b[headerLen:]
+	switch m.Type {
+	case STOCK_DIRECTORY:
+		putSymbol(body[0:8], m.Symbol)
+	case ADD_ORDER, ADD_ORDER_MPID:
+		binary.BigEndian.PutUint64(body[0:], m.OrderRef)
+		body[8] = 'S'
+		if m.Buy {
+			body[8] = 'B'
+		}
+		binary.BigEndian.PutUint32(body[9:], m.Shares)
+		putSymbol(body[13:21], m.Symbol)
+		binary.BigEndian.PutUint32(body[21:], m.Price)
+		if m.Type == ADD_ORDER_MPID {
+			putSymbol(body[25:29], m.Attribution)
+		}
+	case ORDER_EXECUTED, ORDER_EXECUTED_PRICE:
+		binary.BigEndian.PutUint64(body[0:], m.OrderRef)
+		binary.BigEndian.PutUint32(body[8:], m.Shares)
+		binary.BigEndian.PutUint64(body[12:], m.MatchNumber)
+		if m.Type == ORDER_EXECUTED_PRICE {
+			body[20] = 'Y'
+			binary.BigEndian.PutUint32(body[21:], m.Price)
+		}
+	case ORDER_CANCEL:
+		binary.BigEndian.PutUint64(body[0:], m.OrderRef)
+		binary.BigEndian.PutUint32(body[8:], m.Shares)
+	case ORDER_DELETE:
+		binary.BigEndian.PutUint64(body[0:], m.OrderRef)
+	case ORDER_REPLACE:
+		binary.BigEndian.PutUint64(body[0:], m.OrderRef)
+		binary.BigEndian.PutUint64(body[8:], m.NewOrderRef)
+		binary.BigEndian.PutUint32(body[16:], m.Shares)
+		binary.BigEndian.PutUint32(body[20:], m.Price)
+	}
+	return bs
+}
+
+func putSymbol(bs []byte, s string) {
+	for i := range bs {
+		bs[i] = ' '
+	}
+	copy(bs, s)
+}
+
+func encodeAll(ms []*ItchMessage) []byte {
+	var b bytes.Buffer
+	for _, m := range ms {
+		b.Write(encodeItch(m))
+	}
+	return b.Bytes()
+}
+
+var testFeed = []*ItchMessage{
+	{Type: STOCK_DIRECTORY, Locate: aapl, Tracking: 1, Timestamp: 1 << 40, Symbol: "AAPL"},
+	{Type: ItchType('S'), Locate: 0, Timestamp: 2},
+	{Type: ADD_ORDER, Locate: aapl, Timestamp: 3, OrderRef: 100, Shares: 300, Price: 1000000, Symbol: "AAPL"},
+	{Type: ADD_ORDER_MPID, Locate: aapl, Timestamp: 4, OrderRef: 101, Buy: true, Shares: 200, Price: 990000, Symbol: "AAPL", Attribution: "GSCO"},
+	{Type: ORDER_EXECUTED, Locate: aapl, Timestamp: 5, OrderRef: 100, Shares: 100, MatchNumber: 1},
+	{Type: ORDER_CANCEL, Locate: aapl, Timestamp: 6, OrderRef: 101, Shares: 50},
+	{Type: ORDER_REPLACE, Locate: aapl, Timestamp: 7, OrderRef: 100, NewOrderRef: 102, Shares: 500, Price: 1010000},
+	{Type: ORDER_EXECUTED_PRICE, Locate: aapl, Timestamp: 8, OrderRef: 102, Shares: 500, MatchNumber: 2, Price: 1005000},
+	{Type: ORDER_DELETE, Locate: aapl, Timestamp: 9, OrderRef: 101},
+	{Type: ORDER_DELETE, Locate: aapl, Timestamp: 10, OrderRef: 999},
+}
+
+func TestBinaryDecode(t *testing.T) {
+	r := NewBinaryReader(bytes.NewReader(encodeAll(testFeed)))
+	for _, expected := range testFeed {
+		m, err := r.Next()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !reflect.DeepEqual(expected, m) {
+			t.Errorf("Expecting %v, found %v", expected, m)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Expecting io.EOF, found %v", err)
+	}
+	if r.Count() != uint64(len(testFeed)) {
+		t.Errorf("Expecting %d messages read, found %d", len(testFeed), r.Count())
+	}
+}
+
+func TestBinaryErrors(t *testing.T) {
+	bs := encodeItch(testFeed[2])
+	// Truncated part way through a message
+	if _, err := NewBinaryReader(bytes.NewReader(bs[:20])).Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expecting io.ErrUnexpectedEOF, found %v", err)
+	}
+	// A known message type with the wrong length
+	bad := append([]byte{}, bs...)
+	binary.BigEndian.PutUint16(bad, uint16(len(bs)-3))
+	if _, err := NewBinaryReader(bytes.NewReader(bad[:len(bad)-1])).Next(); err == nil {
+		t.Errorf("Expecting an error for a message with the wrong length")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	f := NewFeed(bytes.NewReader(encodeAll(testFeed)))
+	ms, err := f.ReadAll()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	stockId := uint64(aapl)
+	book, aggressor := BookTraderId, AggressorTraderId
+	expected := []msg.Message{
+		{Kind: msg.SELL, Price: 1000000, Amount: 300, StockId: stockId, TraderId: book, TradeId: 1},
+		{Kind: msg.BUY, Price: 990000, Amount: 200, StockId: stockId, TraderId: book, TradeId: 2},
+		// Executing 100 of the resting sell
+		{Kind: msg.BUY, Price: 1000000, Amount: 100, StockId: stockId, TraderId: aggressor, TradeId: 3},
+		{Kind: msg.CANCEL, Price: 1000000, Amount: 100, StockId: stockId, TraderId: aggressor, TradeId: 3},
+		// Cancelling 50 of the resting buy
+		{Kind: msg.CANCEL, Price: 990000, Amount: 200, StockId: stockId, TraderId: book, TradeId: 2},
+		{Kind: msg.BUY, Price: 990000, Amount: 150, StockId: stockId, TraderId: book, TradeId: 4},
+		// Replacing the resting sell
+		{Kind: msg.CANCEL, Price: 1000000, Amount: 200, StockId: stockId, TraderId: book, TradeId: 1},
+		{Kind: msg.SELL, Price: 1010000, Amount: 500, StockId: stockId, TraderId: book, TradeId: 5},
+		// Executing all of the replacement, at the resting price
+		{Kind: msg.BUY, Price: 1010000, Amount: 500, StockId: stockId, TraderId: aggressor, TradeId: 6},
+		{Kind: msg.CANCEL, Price: 1010000, Amount: 500, StockId: stockId, TraderId: aggressor, TradeId: 6},
+		// Deleting the resting buy
+		{Kind: msg.CANCEL, Price: 990000, Amount: 150, StockId: stockId, TraderId: book, TradeId: 4},
+	}
+	if !reflect.DeepEqual(expected, ms) {
+		t.Errorf("Expecting %v\nfound %v", expected, ms)
+	}
+	c := f.Converter()
+	if id, ok := c.StockId("AAPL"); !ok || id != stockId {
+		t.Errorf("Expecting AAPL to have StockId %d, found %d", stockId, id)
+	}
+	if s := c.Symbol(stockId); s != "AAPL" {
+		t.Errorf("Expecting AAPL, found %q", s)
+	}
+	if c.Unknown() != 1 {
+		t.Errorf("Expecting 1 unknown order, found %d", c.Unknown())
+	}
+	if c.Resting() != 0 {
+		t.Errorf("Expecting no resting orders, found %d", c.Resting())
+	}
+	expectMatcherAgrees(t, ms)
+}
+
+// When the matcher's book disagrees with the feed, the aggressor's remainder is cancelled rather than left resting
+func TestConvertCancelsAggressorRemainder(t *testing.T) {
+	c := NewConverter()
+	add := c.Convert(&ItchMessage{Type: ADD_ORDER, Locate: aapl, OrderRef: 100, Shares: 300, Price: 1000000, Symbol: "AAPL"}, nil)
+	exec := c.Convert(&ItchMessage{Type: ORDER_EXECUTED, Locate: aapl, OrderRef: 100, Shares: 100}, nil)
+	m := matcher.NewMatcher(100)
+	m.Config("ITCH", coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+	// The book only holds 40 of the 100 shares executed
+	short := add[0]
+	short.Amount = 40
+	m.SubmitBatch([]msg.Message{short}, nil)
+	outs := m.SubmitBatch(exec, nil)
+	aggressor := exec[0]
+	expected := []msg.Message{
+		{Kind: msg.PARTIAL, Price: 1000000, Amount: 40, StockId: uint64(aapl), TraderId: AggressorTraderId, TradeId: aggressor.TradeId},
+		{Kind: msg.FULL, Price: 1000000, Amount: 40, StockId: uint64(aapl), TraderId: BookTraderId, TradeId: add[0].TradeId},
+		{Kind: msg.CANCELLED, Price: 1000000, Amount: 60, StockId: uint64(aapl), TraderId: AggressorTraderId, TradeId: aggressor.TradeId},
+	}
+	if !reflect.DeepEqual(expected, outs) {
+		t.Errorf("Expecting %v\nfound %v", expected, outs)
+	}
+	if buys, sells := m.Survey(uint64(aapl), 1); len(buys) != 0 || len(sells) != 0 {
+		t.Errorf("Expecting an empty book, found %v %v", buys, sells)
+	}
+}
+
+// The matcher makes every execution from the feed, and every cancel finds its order
+func expectMatcherAgrees(t *testing.T, ms []msg.Message) {
+	m := matcher.NewMatcher(100)
+	m.Config("ITCH", coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+	executed := uint64(0)
+	for _, o := range m.SubmitBatch(ms, nil) {
+		switch o.Kind {
+		case msg.REJECTED:
+			t.Errorf("Unexpected %v", &o)
+		case msg.NOT_CANCELLED, msg.CANCELLED:
+			// Only the aggressors' cancels may find nothing left to cancel
+			if (o.Kind == msg.NOT_CANCELLED) != (o.TraderId == AggressorTraderId) {
+				t.Errorf("Unexpected %v", &o)
+			}
+		case msg.FULL, msg.PARTIAL:
+			if o.TraderId == AggressorTraderId {
+				executed += o.Amount
+			}
+		}
+	}
+	if executed != 600 {
+		t.Errorf("Expecting 600 shares executed, found %d", executed)
+	}
+}
@@ -0,0 +1,103 @@
+package itch
+
+import (
+	"errors"
+	"github.com/fmstephe/matching_engine/msg"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const dirtyCapture = "Time Seq Id Type Amount Price\n" +
+	"0 1 1 B 10 100\n" +
+	"0 2 2 X 5 101\n" +
+	"\n" +
+	"0 3 3 S ten 101\n" +
+	"0 4 4 S\n" +
+	"0 5\n" +
+	"0 6 1 D 10 100\n" +
+	"0 7 5 E 10 100\n" +
+	"0 8 6 S 3 99"
+
+func TestReadStrict(t *testing.T) {
+	r := NewItchReader(strings.NewReader(dirtyCapture))
+	o, _, err := r.ReadMessage()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := &msg.Message{Kind: msg.BUY, Price: 100, Amount: 10, TraderId: 1, TradeId: 1, StockId: 1}
+	if !reflect.DeepEqual(expected, o) {
+		t.Errorf("Expecting %v, found %v", expected, o)
+	}
+	// The unsupported X record is skipped, each malformed record is an error
+	causes := map[uint]string{
+		5: "Bad amount \"ten\"",
+		6: "Expecting at least 6 fields, found 4",
+		7: "Expecting at least 4 fields, found 2",
+	}
+	for _, line := range []uint{5, 6, 7} {
+		_, _, err := r.ReadMessage()
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("Expecting a *ParseError, found %v", err)
+		}
+		if perr.Line != line {
+			t.Errorf("Expecting an error on line %d, found %v", line, perr)
+		}
+		if !strings.HasPrefix(perr.Error(), "line ") {
+			t.Errorf("Expecting the error to start with its line, found %q", perr.Error())
+		}
+		if perr.Err.Error() != causes[line] {
+			t.Errorf("Expecting %q on line %d, found %q", causes[line], line, perr.Err.Error())
+		}
+	}
+	// Reading carries on after an error
+	if o, _, err := r.ReadMessage(); err != nil || o.Kind != msg.CANCEL {
+		t.Errorf("Expecting CANCEL, found %v %v", o, err)
+	}
+	if _, err := r.ReadAll(); err != nil {
+		t.Errorf("Expecting no error, found %v", err)
+	}
+	if r.LineCount() != 10 {
+		t.Errorf("Expecting 10 lines read, found %d", r.LineCount())
+	}
+}
+
+func TestReadTolerant(t *testing.T) {
+	r := NewItchReader(strings.NewReader(dirtyCapture))
+	r.SetTolerant(true)
+	orders, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := []*msg.Message{
+		{Kind: msg.BUY, Price: 100, Amount: 10, TraderId: 1, TradeId: 1, StockId: 1},
+		{Kind: msg.CANCEL, Price: 100, Amount: 10, TraderId: 1, TradeId: 1, StockId: 1},
+		{Kind: msg.SELL, Price: 99, Amount: 3, TraderId: 6, TradeId: 6, StockId: 1},
+	}
+	if !reflect.DeepEqual(expected, orders) {
+		t.Errorf("Expecting %v, found %v", expected, orders)
+	}
+	counts := r.Counts()
+	expectCounts(t, "parsed", map[string]uint64{"B": 1, "D": 1, "S": 1}, counts.Parsed)
+	expectCounts(t, "unsupported", map[string]uint64{"X": 1, "E": 1}, counts.Unsupported)
+	expectCounts(t, "skipped", map[string]uint64{"S": 2, "": 1}, counts.Skipped)
+	if r.MaxBuy() != 100 || r.MinSell() != 99 {
+		t.Errorf("Expecting max buy 100 and min sell 99, found %d and %d", r.MaxBuy(), r.MinSell())
+	}
+}
+
+func TestReadEmpty(t *testing.T) {
+	for _, capture := range []string{"", "Time Seq Id Type Amount Price\n", "Time Seq Id Type Amount Price"} {
+		if _, _, err := NewItchReader(strings.NewReader(capture)).ReadMessage(); err != io.EOF {
+			t.Errorf("%q: Expecting io.EOF, found %v", capture, err)
+		}
+	}
+}
+
+func expectCounts(t *testing.T, name string, expected, found map[string]uint64) {
+	if !reflect.DeepEqual(expected, found) {
+		t.Errorf("Expecting %s counts %v, found %v", name, expected, found)
+	}
+}
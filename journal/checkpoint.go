@@ -0,0 +1,201 @@
+package journal
+
+import (
+	"fmt"
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/msg"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	checkpointPrefix = "checkpoint-"
+	checkpointSuffix = ".snap"
+)
+
+type CheckpointConfig struct {
+	Dir      string
+	Every    uint64        // Messages between checkpoints, 0 disables
+	Interval time.Duration // Time between checkpoints, 0 disables
+	Keep     int           // The number of checkpoints kept, at least 1
+	Archive  string        // If set, journal segments made obsolete by a checkpoint are moved here rather than deleted
+}
+
+// A journalling MsgReader which also checkpoints the matcher reading from it.
+// A matcher only reads its next message once it has finished processing the
+// previous one, so a checkpoint taken inside Read is a consistent view of
+// every message journalled so far. Once a checkpoint is written the journal
+// segments it covers are archived or deleted.
+type CheckpointReader struct {
+	Reader
+	m     *matcher.M
+	cfg   CheckpointConfig
+	last  uint64 // Sequence number covered by the last checkpoint
+	lastT time.Time
+}
+
+func NewCheckpointReader(in coordinator.MsgReader, j *Journal, m *matcher.M, cfg CheckpointConfig) *CheckpointReader {
+	if cfg.Keep < 1 {
+		cfg.Keep = 1
+	}
+	return &CheckpointReader{Reader: Reader{in: in, j: j}, m: m, cfg: cfg, last: j.Next() - 1, lastT: time.Now()}
+}
+
+func (r *CheckpointReader) Read() msg.Message {
+	if r.due() {
+		if err := r.Checkpoint(); err != nil {
+			panic(err.Error())
+		}
+	}
+	return r.Reader.Read()
+}
+
+func (r *CheckpointReader) due() bool {
+	covered := r.j.Next() - 1
+	if covered == r.last {
+		return false
+	}
+	if r.cfg.Every != 0 && covered-r.last >= r.cfg.Every {
+		return true
+	}
+	return r.cfg.Interval != 0 && time.Since(r.lastT) >= r.cfg.Interval
+}
+
+// Writes a checkpoint covering every journalled message, then discards
+// obsolete journal segments and checkpoints.
+// Must only be called when the matcher is not processing a message.
+func (r *CheckpointReader) Checkpoint() error {
+	covered := r.j.Next() - 1
+	if err := r.j.Sync(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.cfg.Dir, 0755); err != nil {
+		return err
+	}
+	if err := r.m.SnapshotFile(checkpointPath(r.cfg.Dir, covered)); err != nil {
+		return err
+	}
+	r.last = covered
+	r.lastT = time.Now()
+	// Pruning syncs the directory, so the new checkpoint is durable before any segment is removed
+	oldest, err := pruneCheckpoints(r.cfg.Dir, r.cfg.Keep)
+	if err != nil {
+		return err
+	}
+	// Keep every message needed to recover from any of the remaining checkpoints
+	return r.j.Truncate(oldest, r.cfg.Archive)
+}
+
+// Archives, or deletes, every journal segment containing only messages up to and including seq.
+// The segment currently being written is never removed.
+func (j *Journal) Truncate(seq uint64, archive string) error {
+	segs, err := segments(j.cfg.Dir)
+	if err != nil {
+		return err
+	}
+	if archive != "" {
+		if err := os.MkdirAll(archive, 0755); err != nil {
+			return err
+		}
+	}
+	for i := 0; i+1 < len(segs) && segs[i+1].first <= seq+1; i++ {
+		if archive != "" {
+			err = os.Rename(segs[i].path, filepath.Join(archive, filepath.Base(segs[i].path)))
+		} else {
+			err = os.Remove(segs[i].path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// Make the archived segments durable before their removal from the journal
+	if archive != "" {
+		if err := syncDir(archive); err != nil {
+			return err
+		}
+	}
+	return syncDir(j.cfg.Dir)
+}
+
+func checkpointPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d%s", checkpointPrefix, seq, checkpointSuffix))
+}
+
+type checkpoint struct {
+	path string
+	seq  uint64
+}
+
+// Lists the checkpoints in dir, most recent first
+func checkpoints(dir string) ([]checkpoint, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cps := make([]checkpoint, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, checkpointPrefix) || !strings.HasSuffix(name, checkpointSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, checkpointPrefix), checkpointSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		cps = append(cps, checkpoint{path: filepath.Join(dir, name), seq: seq})
+	}
+	sort.Slice(cps, func(i, j int) bool { return cps[i].seq > cps[j].seq })
+	return cps, nil
+}
+
+// Removes all but the keep most recent checkpoints, returning the sequence
+// number covered by the oldest checkpoint remaining
+func pruneCheckpoints(dir string, keep int) (uint64, error) {
+	cps, err := checkpoints(dir)
+	if err != nil || len(cps) == 0 {
+		return 0, err
+	}
+	for i := keep; i < len(cps); i++ {
+		if err := os.Remove(cps[i].path); err != nil {
+			return 0, err
+		}
+	}
+	if err := syncDir(dir); err != nil {
+		return 0, err
+	}
+	if keep > len(cps) {
+		keep = len(cps)
+	}
+	return cps[keep-1].seq, nil
+}
+
+// Restores m from the latest readable checkpoint in cfg.Dir, replays the
+// journal messages following it and then opens the journal for appending.
+// Without a checkpoint the whole journal is replayed.
+// As with Recover, outputs of replayed messages are discarded.
+func RecoverCheckpoint(jcfg Config, cfg CheckpointConfig, m *matcher.M) (*Journal, error) {
+	cps, err := checkpoints(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	from := uint64(1)
+	for _, cp := range cps {
+		// A checkpoint which can't be read is skipped in favour of an older one
+		if err := m.RestoreFile(cp.path); err == nil {
+			from = cp.seq + 1
+			break
+		}
+	}
+	if _, err := ReplayInto(jcfg.Dir, from, m); err != nil {
+		return nil, err
+	}
+	return Open(jcfg)
+}
@@ -0,0 +1,309 @@
+package journal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/fmstephe/matching_engine/msg"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A journal is a directory of segment files. Each segment is named after the
+// sequence number of its first message and is laid out as
+//
+//	header:   magic (8 bytes), version (4 bytes), first sequence number (8 bytes)
+//	messages: each message marshalled by msg.Message.Marshal (msg.ByteSize bytes)
+//
+// Sequence numbers start at 1.
+const (
+	segmentMagic      = "MEJRNL\x00\x00"
+	SegmentVersion    = uint32(1)
+	segmentHeaderSize = 20
+	segmentSuffix     = ".journal"
+)
+
+var binCoder = binary.LittleEndian
+
+type SyncPolicy byte
+
+const (
+	// Messages are written to the OS as they arrive, segments are synced when they are closed
+	SYNC_NONE = SyncPolicy(iota)
+	// Segments are synced after every SyncEvery messages
+	SYNC_BATCH = SyncPolicy(iota)
+	// Segments are synced after every message
+	SYNC_ALWAYS = SyncPolicy(iota)
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SYNC_NONE:
+		return "SYNC_NONE"
+	case SYNC_BATCH:
+		return "SYNC_BATCH"
+	case SYNC_ALWAYS:
+		return "SYNC_ALWAYS"
+	}
+	panic("Bad Value")
+}
+
+type Config struct {
+	Dir         string
+	SegmentSize uint64 // The number of messages written to each segment
+	Sync        SyncPolicy
+	SyncEvery   uint64 // Used by SYNC_BATCH
+}
+
+// An append only journal of msg.Messages.
+// Is not Thread-Safe, Write must only be called by a single writer.
+type Journal struct {
+	cfg      Config
+	next     uint64 // Sequence number of the next message written
+	f        *os.File
+	written  uint64 // Messages written to the current segment
+	unsynced uint64
+	buf      []byte
+}
+
+// Opens the journal in cfg.Dir, creating the directory if necessary.
+// Messages are appended to a new segment following any existing segments.
+func Open(cfg Config) (*Journal, error) {
+	if cfg.SegmentSize == 0 {
+		return nil, errors.New("Journal segment size must be greater than 0")
+	}
+	if cfg.Sync == SYNC_BATCH && cfg.SyncEvery == 0 {
+		return nil, errors.New("Journal SYNC_BATCH requires SyncEvery greater than 0")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := repair(cfg.Dir); err != nil {
+		return nil, err
+	}
+	next, err := NextSeq(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{cfg: cfg, next: next, buf: make([]byte, msg.ByteSize)}, nil
+}
+
+// The sequence number which will be given to the next message written
+func (j *Journal) Next() uint64 {
+	return j.next
+}
+
+// Appends m to the journal, returning its sequence number
+func (j *Journal) Append(m *msg.Message) (uint64, error) {
+	if j.f == nil || j.written == j.cfg.SegmentSize {
+		if err := j.roll(); err != nil {
+			return 0, err
+		}
+	}
+	if err := m.Marshal(j.buf); err != nil {
+		return 0, err
+	}
+	if _, err := j.f.Write(j.buf); err != nil {
+		return 0, err
+	}
+	seq := j.next
+	j.next++
+	j.written++
+	j.unsynced++
+	if j.cfg.Sync == SYNC_ALWAYS || (j.cfg.Sync == SYNC_BATCH && j.unsynced >= j.cfg.SyncEvery) {
+		if err := j.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	return seq, nil
+}
+
+// Appends m to the journal, panics if the message can't be written.
+// Allows a Journal to be used as a coordinator.MsgWriter.
+func (j *Journal) Write(m msg.Message) {
+	if _, err := j.Append(&m); err != nil {
+		panic(err.Error())
+	}
+}
+
+// Forces every message written so far to stable storage
+func (j *Journal) Sync() error {
+	j.unsynced = 0
+	if j.f == nil {
+		return nil
+	}
+	return j.f.Sync()
+}
+
+// Syncs and closes the current segment
+func (j *Journal) Close() error {
+	if j.f == nil {
+		return nil
+	}
+	err := j.Sync()
+	if cerr := j.f.Close(); err == nil {
+		err = cerr
+	}
+	j.f = nil
+	return err
+}
+
+func (j *Journal) roll() error {
+	if err := j.Close(); err != nil {
+		return err
+	}
+	path := segmentPath(j.cfg.Dir, j.next)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return j.reopen(path)
+	}
+	if err != nil {
+		return err
+	}
+	header := make([]byte, segmentHeaderSize)
+	copy(header, segmentMagic)
+	binCoder.PutUint32(header[8:12], SegmentVersion)
+	binCoder.PutUint64(header[12:20], j.next)
+	if _, err := f.Write(header); err != nil {
+		f.Close()
+		return err
+	}
+	// Make sure the new segment is visible in the directory
+	if err := syncDir(j.cfg.Dir); err != nil {
+		f.Close()
+		return err
+	}
+	j.f = f
+	j.written = 0
+	return nil
+}
+
+// A crash, or a torn first message being repaired, can leave the final
+// segment holding only its header. Messages are appended to it.
+func (j *Journal) reopen(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() != segmentHeaderSize {
+		err = errors.New(fmt.Sprintf("Segment %s already holds messages", path))
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	j.f = f
+	j.written = 0
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func segmentPath(dir string, first uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", first, segmentSuffix))
+}
+
+type segment struct {
+	path  string
+	first uint64
+}
+
+// Lists the segments in dir, in sequence order
+func segments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	segs := make([]segment, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segs = append(segs, segment{path: filepath.Join(dir, name), first: first})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].first < segs[j].first })
+	return segs, nil
+}
+
+// Removes anything following the last complete message in the final segment.
+// A crash can leave a partially written message, which would otherwise be
+// stranded in the middle of the journal once a new segment is written.
+func repair(dir string) error {
+	segs, err := segments(dir)
+	if err != nil || len(segs) == 0 {
+		return err
+	}
+	last := segs[len(segs)-1]
+	info, err := os.Stat(last.path)
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size < segmentHeaderSize {
+		if err := os.Remove(last.path); err != nil {
+			return err
+		}
+		return syncDir(dir)
+	}
+	complete := size - (size-segmentHeaderSize)%msg.ByteSize
+	if complete != size {
+		return truncateFile(last.path, complete)
+	}
+	return nil
+}
+
+// Truncates the file at path to size, syncing it so the truncation survives a crash
+func truncateFile(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(size)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Returns the sequence number following the last complete message in the journal in dir
+func NextSeq(dir string) (uint64, error) {
+	segs, err := segments(dir)
+	if err != nil {
+		return 0, err
+	}
+	if len(segs) == 0 {
+		return 1, nil
+	}
+	last := segs[len(segs)-1]
+	info, err := os.Stat(last.path)
+	if err != nil {
+		return 0, err
+	}
+	count := uint64(0)
+	if info.Size() > segmentHeaderSize {
+		count = uint64(info.Size()-segmentHeaderSize) / msg.ByteSize
+	}
+	return last.first + count, nil
+}
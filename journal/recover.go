@@ -0,0 +1,52 @@
+package journal
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/msg"
+)
+
+// A MsgReader which appends every message to a journal before passing it on.
+// Placed between a matcher and its input, every message the matcher
+// processes is journalled before it is processed.
+type Reader struct {
+	in coordinator.MsgReader
+	j  *Journal
+}
+
+func NewReader(in coordinator.MsgReader, j *Journal) *Reader {
+	return &Reader{in: in, j: j}
+}
+
+func (r *Reader) Read() msg.Message {
+	m := r.in.Read()
+	r.j.Write(m)
+	return m
+}
+
+// Replays the journal in cfg.Dir through m, then opens the journal for appending.
+// The outputs of replayed messages were published before the restart, so they
+// are discarded. Recover should be called before the matcher's depth and
+// order feed writers are configured.
+func Recover(cfg Config, m *matcher.M) (*Journal, error) {
+	if _, err := ReplayInto(cfg.Dir, 1, m); err != nil {
+		return nil, err
+	}
+	return Open(cfg)
+}
+
+// Submits every journalled message from sequence number from onwards to m,
+// discarding the outputs. Returns the sequence number following the last
+// message replayed.
+func ReplayInto(dir string, from uint64, m *matcher.M) (uint64, error) {
+	out := m.Out
+	m.Out = coordinator.NewNoopReaderWriter()
+	defer func() {
+		m.Out = out
+	}()
+	return Replay(dir, from, func(seq uint64, o *msg.Message) {
+		if o.Kind != msg.SHUTDOWN {
+			m.Submit(o)
+		}
+	})
+}
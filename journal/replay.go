@@ -0,0 +1,85 @@
+package journal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/fmstephe/matching_engine/msg"
+	"io"
+	"os"
+)
+
+// Calls f, in order, with every message in the journal in dir whose sequence
+// number is at least from. A partially written message at the end of the last
+// segment, left by a crash, is ignored. Returns the sequence number following
+// the last message replayed.
+func Replay(dir string, from uint64, f func(seq uint64, m *msg.Message)) (uint64, error) {
+	segs, err := segments(dir)
+	if err != nil {
+		return 0, err
+	}
+	next := from
+	if len(segs) > 0 && segs[0].first > from {
+		return 0, errors.New(fmt.Sprintf("Journal starts at %d, can't replay from %d", segs[0].first, from))
+	}
+	for i, seg := range segs {
+		if i+1 < len(segs) && segs[i+1].first <= from {
+			continue // Every message in this segment precedes from
+		}
+		if seg.first > next {
+			return 0, errors.New(fmt.Sprintf("Journal is missing messages %d to %d", next, seg.first-1))
+		}
+		last := i == len(segs)-1
+		if next, err = replaySegment(seg, next, last, f); err != nil {
+			return 0, err
+		}
+	}
+	return next, nil
+}
+
+func replaySegment(seg segment, from uint64, last bool, f func(seq uint64, m *msg.Message)) (uint64, error) {
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	header := make([]byte, segmentHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if last && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			return from, nil // The segment was created but the header never completely written
+		}
+		return 0, errors.New(fmt.Sprintf("%s: reading header: %s", seg.path, err.Error()))
+	}
+	if string(header[:8]) != segmentMagic {
+		return 0, errors.New(fmt.Sprintf("%s: not a journal segment", seg.path))
+	}
+	if version := binCoder.Uint32(header[8:12]); version != SegmentVersion {
+		return 0, errors.New(fmt.Sprintf("%s: unsupported version. Expecting %d, found %d", seg.path, SegmentVersion, version))
+	}
+	if first := binCoder.Uint64(header[12:20]); first != seg.first {
+		return 0, errors.New(fmt.Sprintf("%s: header names first message %d", seg.path, first))
+	}
+	b := make([]byte, msg.ByteSize)
+	m := &msg.Message{}
+	seq := seg.first
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			if err == io.EOF || (last && err == io.ErrUnexpectedEOF) {
+				break
+			}
+			return 0, errors.New(fmt.Sprintf("%s: reading message %d: %s", seg.path, seq, err.Error()))
+		}
+		if seq >= from {
+			if err := m.Unmarshal(b); err != nil {
+				return 0, err
+			}
+			f(seq, m)
+		}
+		seq++
+	}
+	if seq < from {
+		return from, nil
+	}
+	return seq, nil
+}
@@ -0,0 +1,80 @@
+package journal
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func runCheckpointed(t *testing.T, jcfg Config, ccfg CheckpointConfig, n int) *matcher.M {
+	ms := testMsgs(t, n)
+	m := matcher.NewMatcher(100)
+	j, err := RecoverCheckpoint(jcfg, ccfg, m)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	in := NewCheckpointReader(coordinator.NewPreloadedReaderWriter(ms), j, m, ccfg)
+	m.Config("Checkpointed", in, coordinator.NewShutdownReaderWriter())
+	m.Run()
+	j.Close()
+	return m
+}
+
+func recoverCheckpointed(t *testing.T, jcfg Config, ccfg CheckpointConfig) *matcher.M {
+	m := matcher.NewMatcher(100)
+	j, err := RecoverCheckpoint(jcfg, ccfg, m)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	j.Close()
+	return m
+}
+
+func TestCheckpointTruncatesJournal(t *testing.T) {
+	jcfg := Config{Dir: t.TempDir(), SegmentSize: 20}
+	ccfg := CheckpointConfig{Dir: t.TempDir(), Every: 50, Keep: 2}
+	orig := runCheckpointed(t, jcfg, ccfg, 500)
+	cps, _ := checkpoints(ccfg.Dir)
+	if len(cps) != 2 {
+		t.Errorf("Expecting 2 checkpoints, found %d", len(cps))
+	}
+	segs, _ := segments(jcfg.Dir)
+	if len(segs) == 0 || segs[0].first == 1 || segs[0].first > cps[1].seq+1 {
+		t.Errorf("Expecting journal truncated up to %d, found %v", cps[1].seq, segs)
+	}
+	expectSameState(t, orig, recoverCheckpointed(t, jcfg, ccfg))
+	// An unreadable checkpoint falls back to the previous one
+	os.WriteFile(cps[0].path, []byte("corrupt"), 0644)
+	expectSameState(t, orig, recoverCheckpointed(t, jcfg, ccfg))
+}
+
+func TestCheckpointArchivesJournal(t *testing.T) {
+	jcfg := Config{Dir: t.TempDir(), SegmentSize: 10}
+	ccfg := CheckpointConfig{Dir: t.TempDir(), Every: 100, Archive: t.TempDir()}
+	orig := runCheckpointed(t, jcfg, ccfg, 100)
+	archived, _ := segments(ccfg.Archive)
+	if len(archived) == 0 {
+		t.Errorf("Expecting archived journal segments")
+	}
+	expectSameState(t, orig, recoverCheckpointed(t, jcfg, ccfg))
+	// The archived and remaining segments together form the complete journal
+	for _, seg := range archived {
+		os.Rename(seg.path, filepath.Join(jcfg.Dir, filepath.Base(seg.path)))
+	}
+	replayed := matcher.NewMatcher(100)
+	if _, err := ReplayInto(jcfg.Dir, 1, replayed); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectSameState(t, orig, replayed)
+}
+
+func TestRecoverCheckpointContinues(t *testing.T) {
+	jcfg := Config{Dir: t.TempDir(), SegmentSize: 20}
+	ccfg := CheckpointConfig{Dir: t.TempDir(), Every: 30}
+	runCheckpointed(t, jcfg, ccfg, 100)
+	// A second run after a restart continues from the recovered state
+	second := runCheckpointed(t, jcfg, ccfg, 100)
+	expectSameState(t, second, recoverCheckpointed(t, jcfg, ccfg))
+}
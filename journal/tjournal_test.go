@@ -0,0 +1,179 @@
+package journal
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/msg"
+	"os"
+	"testing"
+)
+
+var journalMaker = msg.NewMessageMaker(1)
+
+func testMsgs(t *testing.T, n int) []msg.Message {
+	ms, err := journalMaker.RndTradeSet(n, n/10, 1, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return ms
+}
+
+func writeAll(t *testing.T, cfg Config, ms []msg.Message) {
+	j, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := range ms {
+		j.Write(ms[i])
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func replayAll(t *testing.T, dir string, from uint64) []msg.Message {
+	ms := make([]msg.Message, 0)
+	expected := from
+	_, err := Replay(dir, from, func(seq uint64, m *msg.Message) {
+		if seq != expected {
+			t.Errorf("Expecting sequence number %d, found %d", expected, seq)
+		}
+		expected++
+		ms = append(ms, *m)
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return ms
+}
+
+func expectMsgs(t *testing.T, expected, found []msg.Message) {
+	if len(expected) != len(found) {
+		t.Errorf("Expecting %d messages, found %d", len(expected), len(found))
+		return
+	}
+	for i := range expected {
+		if expected[i] != found[i] {
+			t.Errorf("Expecting %v, found %v", &expected[i], &found[i])
+			return
+		}
+	}
+}
+
+func TestAppendReplay(t *testing.T) {
+	for _, sync := range []SyncPolicy{SYNC_NONE, SYNC_BATCH, SYNC_ALWAYS} {
+		cfg := Config{Dir: t.TempDir(), SegmentSize: 7, Sync: sync, SyncEvery: 3}
+		ms := testMsgs(t, 20)
+		writeAll(t, cfg, ms)
+		expectMsgs(t, ms, replayAll(t, cfg.Dir, 1))
+		expectMsgs(t, ms[10:], replayAll(t, cfg.Dir, 11))
+		expectMsgs(t, ms[14:], replayAll(t, cfg.Dir, 15))
+		expectMsgs(t, []msg.Message{}, replayAll(t, cfg.Dir, uint64(len(ms)+1)))
+	}
+}
+
+func TestReopenContinuesSequence(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), SegmentSize: 5}
+	ms := testMsgs(t, 20)
+	writeAll(t, cfg, ms[:13])
+	writeAll(t, cfg, ms[13:])
+	expectMsgs(t, ms, replayAll(t, cfg.Dir, 1))
+	next, err := NextSeq(cfg.Dir)
+	if err != nil || next != uint64(len(ms)+1) {
+		t.Errorf("Expecting next sequence %d, found %d (%v)", len(ms)+1, next, err)
+	}
+}
+
+func TestTornWriteIsRepaired(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), SegmentSize: 100}
+	ms := testMsgs(t, 10)
+	writeAll(t, cfg, ms[:5])
+	// Simulate a crash part way through writing a message
+	f, err := os.OpenFile(segmentPath(cfg.Dir, 1), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	f.Write(make([]byte, msg.ByteSize/2))
+	f.Close()
+	expectMsgs(t, ms[:5], replayAll(t, cfg.Dir, 1))
+	writeAll(t, cfg, ms[5:])
+	expectMsgs(t, ms, replayAll(t, cfg.Dir, 1))
+}
+
+// A segment whose only message was torn is left holding just its header, and is appended to
+func TestHeaderOnlySegmentIsReused(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), SegmentSize: 5}
+	ms := testMsgs(t, 10)
+	writeAll(t, cfg, ms[:5])
+	writeAll(t, cfg, ms[5:6])
+	if err := truncateFile(segmentPath(cfg.Dir, 6), segmentHeaderSize+msg.ByteSize/2); err != nil {
+		t.Fatal(err.Error())
+	}
+	j, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if j.Next() != 6 {
+		t.Errorf("Expecting next sequence 6, found %d", j.Next())
+	}
+	for i := 5; i < len(ms); i++ {
+		if _, err := j.Append(&ms[i]); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectMsgs(t, ms, replayAll(t, cfg.Dir, 1))
+}
+
+func TestMissingSegment(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), SegmentSize: 5}
+	writeAll(t, cfg, testMsgs(t, 20))
+	os.Remove(segmentPath(cfg.Dir, 6))
+	if _, err := Replay(cfg.Dir, 1, func(uint64, *msg.Message) {}); err == nil {
+		t.Errorf("Expected error replaying journal with missing segment")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	cfg := Config{Dir: t.TempDir(), SegmentSize: 64, Sync: SYNC_BATCH, SyncEvery: 16}
+	ms := testMsgs(t, 500)
+	half := len(ms) / 2
+	// Run a matcher over half the messages, journalling its input
+	orig := matcher.NewMatcher(100)
+	j, err := Recover(cfg, orig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	in := NewReader(coordinator.NewPreloadedReaderWriter(ms[:half]), j)
+	orig.Config("Original", in, coordinator.NewShutdownReaderWriter())
+	orig.Run()
+	j.Close()
+	// Recover a new matcher from the journal
+	recovered := matcher.NewMatcher(100)
+	recovered.Config("Recovered", coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+	rj, err := Recover(cfg, recovered)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer rj.Close()
+	if rj.Next() != uint64(half+2) { // Every message and the final SHUTDOWN
+		t.Errorf("Expecting next sequence %d, found %d", half+2, rj.Next())
+	}
+	expectSameState(t, orig, recovered)
+}
+
+func expectSameState(t *testing.T, expected, found *matcher.M) {
+	eb, fb := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := expected.Snapshot(eb); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := found.Snapshot(fb); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(eb.Bytes(), fb.Bytes()) {
+		t.Errorf("Matcher states differ")
+	}
+}
@@ -0,0 +1,70 @@
+package matcher
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher/pqueue"
+	"github.com/fmstephe/matching_engine/msg"
+)
+
+// A limit whose size or order count may have changed while processing a message
+type touchedLimit struct {
+	kind    msg.MsgKind
+	price   uint64
+	stockId uint64
+}
+
+// Configures a writer which will receive a BUY_DEPTH or SELL_DEPTH message
+// for every limit changed by a submitted message. A nil writer disables
+// depth publishing.
+func (m *M) SetDepth(depth coordinator.MsgWriter) {
+	m.depth = depth
+}
+
+// Returns the best n buy and sell limits for stockId. A stock which has
+// never been traded has no limits.
+func (m *M) Survey(stockId uint64, n int) (buys, sells []msg.SurveyLimit) {
+	q := m.books[stockId]
+	if q == nil {
+		return nil, nil
+	}
+	return q.SurveyBuys(n), q.SurveySells(n)
+}
+
+func (m *M) touch(kind msg.MsgKind, o *pqueue.OrderNode) {
+	if m.depth == nil {
+		return
+	}
+	t := touchedLimit{kind: kind, price: o.Price(), stockId: o.StockId()}
+	// Matching walks limits in price order, so repeated touches are always adjacent
+	if len(m.touched) > 0 && m.touched[len(m.touched)-1] == t {
+		return
+	}
+	m.touched = append(m.touched, t)
+}
+
+func (m *M) touchCancelled(o *pqueue.OrderNode) {
+	switch o.Kind() {
+	case msg.BUY:
+		m.touch(msg.BUY_DEPTH, o)
+	case msg.SELL:
+		m.touch(msg.SELL_DEPTH, o)
+	}
+}
+
+func (m *M) publishDepth() {
+	for _, t := range m.touched {
+		// Reading a limit must never create a book
+		var l msg.SurveyLimit
+		if q := m.books[t.stockId]; q == nil {
+			l = msg.SurveyLimit{Price: t.price}
+		} else if t.kind == msg.BUY_DEPTH {
+			l = q.BuyLimit(t.price)
+		} else {
+			l = q.SellLimit(t.price)
+		}
+		dm := msg.Message{}
+		dm.WriteDepth(t.kind, t.stockId, &l)
+		m.depth.Write(dm)
+	}
+	m.touched = m.touched[:0]
+}
@@ -0,0 +1,39 @@
+package matcher
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher/pqueue"
+	"github.com/fmstephe/matching_engine/msg"
+)
+
+// Configures a writer which will receive an anonymised book event for every
+// change to a resting order. Consumers can rebuild every book exactly from
+// these events. A nil writer disables the feed.
+func (m *M) SetOrderFeed(feed coordinator.MsgWriter) {
+	m.feed = feed
+}
+
+func (m *M) rest(kind msg.MsgKind, o *pqueue.OrderNode) {
+	m.nextRef++
+	o.SetRef(m.nextRef)
+	m.writeBookEvent(kind, o, o.Price(), o.Amount())
+}
+
+func (m *M) feedExecute(o *pqueue.OrderNode, price, amount uint64) {
+	m.writeBookEvent(msg.BOOK_EXECUTE, o, price, amount)
+}
+
+func (m *M) feedDelete(o *pqueue.OrderNode) {
+	m.writeBookEvent(msg.BOOK_DELETE, o, o.Price(), o.Amount())
+}
+
+func (m *M) writeBookEvent(kind msg.MsgKind, o *pqueue.OrderNode, price, amount uint64) {
+	// The sequence advances even without a feed, so it survives replaying a journal
+	m.feedSeq++
+	if m.feed == nil {
+		return
+	}
+	e := msg.Message{}
+	e.WriteBookEvent(kind, m.feedSeq, o.Ref(), o.StockId(), price, amount)
+	m.feed.Write(e)
+}
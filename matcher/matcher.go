@@ -9,14 +9,47 @@ import (
 
 type M struct {
 	coordinator.AppMsgHelper
-	matchQueues map[uint64]*pqueue.MatchQueues
-	slab        *pqueue.Slab
+	books  map[uint64]pqueue.OrderBook
+	mkBook BookMaker
+	slab   *pqueue.Slab
+	// Level 2 market data
+	depth   coordinator.MsgWriter
+	touched []touchedLimit
+	// Level 3 market data
+	feed    coordinator.MsgWriter
+	feedSeq uint32
+	nextRef uint32
+	// Outputs are appended here, rather than written to Out, while batching
+	batching bool
+	batchOut []msg.Message
 }
 
+// Creates the book for a stock, the first time an order for that stock is seen
+type BookMaker func(stockId uint64) pqueue.OrderBook
+
+// The default BookMaker, every stock is given a pqueue.MatchQueues
+func MatchQueuesMaker(stockId uint64) pqueue.OrderBook {
+	return &pqueue.MatchQueues{}
+}
+
+// Creates a matcher whose slab grows slabSize OrderNodes at a time
 func NewMatcher(slabSize int) *M {
-	matchQueues := make(map[uint64]*pqueue.MatchQueues)
-	slab := pqueue.NewSlab(slabSize)
-	return &M{matchQueues: matchQueues, slab: slab}
+	return NewMatcherWithSlab(pqueue.NewSlab(slabSize))
+}
+
+func NewMatcherWithSlab(slab *pqueue.Slab) *M {
+	books := make(map[uint64]pqueue.OrderBook)
+	return &M{books: books, mkBook: MatchQueuesMaker, slab: slab}
+}
+
+// Sets the BookMaker used to create each stock's book. Stocks which already
+// have a book keep it.
+func (m *M) SetBookMaker(mkBook BookMaker) {
+	m.mkBook = mkBook
+}
+
+func (m *M) SlabStats() pqueue.SlabStats {
+	return m.slab.Stats()
 }
 
 func (m *M) Run() {
@@ -31,7 +64,31 @@ func (m *M) Run() {
 	}
 }
 
+// Submits each message in in, appending the resulting outputs to out.
+// Outputs are not written to Out. A SHUTDOWN is appended to out, and the
+// remaining messages in in are not submitted.
+func (m *M) SubmitBatch(in, out []msg.Message) []msg.Message {
+	m.batching = true
+	m.batchOut = out
+	for i := range in {
+		if in[i].Kind == msg.SHUTDOWN {
+			m.batchOut = append(m.batchOut, in[i])
+			break
+		}
+		m.Submit(&in[i])
+	}
+	out = m.batchOut
+	m.batching = false
+	m.batchOut = nil
+	return out
+}
+
+// A NEW_TRADER is accepted, and produces no outputs, as the matcher keeps no
+// per trader state. Any other kind, except BUY, SELL and CANCEL, panics.
 func (m *M) Submit(o *msg.Message) {
+	if o.Kind == msg.NEW_TRADER {
+		return // The matcher keeps no per trader state
+	}
 	on := m.slab.Malloc()
 	on.CopyFrom(o)
 	switch on.Kind() {
@@ -44,38 +101,55 @@ func (m *M) Submit(o *msg.Message) {
 	default:
 		panic(fmt.Sprintf("MsgKind %v not supported", on))
 	}
+	m.publishDepth()
 }
 
-func (m *M) getMatchQueues(stockId uint64) *pqueue.MatchQueues {
-	q := m.matchQueues[stockId]
+func (m *M) getBook(stockId uint64) pqueue.OrderBook {
+	q := m.books[stockId]
 	if q == nil {
-		q = &pqueue.MatchQueues{}
-		m.matchQueues[stockId] = q
+		q = m.mkBook(stockId)
+		m.books[stockId] = q
 	}
 	return q
 }
 
+// An order is matched before its price is checked against the book's band,
+// only a remainder which would rest outside the band is REJECTED
 func (m *M) addBuy(b *pqueue.OrderNode) {
 	if b.Price() == msg.MARKET_PRICE {
 		panic("It is illegal to send a buy at market price")
 	}
-	q := m.getMatchQueues(b.StockId())
+	q := m.getBook(b.StockId())
 	if !m.fillableBuy(b, q) {
+		if !q.InBand(b.Price()) {
+			m.completeRejected(b)
+			return
+		}
+		m.touch(msg.BUY_DEPTH, b)
+		m.rest(msg.BOOK_ADD_BUY, b)
 		q.PushBuy(b)
 	}
 }
 
 func (m *M) addSell(s *pqueue.OrderNode) {
-	q := m.getMatchQueues(s.StockId())
+	q := m.getBook(s.StockId())
 	if !m.fillableSell(s, q) {
+		if !q.InBand(s.Price()) {
+			m.completeRejected(s)
+			return
+		}
+		m.touch(msg.SELL_DEPTH, s)
+		m.rest(msg.BOOK_ADD_SELL, s)
 		q.PushSell(s)
 	}
 }
 
 func (m *M) cancel(o *pqueue.OrderNode) {
-	q := m.getMatchQueues(o.StockId())
+	q := m.getBook(o.StockId())
 	ro := q.Cancel(o)
 	if ro != nil {
+		m.touchCancelled(ro)
+		m.feedDelete(ro)
 		m.completeCancelled(ro)
 		m.slab.Free(ro)
 	} else {
@@ -84,20 +158,22 @@ func (m *M) cancel(o *pqueue.OrderNode) {
 	m.slab.Free(o)
 }
 
-func (m *M) fillableBuy(b *pqueue.OrderNode, q *pqueue.MatchQueues) bool {
+func (m *M) fillableBuy(b *pqueue.OrderNode, q pqueue.OrderBook) bool {
 	for {
 		s := q.PeekSell()
 		if s == nil {
 			return false
 		}
 		if b.Price() >= s.Price() {
+			m.touch(msg.SELL_DEPTH, s)
 			if b.Amount() > s.Amount() {
 				amount := s.Amount()
 				price := price(b.Price(), s.Price())
-				s.Remove()
-				m.slab.Free(s)
+				q.PopSell()
 				b.ReduceAmount(amount)
 				m.completeTrade(msg.PARTIAL, msg.FULL, b, s, price, amount)
+				m.feedExecute(s, price, amount)
+				m.slab.Free(s)
 				continue // The sell has been used up
 			}
 			if s.Amount() > b.Amount() {
@@ -105,6 +181,7 @@ func (m *M) fillableBuy(b *pqueue.OrderNode, q *pqueue.MatchQueues) bool {
 				price := price(b.Price(), s.Price())
 				s.ReduceAmount(amount)
 				m.completeTrade(msg.FULL, msg.PARTIAL, b, s, price, amount)
+				m.feedExecute(s, price, amount)
 				m.slab.Free(b)
 				return true // The buy has been used up
 			}
@@ -112,7 +189,8 @@ func (m *M) fillableBuy(b *pqueue.OrderNode, q *pqueue.MatchQueues) bool {
 				amount := b.Amount()
 				price := price(b.Price(), s.Price())
 				m.completeTrade(msg.FULL, msg.FULL, b, s, price, amount)
-				s.Remove()
+				m.feedExecute(s, price, amount)
+				q.PopSell()
 				m.slab.Free(s)
 				m.slab.Free(b)
 				return true // The buy and sell have been used up
@@ -123,19 +201,20 @@ func (m *M) fillableBuy(b *pqueue.OrderNode, q *pqueue.MatchQueues) bool {
 	}
 }
 
-func (m *M) fillableSell(s *pqueue.OrderNode, q *pqueue.MatchQueues) bool {
+func (m *M) fillableSell(s *pqueue.OrderNode, q pqueue.OrderBook) bool {
 	for {
 		b := q.PeekBuy()
 		if b == nil {
 			return false
 		}
 		if b.Price() >= s.Price() {
+			m.touch(msg.BUY_DEPTH, b)
 			if b.Amount() > s.Amount() {
 				amount := s.Amount()
 				price := price(b.Price(), s.Price())
 				b.ReduceAmount(amount)
 				m.completeTrade(msg.PARTIAL, msg.FULL, b, s, price, amount)
-				s.Remove()
+				m.feedExecute(b, price, amount)
 				m.slab.Free(s)
 				return true // The sell has been used up
 			}
@@ -144,7 +223,8 @@ func (m *M) fillableSell(s *pqueue.OrderNode, q *pqueue.MatchQueues) bool {
 				price := price(b.Price(), s.Price())
 				s.ReduceAmount(amount)
 				m.completeTrade(msg.FULL, msg.PARTIAL, b, s, price, amount)
-				b.Remove()
+				m.feedExecute(b, price, amount)
+				q.PopBuy()
 				m.slab.Free(b) // The buy has been used up
 				continue
 			}
@@ -152,7 +232,8 @@ func (m *M) fillableSell(s *pqueue.OrderNode, q *pqueue.MatchQueues) bool {
 				amount := b.Amount()
 				price := price(b.Price(), s.Price())
 				m.completeTrade(msg.FULL, msg.FULL, b, s, price, amount)
-				b.Remove()
+				m.feedExecute(b, price, amount)
+				q.PopBuy()
 				m.slab.Free(b)
 				m.slab.Free(s)
 				return true // The sell and buy have been used up
@@ -171,21 +252,37 @@ func price(bPrice, sPrice uint64) uint64 {
 	return sPrice + (d / 2)
 }
 
+func (m *M) write(o msg.Message) {
+	if m.batching {
+		m.batchOut = append(m.batchOut, o)
+		return
+	}
+	m.Out.Write(o)
+}
+
 func (m *M) completeTrade(brk, srk msg.MsgKind, b, s *pqueue.OrderNode, price, amount uint64) {
-	m.Out.Write(msg.Message{Kind: brk, Price: price, Amount: amount, TraderId: b.TraderId(), TradeId: b.TradeId(), StockId: b.StockId()})
-	m.Out.Write(msg.Message{Kind: srk, Price: price, Amount: amount, TraderId: s.TraderId(), TradeId: s.TradeId(), StockId: s.StockId()})
+	m.write(msg.Message{Kind: brk, Price: price, Amount: amount, TraderId: b.TraderId(), TradeId: b.TradeId(), StockId: b.StockId()})
+	m.write(msg.Message{Kind: srk, Price: price, Amount: amount, TraderId: s.TraderId(), TradeId: s.TradeId(), StockId: s.StockId()})
 }
 
 func (m *M) completeCancelled(c *pqueue.OrderNode) {
 	cm := msg.Message{}
 	c.CopyTo(&cm)
 	cm.Kind = msg.CANCELLED
-	m.Out.Write(cm)
+	m.write(cm)
 }
 
 func (m *M) completeNotCancelled(nc *pqueue.OrderNode) {
 	ncm := msg.Message{}
 	nc.CopyTo(&ncm)
 	ncm.Kind = msg.NOT_CANCELLED
-	m.Out.Write(ncm)
+	m.write(ncm)
+}
+
+func (m *M) completeRejected(r *pqueue.OrderNode) {
+	rm := msg.Message{}
+	r.CopyTo(&rm)
+	rm.Kind = msg.REJECTED
+	m.write(rm)
+	m.slab.Free(r)
 }
@@ -0,0 +1,33 @@
+package pqueue
+
+import (
+	"github.com/fmstephe/matching_engine/msg"
+)
+
+// The book of buys and sells for a single stock. Buys are prioritised by
+// highest price and sells by lowest price, orders at the same price by the
+// time they were pushed.
+type OrderBook interface {
+	// Indicates whether orders at price can be pushed into this book
+	InBand(price uint64) bool
+	Size() int
+	// Pushing an order whose price is not InBand panics, callers must check first
+	PushBuy(b *OrderNode)
+	PushSell(s *OrderNode)
+	PeekBuy() *OrderNode
+	PeekSell() *OrderNode
+	PopBuy() *OrderNode
+	PopSell() *OrderNode
+	// Removes the order with the same guid as o, returning nil if there is none
+	Cancel(o *OrderNode) *OrderNode
+	// Visits each limit, best price first, until f returns false
+	WalkBuyLimits(f func(l *msg.SurveyLimit) bool)
+	WalkSellLimits(f func(l *msg.SurveyLimit) bool)
+	BuyLimit(price uint64) msg.SurveyLimit
+	SellLimit(price uint64) msg.SurveyLimit
+	SurveyBuys(n int) []msg.SurveyLimit
+	SurveySells(n int) []msg.SurveyLimit
+	// Visits every order, in priority order
+	WalkBuys(f func(o *OrderNode))
+	WalkSells(f func(o *OrderNode))
+}
@@ -0,0 +1,172 @@
+package pqueue
+
+import (
+	"math/bits"
+)
+
+// One side of a book with a level for every price in a fixed band.
+// Occupied levels are tracked in a bitmap, summarised by a second bitmap
+// with a bit per bitmap word, so the next best level is found by
+// scanning from the old best level rather than through every price.
+type ladderSide struct {
+	low     uint64
+	levels  []level
+	bits    []uint64
+	summary []uint64
+	best    int // The index of the best occupied level, -1 if there is none
+	buy     bool
+}
+
+func mkLadderSide(lowPrice, highPrice uint64, buy bool) *ladderSide {
+	n := int(highPrice-lowPrice) + 1
+	words := (n + 63) / 64
+	s := &ladderSide{
+		low:     lowPrice,
+		levels:  make([]level, n),
+		bits:    make([]uint64, words),
+		summary: make([]uint64, (words+63)/64),
+		best:    -1,
+		buy:     buy,
+	}
+	for i := range s.levels {
+		l := &s.levels[i]
+		initNode(nil, lowPrice+uint64(i), &l.head, nil)
+		l.side = s
+	}
+	return s
+}
+
+func (s *ladderSide) push(o *OrderNode) {
+	i := int(o.Price() - s.low)
+	l := &s.levels[i]
+	if l.orders == 0 {
+		s.set(i)
+		if s.best == -1 || (s.buy && i > s.best) || (!s.buy && i < s.best) {
+			s.best = i
+		}
+	}
+	l.head.addLast(&o.priceNode)
+	l.size += o.amount
+	l.orders++
+	o.level = l
+}
+
+func (s *ladderSide) removeLevel(l *level) {
+	i := int(l.head.val - s.low)
+	s.clear(i)
+	if i == s.best {
+		s.best = s.next(i)
+	}
+}
+
+func (s *ladderSide) peek() *level {
+	if s.best == -1 {
+		return nil
+	}
+	return &s.levels[s.best]
+}
+
+func (s *ladderSide) get(price uint64) *level {
+	l := &s.levels[price-s.low]
+	if l.orders == 0 {
+		return nil
+	}
+	return l
+}
+
+// Visits each occupied level, best price first, until f returns false
+func (s *ladderSide) walk(f func(l *level) bool) {
+	for i := s.best; i != -1; i = s.next(i) {
+		if !f(&s.levels[i]) {
+			return
+		}
+	}
+}
+
+// The index of the next best occupied level after i, -1 if there is none
+func (s *ladderSide) next(i int) int {
+	if s.buy {
+		return s.highestFrom(i - 1)
+	}
+	return s.lowestFrom(i + 1)
+}
+
+func (s *ladderSide) set(i int) {
+	w := i >> 6
+	s.bits[w] |= 1 << uint(i&63)
+	s.summary[w>>6] |= 1 << uint(w&63)
+}
+
+func (s *ladderSide) clear(i int) {
+	w := i >> 6
+	s.bits[w] &^= 1 << uint(i&63)
+	if s.bits[w] == 0 {
+		s.summary[w>>6] &^= 1 << uint(w&63)
+	}
+}
+
+// The highest occupied index <= i, -1 if there is none
+func (s *ladderSide) highestFrom(i int) int {
+	if i < 0 {
+		return -1
+	}
+	w := i >> 6
+	if word := s.bits[w] & atOrBelow(i&63); word != 0 {
+		return w<<6 + 63 - bits.LeadingZeros64(word)
+	}
+	sw := w >> 6
+	sword := s.summary[sw] & below(w&63)
+	for sword == 0 {
+		sw--
+		if sw < 0 {
+			return -1
+		}
+		sword = s.summary[sw]
+	}
+	w = sw<<6 + 63 - bits.LeadingZeros64(sword)
+	return w<<6 + 63 - bits.LeadingZeros64(s.bits[w])
+}
+
+// The lowest occupied index >= i, -1 if there is none
+func (s *ladderSide) lowestFrom(i int) int {
+	if i >= len(s.levels) {
+		return -1
+	}
+	w := i >> 6
+	if word := s.bits[w] & atOrAbove(i&63); word != 0 {
+		return w<<6 + bits.TrailingZeros64(word)
+	}
+	sw := w >> 6
+	sword := s.summary[sw] & above(w&63)
+	for sword == 0 {
+		sw++
+		if sw >= len(s.summary) {
+			return -1
+		}
+		sword = s.summary[sw]
+	}
+	w = sw<<6 + bits.TrailingZeros64(sword)
+	return w<<6 + bits.TrailingZeros64(s.bits[w])
+}
+
+func atOrBelow(b int) uint64 {
+	if b == 63 {
+		return ^uint64(0)
+	}
+	return 1<<uint(b+1) - 1
+}
+
+func below(b int) uint64 {
+	return 1<<uint(b) - 1
+}
+
+func atOrAbove(b int) uint64 {
+	return ^uint64(0) << uint(b)
+}
+
+func above(b int) uint64 {
+	if b == 63 {
+		return 0
+	}
+	return ^uint64(0) << uint(b+1)
+}
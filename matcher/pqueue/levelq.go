@@ -0,0 +1,117 @@
+package pqueue
+
+import (
+	"github.com/fmstephe/matching_engine/msg"
+)
+
+// A price level. The level's orders are queued behind its head node using
+// their own price nodes. In a LevelMatchQueues the head node sits in the price tree.
+type level struct {
+	head   node
+	size   uint64
+	orders uint32
+	side   levelOwner
+}
+
+// Notified when one of its levels becomes empty
+type levelOwner interface {
+	removeLevel(l *level)
+}
+
+// Removes an order, already unlinked from the level's queue, from the level's totals
+func (l *level) remove(o *OrderNode) {
+	l.size -= o.amount
+	l.orders--
+	o.level = nil
+	if l.orders == 0 {
+		l.side.removeLevel(l)
+	}
+}
+
+func (l *level) limit() msg.SurveyLimit {
+	return msg.SurveyLimit{Price: l.head.val, Size: l.size, Orders: l.orders}
+}
+
+// The order at the front of the level's queue
+func (l *level) first() *OrderNode {
+	return l.head.prev.order
+}
+
+// Visits each order in the level, in time priority order
+func (l *level) walk(f func(o *OrderNode)) {
+	for n := l.head.prev; n != &l.head; n = n.prev {
+		f(n.order)
+	}
+}
+
+// One side of a book. Holds a tree node per price level, rather than per order.
+type levelSide struct {
+	tree   rbtree
+	levels map[uint64]*level
+	free   []*level
+}
+
+func (s *levelSide) push(o *OrderNode) {
+	if s.levels == nil {
+		s.levels = make(map[uint64]*level)
+	}
+	l := s.levels[o.Price()]
+	if l == nil {
+		l = s.newLevel(o.Price())
+	}
+	l.head.addLast(&o.priceNode)
+	l.size += o.amount
+	l.orders++
+	o.level = l
+}
+
+func (s *levelSide) newLevel(price uint64) *level {
+	var l *level
+	if len(s.free) > 0 {
+		l = s.free[len(s.free)-1]
+		s.free = s.free[:len(s.free)-1]
+	} else {
+		l = &level{side: s}
+	}
+	initNode(nil, price, &l.head, nil)
+	s.tree.push(&l.head)
+	s.levels[price] = l
+	return l
+}
+
+func (s *levelSide) removeLevel(l *level) {
+	l.head.pop()
+	delete(s.levels, l.head.val)
+	s.free = append(s.free, l)
+}
+
+func (s *levelSide) get(price uint64) *level {
+	return s.levels[price]
+}
+
+func nodeLevel(n *node) *level {
+	if n == nil {
+		return nil
+	}
+	return n.prev.order.level
+}
+
+func (s *levelSide) peekMax() *level {
+	return nodeLevel(s.tree.peekMax())
+}
+
+func (s *levelSide) peekMin() *level {
+	return nodeLevel(s.tree.peekMin())
+}
+
+func (s *levelSide) walkAsc(f func(l *level) bool) {
+	s.tree.walkAsc(func(n *node) bool {
+		return f(nodeLevel(n))
+	})
+}
+
+func (s *levelSide) walkDesc(f func(l *level) bool) {
+	s.tree.walkDesc(func(n *node) bool {
+		return f(nodeLevel(n))
+	})
+}
@@ -13,6 +13,9 @@ type OrderNode struct {
 	amount    uint64
 	stockId   uint64
 	kind      msg.MsgKind
+	ref       uint32
+	level     *level // Set while queued in a LevelMatchQueues
+	slabState byte
 	nextFree  *OrderNode
 }
 
@@ -20,6 +23,8 @@ func (o *OrderNode) CopyFrom(from *msg.Message) {
 	o.amount = from.Amount
 	o.stockId = from.StockId
 	o.kind = from.Kind
+	o.ref = 0
+	o.level = nil
 	o.setup(from.Price, uint64(fmath.CombineInt32(int32(from.TraderId), int32(from.TradeId))))
 }
 
@@ -53,12 +58,24 @@ func (o *OrderNode) TradeId() uint32 {
 	return uint32(fmath.LowInt32(int64(o.guidNode.val)))
 }
 
+// The anonymous reference assigned to this order when it rested in the book
+func (o *OrderNode) Ref() uint32 {
+	return o.ref
+}
+
+func (o *OrderNode) SetRef(ref uint32) {
+	o.ref = ref
+}
+
 func (o *OrderNode) Amount() uint64 {
 	return o.amount
 }
 
 func (o *OrderNode) ReduceAmount(s uint64) {
 	o.amount -= s
+	if o.level != nil {
+		o.level.size -= s
+	}
 }
 
 func (o *OrderNode) StockId() uint64 {
@@ -72,6 +89,9 @@ func (o *OrderNode) Kind() msg.MsgKind {
 func (o *OrderNode) Remove() {
 	o.priceNode.pop()
 	o.guidNode.pop()
+	if o.level != nil {
+		o.level.remove(o)
+	}
 }
 
 func (o *OrderNode) String() string {
@@ -0,0 +1,144 @@
+package pqueue
+
+import (
+	"fmt"
+	"github.com/fmstephe/matching_engine/msg"
+)
+
+// A book for instruments whose prices are confined to a known band. Each
+// side is an array with a level for every price in the band, so pushing an
+// order is a single index and the best buy and sell are always at hand.
+// Every level is allocated up front, so the band should be kept narrow.
+//
+// Orders are found for cancelling through a tree ordered by guid.
+type LadderMatchQueues struct {
+	buys      *ladderSide
+	sells     *ladderSide
+	orders    rbtree
+	size      int
+	lowPrice  uint64
+	highPrice uint64
+}
+
+func NewLadderMatchQueues(lowPrice, highPrice uint64) *LadderMatchQueues {
+	if lowPrice > highPrice {
+		panic(fmt.Sprintf("lowPrice must not be greater than highPrice. lowPrice was %d, highPrice was %d", lowPrice, highPrice))
+	}
+	buys := mkLadderSide(lowPrice, highPrice, true)
+	sells := mkLadderSide(lowPrice, highPrice, false)
+	return &LadderMatchQueues{buys: buys, sells: sells, lowPrice: lowPrice, highPrice: highPrice}
+}
+
+// Indicates whether orders at price can be pushed into this book
+func (m *LadderMatchQueues) InBand(price uint64) bool {
+	return price >= m.lowPrice && price <= m.highPrice
+}
+
+func (m *LadderMatchQueues) Size() int {
+	return m.size
+}
+
+// Panics if b's price is not InBand
+func (m *LadderMatchQueues) PushBuy(b *OrderNode) {
+	m.checkBand(b)
+	m.size++
+	m.buys.push(b)
+	m.orders.push(&b.guidNode)
+}
+
+// Panics if s's price is not InBand
+func (m *LadderMatchQueues) PushSell(s *OrderNode) {
+	m.checkBand(s)
+	m.size++
+	m.sells.push(s)
+	m.orders.push(&s.guidNode)
+}
+
+func (m *LadderMatchQueues) checkBand(o *OrderNode) {
+	if !m.InBand(o.Price()) {
+		panic(fmt.Sprintf("Price of %v outside of band %d-%d", o, m.lowPrice, m.highPrice))
+	}
+}
+
+func (m *LadderMatchQueues) PeekBuy() *OrderNode {
+	if l := m.buys.peek(); l != nil {
+		return l.first()
+	}
+	return nil
+}
+
+func (m *LadderMatchQueues) PeekSell() *OrderNode {
+	if l := m.sells.peek(); l != nil {
+		return l.first()
+	}
+	return nil
+}
+
+func (m *LadderMatchQueues) PopBuy() *OrderNode {
+	return m.pop(m.PeekBuy())
+}
+
+func (m *LadderMatchQueues) PopSell() *OrderNode {
+	return m.pop(m.PeekSell())
+}
+
+func (m *LadderMatchQueues) pop(o *OrderNode) *OrderNode {
+	if o != nil {
+		m.size--
+		o.Remove()
+	}
+	return o
+}
+
+func (m *LadderMatchQueues) Cancel(o *OrderNode) *OrderNode {
+	po := m.orders.cancel(o.Guid()).getOrderNode()
+	if po != nil {
+		m.size--
+		po.level.remove(po)
+	}
+	return po
+}
+
+// Visits each buy limit, best price first, until f returns false
+func (m *LadderMatchQueues) WalkBuyLimits(f func(l *msg.SurveyLimit) bool) {
+	m.buys.walk(levelLimitWalker(f))
+}
+
+// Visits each sell limit, best price first, until f returns false
+func (m *LadderMatchQueues) WalkSellLimits(f func(l *msg.SurveyLimit) bool) {
+	m.sells.walk(levelLimitWalker(f))
+}
+
+func (m *LadderMatchQueues) BuyLimit(price uint64) msg.SurveyLimit {
+	if !m.InBand(price) {
+		return msg.SurveyLimit{Price: price}
+	}
+	return levelLimit(price, m.buys.get(price))
+}
+
+func (m *LadderMatchQueues) SellLimit(price uint64) msg.SurveyLimit {
+	if !m.InBand(price) {
+		return msg.SurveyLimit{Price: price}
+	}
+	return levelLimit(price, m.sells.get(price))
+}
+
+// Returns the best n buy limits
+func (m *LadderMatchQueues) SurveyBuys(n int) []msg.SurveyLimit {
+	return survey(m.WalkBuyLimits, n)
+}
+
+// Returns the best n sell limits
+func (m *LadderMatchQueues) SurveySells(n int) []msg.SurveyLimit {
+	return survey(m.WalkSellLimits, n)
+}
+
+// Visits every buy, in priority order
+func (m *LadderMatchQueues) WalkBuys(f func(o *OrderNode)) {
+	m.buys.walk(levelOrderWalker(f))
+}
+
+// Visits every sell, in priority order
+func (m *LadderMatchQueues) WalkSells(f func(o *OrderNode)) {
+	m.sells.walk(levelOrderWalker(f))
+}
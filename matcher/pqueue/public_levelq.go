@@ -0,0 +1,139 @@
+package pqueue
+
+import (
+	"github.com/fmstephe/matching_engine/msg"
+)
+
+// A book whose price trees hold one node per price level, rather than one
+// per order. Orders at the same price are queued behind their level, which
+// keeps the aggregated size and order count. Pushing an order at an existing
+// price never rebalances a tree, and limits are available without walking
+// the level's orders.
+//
+// Orders are found for cancelling through a tree ordered by guid.
+type LevelMatchQueues struct {
+	buys   levelSide
+	sells  levelSide
+	orders rbtree
+	size   int
+}
+
+// Any price can be pushed into this book
+func (m *LevelMatchQueues) InBand(price uint64) bool {
+	return true
+}
+
+func (m *LevelMatchQueues) Size() int {
+	return m.size
+}
+
+func (m *LevelMatchQueues) PushBuy(b *OrderNode) {
+	m.size++
+	m.buys.push(b)
+	m.orders.push(&b.guidNode)
+}
+
+func (m *LevelMatchQueues) PushSell(s *OrderNode) {
+	m.size++
+	m.sells.push(s)
+	m.orders.push(&s.guidNode)
+}
+
+func (m *LevelMatchQueues) PeekBuy() *OrderNode {
+	if l := m.buys.peekMax(); l != nil {
+		return l.first()
+	}
+	return nil
+}
+
+func (m *LevelMatchQueues) PeekSell() *OrderNode {
+	if l := m.sells.peekMin(); l != nil {
+		return l.first()
+	}
+	return nil
+}
+
+func (m *LevelMatchQueues) PopBuy() *OrderNode {
+	return m.pop(m.PeekBuy())
+}
+
+func (m *LevelMatchQueues) PopSell() *OrderNode {
+	return m.pop(m.PeekSell())
+}
+
+func (m *LevelMatchQueues) pop(o *OrderNode) *OrderNode {
+	if o != nil {
+		m.size--
+		o.Remove()
+	}
+	return o
+}
+
+func (m *LevelMatchQueues) Cancel(o *OrderNode) *OrderNode {
+	po := m.orders.cancel(o.Guid()).getOrderNode()
+	if po != nil {
+		m.size--
+		po.level.remove(po)
+	}
+	return po
+}
+
+// Visits each buy limit, best price first, until f returns false
+func (m *LevelMatchQueues) WalkBuyLimits(f func(l *msg.SurveyLimit) bool) {
+	m.buys.walkDesc(levelLimitWalker(f))
+}
+
+// Visits each sell limit, best price first, until f returns false
+func (m *LevelMatchQueues) WalkSellLimits(f func(l *msg.SurveyLimit) bool) {
+	m.sells.walkAsc(levelLimitWalker(f))
+}
+
+func (m *LevelMatchQueues) BuyLimit(price uint64) msg.SurveyLimit {
+	return levelLimit(price, m.buys.get(price))
+}
+
+func (m *LevelMatchQueues) SellLimit(price uint64) msg.SurveyLimit {
+	return levelLimit(price, m.sells.get(price))
+}
+
+// Returns the best n buy limits
+func (m *LevelMatchQueues) SurveyBuys(n int) []msg.SurveyLimit {
+	return survey(m.WalkBuyLimits, n)
+}
+
+// Returns the best n sell limits
+func (m *LevelMatchQueues) SurveySells(n int) []msg.SurveyLimit {
+	return survey(m.WalkSellLimits, n)
+}
+
+// Visits every buy, in priority order
+func (m *LevelMatchQueues) WalkBuys(f func(o *OrderNode)) {
+	m.buys.walkDesc(levelOrderWalker(f))
+}
+
+// Visits every sell, in priority order
+func (m *LevelMatchQueues) WalkSells(f func(o *OrderNode)) {
+	m.sells.walkAsc(levelOrderWalker(f))
+}
+
+func levelLimitWalker(f func(l *msg.SurveyLimit) bool) func(l *level) bool {
+	return func(l *level) bool {
+		sl := l.limit()
+		return f(&sl)
+	}
+}
+
+func levelOrderWalker(f func(o *OrderNode)) func(l *level) bool {
+	return func(l *level) bool {
+		l.walk(f)
+		return true
+	}
+}
+
+// A nil l produces an empty limit
+func levelLimit(price uint64, l *level) msg.SurveyLimit {
+	if l == nil {
+		return msg.SurveyLimit{Price: price}
+	}
+	return l.limit()
+}
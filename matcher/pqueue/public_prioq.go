@@ -1,6 +1,8 @@
 package pqueue
 
-import ()
+import (
+	"github.com/fmstephe/matching_engine/msg"
+)
 
 type MatchQueues struct {
 	buyTree  rbtree
@@ -9,6 +11,11 @@ type MatchQueues struct {
 	size     int
 }
 
+// Any price can be pushed into this book
+func (m *MatchQueues) InBand(price uint64) bool {
+	return true
+}
+
 func (m *MatchQueues) Size() int {
 	return m.size
 }
@@ -34,13 +41,19 @@ func (m *MatchQueues) PeekSell() *OrderNode {
 }
 
 func (m *MatchQueues) PopBuy() *OrderNode {
-	m.size--
-	return m.buyTree.popMax().getOrderNode()
+	o := m.buyTree.popMax().getOrderNode()
+	if o != nil {
+		m.size--
+	}
+	return o
 }
 
 func (m *MatchQueues) PopSell() *OrderNode {
-	m.size--
-	return m.sellTree.popMin().getOrderNode()
+	o := m.sellTree.popMin().getOrderNode()
+	if o != nil {
+		m.size--
+	}
+	return o
 }
 
 func (m *MatchQueues) Cancel(o *OrderNode) *OrderNode {
@@ -50,3 +63,81 @@ func (m *MatchQueues) Cancel(o *OrderNode) *OrderNode {
 	}
 	return po
 }
+
+// Visits each buy limit, best price first, until f returns false
+func (m *MatchQueues) WalkBuyLimits(f func(l *msg.SurveyLimit) bool) {
+	m.buyTree.walkDesc(limitWalker(f))
+}
+
+// Visits each sell limit, best price first, until f returns false
+func (m *MatchQueues) WalkSellLimits(f func(l *msg.SurveyLimit) bool) {
+	m.sellTree.walkAsc(limitWalker(f))
+}
+
+func (m *MatchQueues) BuyLimit(price uint64) msg.SurveyLimit {
+	return mkLimit(price, m.buyTree.get(price))
+}
+
+func (m *MatchQueues) SellLimit(price uint64) msg.SurveyLimit {
+	return mkLimit(price, m.sellTree.get(price))
+}
+
+// Returns the best n buy limits
+func (m *MatchQueues) SurveyBuys(n int) []msg.SurveyLimit {
+	return survey(m.WalkBuyLimits, n)
+}
+
+// Returns the best n sell limits
+func (m *MatchQueues) SurveySells(n int) []msg.SurveyLimit {
+	return survey(m.WalkSellLimits, n)
+}
+
+func limitWalker(f func(l *msg.SurveyLimit) bool) func(n *node) bool {
+	return func(n *node) bool {
+		l := mkLimit(n.val, n)
+		return f(&l)
+	}
+}
+
+// Aggregates the orders queued at the head node n. A nil n produces an empty limit.
+func mkLimit(price uint64, n *node) msg.SurveyLimit {
+	l := msg.SurveyLimit{Price: price}
+	if n != nil {
+		n.walkQueue(func(qn *node) {
+			l.Size += qn.order.Amount()
+			l.Orders++
+		})
+	}
+	return l
+}
+
+func survey(walk func(f func(l *msg.SurveyLimit) bool), n int) []msg.SurveyLimit {
+	limits := make([]msg.SurveyLimit, 0, n)
+	if n <= 0 {
+		return limits
+	}
+	walk(func(l *msg.SurveyLimit) bool {
+		limits = append(limits, *l)
+		return len(limits) < n
+	})
+	return limits
+}
+
+// Visits every buy, in priority order
+func (m *MatchQueues) WalkBuys(f func(o *OrderNode)) {
+	m.buyTree.walkDesc(orderWalker(f))
+}
+
+// Visits every sell, in priority order
+func (m *MatchQueues) WalkSells(f func(o *OrderNode)) {
+	m.sellTree.walkAsc(orderWalker(f))
+}
+
+func orderWalker(f func(o *OrderNode)) func(n *node) bool {
+	return func(n *node) bool {
+		n.walkQueue(func(qn *node) {
+			f(qn.order)
+		})
+		return true
+	}
+}
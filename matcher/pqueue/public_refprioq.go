@@ -1,6 +1,8 @@
 package pqueue
 
-import ()
+import (
+	"github.com/fmstephe/matching_engine/msg"
+)
 
 type RefMatchQueues struct {
 	buys  *pqueue
@@ -14,6 +16,10 @@ func NewRefMatchQueues(lowPrice, highPrice uint64) *RefMatchQueues {
 	return &RefMatchQueues{buys: buys, sells: sells}
 }
 
+func (m *RefMatchQueues) InBand(price uint64) bool {
+	return price >= m.buys.lowPrice && price <= m.buys.highPrice
+}
+
 func (m *RefMatchQueues) Size() int {
 	return m.size
 }
@@ -37,13 +43,19 @@ func (m *RefMatchQueues) PeekSell() *OrderNode {
 }
 
 func (m *RefMatchQueues) PopBuy() *OrderNode {
-	m.size--
-	return m.buys.popMax()
+	o := m.buys.popMax()
+	if o != nil {
+		m.size--
+	}
+	return o
 }
 
 func (m *RefMatchQueues) PopSell() *OrderNode {
-	m.size--
-	return m.sells.popMin()
+	o := m.sells.popMin()
+	if o != nil {
+		m.size--
+	}
+	return o
 }
 
 func (m *RefMatchQueues) Cancel(o *OrderNode) *OrderNode {
@@ -59,3 +71,60 @@ func (m *RefMatchQueues) Cancel(o *OrderNode) *OrderNode {
 	}
 	return nil
 }
+
+func (m *RefMatchQueues) WalkBuyLimits(f func(l *msg.SurveyLimit) bool) {
+	m.buys.walkDesc(prioLimitWalker(f))
+}
+
+func (m *RefMatchQueues) WalkSellLimits(f func(l *msg.SurveyLimit) bool) {
+	m.sells.walkAsc(prioLimitWalker(f))
+}
+
+func (m *RefMatchQueues) BuyLimit(price uint64) msg.SurveyLimit {
+	return prioLimit(price, m.buys.get(price))
+}
+
+func (m *RefMatchQueues) SellLimit(price uint64) msg.SurveyLimit {
+	return prioLimit(price, m.sells.get(price))
+}
+
+func (m *RefMatchQueues) SurveyBuys(n int) []msg.SurveyLimit {
+	return survey(m.WalkBuyLimits, n)
+}
+
+func (m *RefMatchQueues) SurveySells(n int) []msg.SurveyLimit {
+	return survey(m.WalkSellLimits, n)
+}
+
+func (m *RefMatchQueues) WalkBuys(f func(o *OrderNode)) {
+	m.buys.walkDesc(prioOrderWalker(f))
+}
+
+func (m *RefMatchQueues) WalkSells(f func(o *OrderNode)) {
+	m.sells.walkAsc(prioOrderWalker(f))
+}
+
+func prioLimitWalker(f func(l *msg.SurveyLimit) bool) func(prio []*OrderNode) bool {
+	return func(prio []*OrderNode) bool {
+		l := prioLimit(prio[0].Price(), prio)
+		return f(&l)
+	}
+}
+
+func prioOrderWalker(f func(o *OrderNode)) func(prio []*OrderNode) bool {
+	return func(prio []*OrderNode) bool {
+		for _, o := range prio {
+			f(o)
+		}
+		return true
+	}
+}
+
+func prioLimit(price uint64, prio []*OrderNode) msg.SurveyLimit {
+	l := msg.SurveyLimit{Price: price}
+	for _, o := range prio {
+		l.Size += o.Amount()
+		l.Orders++
+	}
+	return l
+}
@@ -95,7 +95,16 @@ func (b *rbtree) get(val uint64) *node {
 			n = n.right
 		}
 	}
-	panic("Unreachable")
+}
+
+// Visits each distinct value in ascending order until f returns false
+func (b *rbtree) walkAsc(f func(n *node) bool) {
+	b.root.walkAsc(f)
+}
+
+// Visits each distinct value in descending order until f returns false
+func (b *rbtree) walkDesc(f func(n *node) bool) {
+	b.root.walkDesc(f)
 }
 
 type node struct {
@@ -138,6 +147,32 @@ func (n *node) String() string {
 	return b.String()
 }
 
+func (n *node) walkAsc(f func(n *node) bool) bool {
+	if n == nil {
+		return true
+	}
+	return n.left.walkAsc(f) && f(n) && n.right.walkAsc(f)
+}
+
+func (n *node) walkDesc(f func(n *node) bool) bool {
+	if n == nil {
+		return true
+	}
+	return n.right.walkDesc(f) && f(n) && n.left.walkDesc(f)
+}
+
+// Visits n and every node queued behind it, in time priority order
+func (n *node) walkQueue(f func(n *node)) {
+	curr := n
+	for {
+		f(curr)
+		curr = curr.prev
+		if curr == n {
+			return
+		}
+	}
+}
+
 func initNode(o *OrderNode, val uint64, n, other *node) {
 	*n = node{val: val, order: o, other: other}
 	n.next = n
@@ -102,3 +102,28 @@ func (q *pqueue) cancel(guid uint64) *OrderNode {
 	}
 	return nil
 }
+
+// Visits each non-empty priority, lowest price first, until f returns false
+func (q *pqueue) walkAsc(f func(prio []*OrderNode) bool) {
+	for i := 0; i < len(q.prios); i++ {
+		if len(q.prios[i]) > 0 && !f(q.prios[i]) {
+			return
+		}
+	}
+}
+
+// Visits each non-empty priority, highest price first, until f returns false
+func (q *pqueue) walkDesc(f func(prio []*OrderNode) bool) {
+	for i := len(q.prios) - 1; i >= 0; i-- {
+		if len(q.prios[i]) > 0 && !f(q.prios[i]) {
+			return
+		}
+	}
+}
+
+func (q *pqueue) get(price uint64) []*OrderNode {
+	if price < q.lowPrice || price > q.highPrice {
+		return nil
+	}
+	return q.prios[price-q.lowPrice]
+}
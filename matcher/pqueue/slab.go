@@ -2,37 +2,104 @@ package pqueue
 
 import ()
 
+// The allocation state of an OrderNode
+const (
+	notSlabbed = byte(iota) // Allocated outside the slab, left to the garbage collector
+	slabLive   = byte(iota)
+	slabFree   = byte(iota)
+)
+
+// A free-list allocator of OrderNodes. The slab grows in chunks of
+// OrderNodes as needed. If the slab is bounded and every chunk is in use
+// OrderNodes are allocated on the heap instead, these fallbacks are left to
+// the garbage collector when freed.
+//
+// Freeing an OrderNode twice is ignored, unless built with the slabdebug
+// build tag in which case it panics.
 type Slab struct {
-	free   *OrderNode
-	orders []OrderNode
-}
-
-func NewSlab(size int) *Slab {
-	s := &Slab{orders: make([]OrderNode, size)}
-	s.free = &s.orders[0]
-	prev := s.free
-	for i := 1; i < len(s.orders); i++ {
-		curr := &s.orders[i]
-		prev.nextFree = curr
-		prev = curr
+	free      *OrderNode
+	chunkSize int
+	maxChunks int
+	chunks    int
+	stats     SlabStats
+}
+
+type SlabStats struct {
+	Chunks    int // Chunks allocated
+	Capacity  int // OrderNodes managed by the slab
+	Live      int // Slab managed OrderNodes in use
+	Free      int // Slab managed OrderNodes available
+	HighWater int // The greatest number of slab managed OrderNodes ever in use
+	Fallbacks int // OrderNodes allocated on the heap because the slab was full
+}
+
+// Creates an unbounded slab growing chunkSize OrderNodes at a time
+func NewSlab(chunkSize int) *Slab {
+	return NewBoundedSlab(chunkSize, 0)
+}
+
+// Creates a slab growing chunkSize OrderNodes at a time, up to at most
+// maxChunks chunks. A maxChunks of 0 leaves the slab unbounded.
+func NewBoundedSlab(chunkSize, maxChunks int) *Slab {
+	if chunkSize < 1 {
+		chunkSize = 1
 	}
+	s := &Slab{chunkSize: chunkSize, maxChunks: maxChunks}
+	s.grow()
 	return s
 }
 
+func (s *Slab) grow() bool {
+	if s.maxChunks != 0 && s.chunks >= s.maxChunks {
+		return false
+	}
+	chunk := make([]OrderNode, s.chunkSize)
+	for i := len(chunk) - 1; i >= 0; i-- {
+		o := &chunk[i]
+		o.slabState = slabFree
+		o.nextFree = s.free
+		s.free = o
+	}
+	s.chunks++
+	s.stats.Chunks++
+	s.stats.Capacity += s.chunkSize
+	s.stats.Free += s.chunkSize
+	return true
+}
+
 func (s *Slab) Malloc() *OrderNode {
-	o := s.free
-	if o == nil {
-		o = &OrderNode{}
+	if s.free == nil && !s.grow() {
+		s.stats.Fallbacks++
+		return &OrderNode{}
 	}
+	o := s.free
 	s.free = o.nextFree
-	o.nextFree = o // Slab allocated order marker
+	o.nextFree = nil
+	o.slabState = slabLive
+	s.stats.Free--
+	s.stats.Live++
+	if s.stats.Live > s.stats.HighWater {
+		s.stats.HighWater = s.stats.Live
+	}
 	return o
 }
 
 func (s *Slab) Free(o *OrderNode) {
-	if o.nextFree == o {
+	switch o.slabState {
+	case slabLive:
+		o.slabState = slabFree
 		o.nextFree = s.free
 		s.free = o
+		s.stats.Live--
+		s.stats.Free++
+	case slabFree:
+		if slabDebug {
+			panic("Double free of slab allocated OrderNode " + o.String())
+		}
 	}
 	// OrderNodes that were not slab allocated are left to the garbage collector
 }
+
+func (s *Slab) Stats() SlabStats {
+	return s.stats
+}
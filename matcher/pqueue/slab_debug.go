@@ -0,0 +1,5 @@
+//go:build slabdebug
+
+package pqueue
+
+const slabDebug = true
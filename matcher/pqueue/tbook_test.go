@@ -0,0 +1,86 @@
+package pqueue
+
+import (
+	"github.com/fmstephe/matching_engine/msg"
+	"math/rand"
+	"testing"
+)
+
+func TestRefMatchesMatchQueues(t *testing.T) {
+	for _, r := range [][2]uint64{{1, 1}, {10, 20}, {100, 200}} {
+		q := NewRefMatchQueues(r[0], r[1])
+		testMatchesMatchQueues(t, q, func(t *testing.T) {}, 1000, r[0], r[1])
+	}
+}
+
+// Applies the same random operations to a MatchQueues and q, calling validate after each operation
+func testMatchesMatchQueues(t *testing.T, q OrderBook, validate func(t *testing.T), opCount int, lowPrice, highPrice uint64) {
+	r := rand.New(rand.NewSource(1))
+	ref := &MatchQueues{}
+	guids := []uint32{}
+	for i := 0; i < opCount; i++ {
+		switch r.Intn(5) {
+		case 0, 1:
+			kind := msg.BUY
+			if r.Intn(2) == 0 {
+				kind = msg.SELL
+			}
+			price := lowPrice + uint64(r.Int63n(int64(highPrice-lowPrice+1)))
+			amount := uint64(r.Intn(10) + 1)
+			tradeId := uint32(i + 1)
+			guids = append(guids, tradeId)
+			if kind == msg.BUY {
+				ref.PushBuy(mkOrder(kind, price, amount, tradeId))
+				q.PushBuy(mkOrder(kind, price, amount, tradeId))
+			} else {
+				ref.PushSell(mkOrder(kind, price, amount, tradeId))
+				q.PushSell(mkOrder(kind, price, amount, tradeId))
+			}
+		case 2:
+			if r.Intn(2) == 0 {
+				expectSameOrder(t, ref.PopBuy(), q.PopBuy())
+			} else {
+				expectSameOrder(t, ref.PopSell(), q.PopSell())
+			}
+		case 3:
+			if len(guids) == 0 {
+				continue
+			}
+			c := mkOrder(msg.CANCEL, 0, 0, guids[r.Intn(len(guids))])
+			expectSameOrder(t, ref.Cancel(c), q.Cancel(c))
+		case 4:
+			rb, qb := ref.PeekBuy(), q.PeekBuy()
+			expectSameOrder(t, rb, qb)
+			if rb != nil && rb.Amount() > 1 {
+				rb.ReduceAmount(1)
+				qb.ReduceAmount(1)
+			}
+		}
+		expectSameOrder(t, ref.PeekBuy(), q.PeekBuy())
+		expectSameOrder(t, ref.PeekSell(), q.PeekSell())
+		if ref.Size() != q.Size() {
+			t.Errorf("Expecting size %d, found %d", ref.Size(), q.Size())
+		}
+		expectLimits(t, ref.SurveyBuys(5), q.SurveyBuys(5))
+		expectLimits(t, ref.SurveySells(5), q.SurveySells(5))
+		validate(t)
+		if t.Failed() {
+			return
+		}
+	}
+}
+
+func mkOrder(kind msg.MsgKind, price, amount uint64, tradeId uint32) *OrderNode {
+	o := &OrderNode{}
+	o.CopyFrom(&msg.Message{Kind: kind, Price: price, Amount: amount, TraderId: 1, TradeId: tradeId, StockId: 1})
+	return o
+}
+
+func expectSameOrder(t *testing.T, expected, found *OrderNode) {
+	if expected == nil && found == nil {
+		return
+	}
+	if expected == nil || found == nil || expected.Guid() != found.Guid() || expected.Price() != found.Price() || expected.Amount() != found.Amount() {
+		t.Errorf("Expecting %v, found %v", expected, found)
+	}
+}
@@ -0,0 +1,103 @@
+package pqueue
+
+import (
+	"github.com/fmstephe/matching_engine/msg"
+	"math/rand"
+	"testing"
+)
+
+func TestLadderMatchesMatchQueues(t *testing.T) {
+	// The widest band spans several summary words
+	for _, r := range [][2]uint64{{1, 1}, {10, 20}, {100, 200}, {1, 10000}} {
+		q := NewLadderMatchQueues(r[0], r[1])
+		testMatchesMatchQueues(t, q, func(t *testing.T) { validateLadder(t, q) }, 1000, r[0], r[1])
+	}
+}
+
+func TestLadderBand(t *testing.T) {
+	q := NewLadderMatchQueues(10, 20)
+	if q.InBand(9) || !q.InBand(10) || !q.InBand(20) || q.InBand(21) {
+		t.Errorf("Expecting band of 10-20")
+	}
+	expectLimit(t, msg.SurveyLimit{Price: 21}, q.BuyLimit(21))
+	for _, price := range []uint64{9, 21} {
+		expectPushPanics(t, func() { q.PushBuy(mkOrder(msg.BUY, price, 1, 1)) })
+		expectPushPanics(t, func() { q.PushSell(mkOrder(msg.SELL, price, 1, 1)) })
+	}
+	// A failed push leaves the book untouched
+	if q.Size() != 0 || q.PeekBuy() != nil || q.PeekSell() != nil {
+		t.Errorf("Expecting an empty book, found size %d", q.Size())
+	}
+}
+
+func expectPushPanics(t *testing.T, push func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expecting push outside of band to panic")
+		}
+	}()
+	push()
+}
+
+// Compares the bitmap searches with a scan over every level
+func TestLadderBitmap(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	s := mkLadderSide(0, 9999, true)
+	occupied := make([]bool, len(s.levels))
+	for i := 0; i < 2000; i++ {
+		idx := r.Intn(len(s.levels))
+		if occupied[idx] {
+			s.clear(idx)
+		} else {
+			s.set(idx)
+		}
+		occupied[idx] = !occupied[idx]
+		from := r.Intn(len(s.levels))
+		if expected, found := scanHighest(occupied, from), s.highestFrom(from); expected != found {
+			t.Errorf("Expecting highest from %d to be %d, found %d", from, expected, found)
+		}
+		if expected, found := scanLowest(occupied, from), s.lowestFrom(from); expected != found {
+			t.Errorf("Expecting lowest from %d to be %d, found %d", from, expected, found)
+		}
+	}
+}
+
+func scanHighest(occupied []bool, from int) int {
+	for i := from; i >= 0; i-- {
+		if occupied[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+func scanLowest(occupied []bool, from int) int {
+	for i := from; i < len(occupied); i++ {
+		if occupied[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+func validateLadder(t *testing.T, q *LadderMatchQueues) {
+	for _, s := range []*ladderSide{q.buys, q.sells} {
+		best := -1
+		for i := range s.levels {
+			occupied := s.levels[i].orders != 0
+			if occupied != (s.bits[i>>6]&(1<<uint(i&63)) != 0) {
+				t.Errorf("Level %d occupied %v, but bitmap disagrees", i, occupied)
+			}
+			if occupied && (best == -1 || s.buy) {
+				best = i
+			}
+		}
+		if best != s.best {
+			t.Errorf("Expecting best level %d, found %d", best, s.best)
+		}
+	}
+	if err := validateRBT(&q.orders); err != nil {
+		t.Errorf(err.Error())
+	}
+}
@@ -0,0 +1,69 @@
+package pqueue
+
+import (
+	"github.com/fmstephe/matching_engine/msg"
+	"testing"
+)
+
+func TestLevelLimits(t *testing.T) {
+	q := &LevelMatchQueues{}
+	os := []*OrderNode{
+		mkOrder(msg.BUY, 5, 1, 1),
+		mkOrder(msg.BUY, 7, 2, 2),
+		mkOrder(msg.BUY, 5, 3, 3),
+		mkOrder(msg.SELL, 9, 1, 4),
+		mkOrder(msg.SELL, 9, 3, 5),
+	}
+	for _, o := range os {
+		if o.Kind() == msg.BUY {
+			q.PushBuy(o)
+		} else {
+			q.PushSell(o)
+		}
+	}
+	expectLimits(t, []msg.SurveyLimit{{Price: 7, Size: 2, Orders: 1}, {Price: 5, Size: 4, Orders: 2}}, q.SurveyBuys(10))
+	expectLimits(t, []msg.SurveyLimit{{Price: 9, Size: 4, Orders: 2}}, q.SurveySells(10))
+	// Reducing an order's amount is reflected in its level
+	q.PeekSell().ReduceAmount(1)
+	expectLimit(t, msg.SurveyLimit{Price: 9, Size: 3, Orders: 2}, q.SellLimit(9))
+	// Orders within a level are popped in time priority order
+	if o := q.PopSell(); o != os[3] {
+		t.Errorf("Expecting %v, found %v", os[3], o)
+	}
+	expectLimit(t, msg.SurveyLimit{Price: 9, Size: 3, Orders: 1}, q.SellLimit(9))
+	// Cancelling the last order at a price removes the level
+	if o := q.Cancel(mkOrder(msg.CANCEL, 7, 0, 2)); o != os[1] {
+		t.Errorf("Expecting %v, found %v", os[1], o)
+	}
+	expectLimits(t, []msg.SurveyLimit{{Price: 5, Size: 4, Orders: 2}}, q.SurveyBuys(10))
+	expectLimit(t, msg.SurveyLimit{Price: 7}, q.BuyLimit(7))
+	// Removing an order directly also updates its level
+	q.PeekBuy().Remove()
+	expectLimit(t, msg.SurveyLimit{Price: 5, Size: 3, Orders: 1}, q.BuyLimit(5))
+}
+
+func TestLevelMatchesMatchQueues(t *testing.T) {
+	for _, r := range [][2]uint64{{1, 1}, {10, 20}, {100, 10000}} {
+		q := &LevelMatchQueues{}
+		testMatchesMatchQueues(t, q, func(t *testing.T) { validateLevels(t, q) }, 1000, r[0], r[1])
+	}
+}
+
+func validateLevels(t *testing.T, q *LevelMatchQueues) {
+	for _, s := range []*levelSide{&q.buys, &q.sells} {
+		if err := validateRBT(&s.tree); err != nil {
+			t.Errorf(err.Error())
+		}
+		count := 0
+		s.walkAsc(func(l *level) bool {
+			count++
+			return true
+		})
+		if count != len(s.levels) {
+			t.Errorf("Expecting %d levels in tree, found %d", len(s.levels), count)
+		}
+	}
+	if err := validateRBT(&q.orders); err != nil {
+		t.Errorf(err.Error())
+	}
+}
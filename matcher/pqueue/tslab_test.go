@@ -0,0 +1,79 @@
+package pqueue
+
+import (
+	"testing"
+)
+
+func TestSlabGrows(t *testing.T) {
+	s := NewSlab(4)
+	expectStats(t, SlabStats{Chunks: 1, Capacity: 4, Free: 4}, s.Stats())
+	os := make([]*OrderNode, 10)
+	for i := range os {
+		os[i] = s.Malloc()
+	}
+	expectStats(t, SlabStats{Chunks: 3, Capacity: 12, Live: 10, Free: 2, HighWater: 10}, s.Stats())
+	for _, o := range os {
+		s.Free(o)
+	}
+	expectStats(t, SlabStats{Chunks: 3, Capacity: 12, Free: 12, HighWater: 10}, s.Stats())
+}
+
+func TestSlabReusesFreed(t *testing.T) {
+	s := NewSlab(2)
+	os := map[*OrderNode]bool{}
+	for i := 0; i < 2; i++ {
+		os[s.Malloc()] = true
+	}
+	for o := range os {
+		s.Free(o)
+	}
+	for i := 0; i < 2; i++ {
+		o := s.Malloc()
+		if !os[o] {
+			t.Errorf("Expecting a freed OrderNode to be reused")
+		}
+	}
+	expectStats(t, SlabStats{Chunks: 1, Capacity: 2, Live: 2, HighWater: 2}, s.Stats())
+}
+
+func TestBoundedSlabFallsBack(t *testing.T) {
+	s := NewBoundedSlab(2, 2)
+	os := make([]*OrderNode, 6)
+	for i := range os {
+		os[i] = s.Malloc()
+	}
+	expectStats(t, SlabStats{Chunks: 2, Capacity: 4, Live: 4, HighWater: 4, Fallbacks: 2}, s.Stats())
+	// Freeing heap allocated OrderNodes leaves the slab untouched
+	s.Free(os[4])
+	s.Free(os[5])
+	expectStats(t, SlabStats{Chunks: 2, Capacity: 4, Live: 4, HighWater: 4, Fallbacks: 2}, s.Stats())
+	s.Free(os[0])
+	o := s.Malloc()
+	if o != os[0] {
+		t.Errorf("Expecting a freed OrderNode to be reused before falling back")
+	}
+	expectStats(t, SlabStats{Chunks: 2, Capacity: 4, Live: 4, HighWater: 4, Fallbacks: 2}, s.Stats())
+}
+
+func TestSlabDoubleFree(t *testing.T) {
+	s := NewSlab(2)
+	o := s.Malloc()
+	s.Free(o)
+	defer func() {
+		r := recover()
+		if slabDebug && r == nil {
+			t.Errorf("Expecting double free to panic in debug builds")
+		}
+		if !slabDebug && r != nil {
+			t.Errorf("Expecting double free to be ignored, found panic %v", r)
+		}
+		expectStats(t, SlabStats{Chunks: 1, Capacity: 2, Free: 2, HighWater: 1}, s.Stats())
+	}()
+	s.Free(o)
+}
+
+func expectStats(t *testing.T, expected, found SlabStats) {
+	if expected != found {
+		t.Errorf("Expecting %+v, found %+v", expected, found)
+	}
+}
@@ -0,0 +1,61 @@
+package pqueue
+
+import (
+	"github.com/fmstephe/matching_engine/msg"
+	"testing"
+)
+
+func pushOrder(q *MatchQueues, kind msg.MsgKind, price, amount uint64, tradeId uint32) {
+	o := &OrderNode{}
+	o.CopyFrom(&msg.Message{Kind: kind, Price: price, Amount: amount, TraderId: 1, TradeId: tradeId, StockId: 1})
+	if kind == msg.BUY {
+		q.PushBuy(o)
+	} else {
+		q.PushSell(o)
+	}
+}
+
+func TestSurveyEmpty(t *testing.T) {
+	q := &MatchQueues{}
+	expectLimits(t, []msg.SurveyLimit{}, q.SurveyBuys(5))
+	expectLimits(t, []msg.SurveyLimit{}, q.SurveySells(5))
+	expectLimit(t, msg.SurveyLimit{Price: 7}, q.BuyLimit(7))
+}
+
+func TestSurveyAggregatesLimits(t *testing.T) {
+	q := &MatchQueues{}
+	pushOrder(q, msg.BUY, 5, 1, 1)
+	pushOrder(q, msg.BUY, 7, 2, 2)
+	pushOrder(q, msg.BUY, 5, 3, 3)
+	pushOrder(q, msg.BUY, 6, 4, 4)
+	pushOrder(q, msg.SELL, 9, 1, 5)
+	pushOrder(q, msg.SELL, 8, 2, 6)
+	pushOrder(q, msg.SELL, 9, 3, 7)
+	buys := []msg.SurveyLimit{{Price: 7, Size: 2, Orders: 1}, {Price: 6, Size: 4, Orders: 1}, {Price: 5, Size: 4, Orders: 2}}
+	sells := []msg.SurveyLimit{{Price: 8, Size: 2, Orders: 1}, {Price: 9, Size: 4, Orders: 2}}
+	expectLimits(t, buys, q.SurveyBuys(10))
+	expectLimits(t, buys[:2], q.SurveyBuys(2))
+	expectLimits(t, sells, q.SurveySells(10))
+	expectLimits(t, sells[:1], q.SurveySells(1))
+	expectLimit(t, buys[2], q.BuyLimit(5))
+	expectLimit(t, sells[1], q.SellLimit(9))
+	// Popping the best buy removes its limit
+	q.PopBuy()
+	expectLimits(t, buys[1:], q.SurveyBuys(10))
+}
+
+func expectLimits(t *testing.T, expected, found []msg.SurveyLimit) {
+	if len(expected) != len(found) {
+		t.Errorf("Expecting %d limits, found %d\n%v\n%v", len(expected), len(found), expected, found)
+		return
+	}
+	for i := range expected {
+		expectLimit(t, expected[i], found[i])
+	}
+}
+
+func expectLimit(t *testing.T, expected, found msg.SurveyLimit) {
+	if expected != found {
+		t.Errorf("Expecting limit %v, found %v", expected, found)
+	}
+}
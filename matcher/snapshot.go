@@ -0,0 +1,249 @@
+package matcher
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/fmstephe/matching_engine/matcher/pqueue"
+	"github.com/fmstephe/matching_engine/msg"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// A snapshot is laid out as
+//
+//	header:  magic (8 bytes), version (4 bytes)
+//	counters: feedSeq (4 bytes), nextRef (4 bytes)
+//	stocks:  stock count (8 bytes), then for each stock in ascending stockId order
+//	         stockId (8 bytes), buy count (8 bytes), buys, sell count (8 bytes), sells
+//	trailer: crc32 of everything preceding it (4 bytes)
+//
+// Each order is a marshalled msg.Message followed by its order reference (4 bytes).
+// Orders are written in priority order, so pushing them back in the order they
+// are read restores their time priority.
+const (
+	snapshotMagic   = "MESNAP\x00\x00"
+	SnapshotVersion = uint32(1)
+	orderRecordSize = msg.ByteSize + 4
+)
+
+var snapshotCoder = binary.LittleEndian
+
+// Writes the state of every book, and the matcher's counters, to w
+func (m *M) Snapshot(w io.Writer) error {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	sw := &snapshotWriter{w: bw}
+	sw.write([]byte(snapshotMagic))
+	sw.putUint32(SnapshotVersion)
+	sw.putUint32(m.feedSeq)
+	sw.putUint32(m.nextRef)
+	stockIds := make([]uint64, 0, len(m.books))
+	for stockId := range m.books {
+		stockIds = append(stockIds, stockId)
+	}
+	sort.Slice(stockIds, func(i, j int) bool { return stockIds[i] < stockIds[j] })
+	sw.putUint64(uint64(len(stockIds)))
+	for _, stockId := range stockIds {
+		q := m.books[stockId]
+		sw.putUint64(stockId)
+		sw.putOrders(q.WalkBuys)
+		sw.putOrders(q.WalkSells)
+	}
+	if sw.err != nil {
+		return sw.err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	trailer := make([]byte, 4)
+	snapshotCoder.PutUint32(trailer, crc.Sum32())
+	_, err := w.Write(trailer)
+	return err
+}
+
+// Replaces the state of every book, and the matcher's counters, with the snapshot read from r.
+// Exactly the snapshot's bytes are read from r, anything following it is left unread.
+// If the snapshot can't be restored the matcher is left unchanged.
+func (m *M) Restore(r io.Reader) (err error) {
+	crc := crc32.NewIEEE()
+	sr := &snapshotReader{r: io.TeeReader(r, crc)}
+	magic := sr.read(len(snapshotMagic))
+	if sr.err == nil && string(magic) != snapshotMagic {
+		return errors.New("Not a matcher snapshot")
+	}
+	if version := sr.getUint32(); sr.err == nil && version != SnapshotVersion {
+		return errors.New(fmt.Sprintf("Unsupported snapshot version. Expecting %d, found %d", SnapshotVersion, version))
+	}
+	feedSeq := sr.getUint32()
+	nextRef := sr.getUint32()
+	books := make(map[uint64]pqueue.OrderBook)
+	defer func() {
+		// Return the orders of a partial restore to the slab
+		if err != nil {
+			m.freeOrders(books)
+		}
+	}()
+	stockCount := sr.getUint64()
+	for i := uint64(0); i < stockCount && sr.err == nil; i++ {
+		stockId := sr.getUint64()
+		q := m.mkBook(stockId)
+		books[stockId] = q
+		sr.readOrders(m.slab, q, stockId, msg.BUY)
+		sr.readOrders(m.slab, q, stockId, msg.SELL)
+	}
+	if sr.err != nil {
+		return sr.err
+	}
+	sum := crc.Sum32()
+	if found := sr.getUint32(); sr.err != nil {
+		return sr.err
+	} else if found != sum {
+		return errors.New(fmt.Sprintf("Corrupt snapshot. Expecting checksum %d, found %d", sum, found))
+	}
+	m.freeOrders(m.books)
+	m.books = books
+	m.feedSeq = feedSeq
+	m.nextRef = nextRef
+	return nil
+}
+
+// Returns every order resting in books to the slab
+func (m *M) freeOrders(books map[uint64]pqueue.OrderBook) {
+	var orders []*pqueue.OrderNode
+	collect := func(o *pqueue.OrderNode) { orders = append(orders, o) }
+	for _, q := range books {
+		q.WalkBuys(collect)
+		q.WalkSells(collect)
+	}
+	for _, o := range orders {
+		m.slab.Free(o)
+	}
+}
+
+// Writes a snapshot to path. The snapshot is written to a temporary file
+// which is synced and then renamed, so path never contains a partial snapshot.
+func (m *M) SnapshotFile(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := m.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Restores the snapshot written to path
+func (m *M) RestoreFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Nothing else reads f, so it can be buffered
+	return m.Restore(bufio.NewReader(f))
+}
+
+type snapshotWriter struct {
+	w   io.Writer
+	buf [orderRecordSize]byte
+	err error
+}
+
+func (sw *snapshotWriter) write(b []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(b)
+	}
+}
+
+func (sw *snapshotWriter) putUint32(v uint32) {
+	snapshotCoder.PutUint32(sw.buf[:4], v)
+	sw.write(sw.buf[:4])
+}
+
+func (sw *snapshotWriter) putUint64(v uint64) {
+	snapshotCoder.PutUint64(sw.buf[:8], v)
+	sw.write(sw.buf[:8])
+}
+
+func (sw *snapshotWriter) putOrders(walk func(f func(o *pqueue.OrderNode))) {
+	count := uint64(0)
+	walk(func(o *pqueue.OrderNode) { count++ })
+	sw.putUint64(count)
+	m := &msg.Message{}
+	walk(func(o *pqueue.OrderNode) {
+		o.CopyTo(m)
+		m.Marshal(sw.buf[:msg.ByteSize])
+		snapshotCoder.PutUint32(sw.buf[msg.ByteSize:], o.Ref())
+		sw.write(sw.buf[:])
+	})
+}
+
+type snapshotReader struct {
+	r   io.Reader
+	buf [orderRecordSize]byte
+	err error
+}
+
+func (sr *snapshotReader) read(n int) []byte {
+	b := sr.buf[:n]
+	if sr.err != nil {
+		return b
+	}
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		sr.err = err
+	}
+	return b
+}
+
+func (sr *snapshotReader) getUint32() uint32 {
+	return snapshotCoder.Uint32(sr.read(4))
+}
+
+func (sr *snapshotReader) getUint64() uint64 {
+	return snapshotCoder.Uint64(sr.read(8))
+}
+
+func (sr *snapshotReader) readOrders(slab *pqueue.Slab, q pqueue.OrderBook, stockId uint64, kind msg.MsgKind) {
+	count := sr.getUint64()
+	m := &msg.Message{}
+	for i := uint64(0); i < count && sr.err == nil; i++ {
+		b := sr.read(orderRecordSize)
+		if sr.err != nil {
+			return
+		}
+		m.Unmarshal(b[:msg.ByteSize])
+		if m.Kind != kind || m.StockId != stockId {
+			sr.err = errors.New(fmt.Sprintf("Corrupt snapshot. Expecting %v order for stock %d, found %v", kind, stockId, m))
+			return
+		}
+		if !q.InBand(m.Price) {
+			sr.err = errors.New(fmt.Sprintf("Snapshot order %v is outside the band of its book", m))
+			return
+		}
+		o := slab.Malloc()
+		o.CopyFrom(m)
+		o.SetRef(snapshotCoder.Uint32(b[msg.ByteSize:]))
+		if kind == msg.BUY {
+			q.PushBuy(o)
+		} else {
+			q.PushSell(o)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package matcher
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/msg"
+	"testing"
+)
+
+var batchMaker = msg.NewMessageMaker(1)
+
+func TestSubmitBatchMatchesSubmit(t *testing.T) {
+	testSet, err := batchMaker.RndTradeSet(1000, 100, 1, 50)
+	if err != nil {
+		panic(err.Error())
+	}
+	ref := NewMatcher(100)
+	refOut := &sliceWriter{}
+	ref.Config("Reference", coordinator.NewNoopReaderWriter(), refOut)
+	for i := range testSet {
+		ref.Submit(&testSet[i])
+	}
+	for _, batchSize := range []int{1, 7, 100, len(testSet)} {
+		m := NewMatcher(100)
+		m.Config("Batched", coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+		out := []msg.Message{}
+		for i := 0; i < len(testSet); i += batchSize {
+			end := i + batchSize
+			if end > len(testSet) {
+				end = len(testSet)
+			}
+			out = m.SubmitBatch(testSet[i:end], out)
+		}
+		expectSame(t, refOut.ms, out)
+	}
+}
+
+func TestSubmitBatchShutdown(t *testing.T) {
+	out := &sliceWriter{}
+	m := NewMatcher(100)
+	m.Config("Batched", coordinator.NewNoopReaderWriter(), out)
+	b := msg.Message{Kind: msg.BUY, Price: 5, Amount: 1, TraderId: trader1, TradeId: 1, StockId: stockId}
+	s := msg.Message{Kind: msg.SELL, Price: 5, Amount: 1, TraderId: trader2, TradeId: 1, StockId: stockId}
+	shutdown := msg.Message{Kind: msg.SHUTDOWN}
+	// Existing contents of out are kept
+	prior := msg.Message{Kind: msg.NEW_TRADER, TraderId: trader1}
+	found := m.SubmitBatch([]msg.Message{b, shutdown, s}, []msg.Message{prior})
+	expectSame(t, []msg.Message{prior, shutdown}, found)
+	// Nothing was written to Out while batching, and the sell was never submitted
+	if len(out.ms) != 0 {
+		t.Errorf("Expecting no messages written to Out, found %v", out.ms)
+	}
+	buys, sells := m.Survey(stockId, 10)
+	if len(buys) != 1 || len(sells) != 0 {
+		t.Errorf("Expecting a single resting buy, found buys %v sells %v", buys, sells)
+	}
+	// Outside of a batch outputs are written to Out again
+	m.Submit(&s)
+	if len(out.ms) != 2 {
+		t.Errorf("Expecting 2 messages written to Out, found %v", out.ms)
+	}
+}
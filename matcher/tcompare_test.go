@@ -2,6 +2,7 @@ package matcher
 
 import (
 	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher/pqueue"
 	"github.com/fmstephe/matching_engine/msg"
 	"testing"
 )
@@ -25,7 +26,33 @@ func TestCompareMatchers(t *testing.T) {
 	compareMatchers(t, 100, 100, 100, 2000)
 }
 
+// Every book type, each able to hold prices from lowPrice to highPrice
+func bookMakers(lowPrice, highPrice uint64) map[string]BookMaker {
+	return map[string]BookMaker{
+		"MatchQueues":      MatchQueuesMaker,
+		"LevelMatchQueues": func(stockId uint64) pqueue.OrderBook { return &pqueue.LevelMatchQueues{} },
+		"LadderMatchQueues": func(stockId uint64) pqueue.OrderBook {
+			return pqueue.NewLadderMatchQueues(lowPrice, highPrice)
+		},
+		"RefMatchQueues": func(stockId uint64) pqueue.OrderBook {
+			return pqueue.NewRefMatchQueues(lowPrice, highPrice)
+		},
+	}
+}
+
 func compareMatchers(t *testing.T, orderPairs, depth int, lowPrice, highPrice uint64) {
+	testSet, err := cmprMaker.RndTradeSet(orderPairs, depth, lowPrice, highPrice)
+	if err != nil {
+		panic(err.Error())
+	}
+	for name, mkBook := range bookMakers(lowPrice, highPrice) {
+		t.Run(name, func(t *testing.T) {
+			compareMatcher(t, mkBook, testSet, orderPairs, lowPrice, highPrice)
+		})
+	}
+}
+
+func compareMatcher(t *testing.T, mkBook BookMaker, testSet []msg.Message, orderPairs int, lowPrice, highPrice uint64) {
 	refIn := coordinator.NewChanReaderWriter(1)
 	refOut := coordinator.NewChanReaderWriter(orderPairs * 4)
 	refm := newRefmatcher(lowPrice, highPrice)
@@ -33,11 +60,8 @@ func compareMatchers(t *testing.T, orderPairs, depth int, lowPrice, highPrice ui
 	in := coordinator.NewChanReaderWriter(1)
 	out := coordinator.NewChanReaderWriter(orderPairs * 4)
 	m := NewMatcher(orderPairs * 4)
+	m.SetBookMaker(mkBook)
 	m.Config("Real Matcher", in, out)
-	testSet, err := cmprMaker.RndTradeSet(orderPairs, depth, lowPrice, highPrice)
-	if err != nil {
-		panic(err.Error())
-	}
 	go m.Run()
 	go refm.Run()
 	for i := 0; i < len(testSet); i++ {
@@ -97,3 +97,32 @@ func validate(t *testing.T, m, e *msg.Message, stackOffset int) {
 func TestRunCoordinatedTestSuite(t *testing.T) {
 	RunTestSuite(t, newMatchTesterMaker())
 }
+
+// Connects the matcher and the test client over a loopback TCP connection
+type streamTesterMaker struct {
+	t *testing.T
+}
+
+func (tm *streamTesterMaker) Make() MatchTester {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tm.t.Fatal(err.Error())
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		tm.t.Fatal(err.Error())
+	}
+	server, err := l.Accept()
+	if err != nil {
+		tm.t.Fatal(err.Error())
+	}
+	m := NewMatcher(100)
+	coordinator.Stream(server, server, m, 0, "Matching Engine", false)
+	fromListener, toResponder := coordinator.StreamListenerResponder(client, client, "Test Client    ", false)
+	return &netwkTester{receivedMsgs: fromListener, toSendMsgs: toResponder}
+}
+
+func TestRunStreamTestSuite(t *testing.T) {
+	RunTestSuite(t, &streamTesterMaker{t: t})
+}
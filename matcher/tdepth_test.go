@@ -0,0 +1,73 @@
+package matcher
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/msg"
+	"testing"
+)
+
+func mkDepthMatcher() (*M, *coordinator.ChanReaderWriter) {
+	out := coordinator.NewChanReaderWriter(100)
+	depth := coordinator.NewChanReaderWriter(100)
+	m := NewMatcher(100)
+	m.Config("Depth Matcher", coordinator.NewNoopReaderWriter(), out)
+	m.SetDepth(depth)
+	return m, depth
+}
+
+func expectDepth(t *testing.T, depth coordinator.MsgReader, kind msg.MsgKind, price, size uint64, orders uint32) {
+	e := msg.Message{Kind: kind, Price: price, Amount: size, StockId: stockId, TradeId: orders}
+	d := depth.Read()
+	validate(t, &d, &e, 2)
+}
+
+func TestDepthRestingOrders(t *testing.T) {
+	m, depth := mkDepthMatcher()
+	m.Submit(&msg.Message{Kind: msg.BUY, Price: 5, Amount: 2, TraderId: trader1, TradeId: 1, StockId: stockId})
+	expectDepth(t, depth, msg.BUY_DEPTH, 5, 2, 1)
+	m.Submit(&msg.Message{Kind: msg.BUY, Price: 5, Amount: 3, TraderId: trader2, TradeId: 1, StockId: stockId})
+	expectDepth(t, depth, msg.BUY_DEPTH, 5, 5, 2)
+	m.Submit(&msg.Message{Kind: msg.SELL, Price: 9, Amount: 1, TraderId: trader3, TradeId: 1, StockId: stockId})
+	expectDepth(t, depth, msg.SELL_DEPTH, 9, 1, 1)
+	// Cancel the first buy
+	m.Submit(&msg.Message{Kind: msg.CANCEL, Price: 5, Amount: 2, TraderId: trader1, TradeId: 1, StockId: stockId})
+	expectDepth(t, depth, msg.BUY_DEPTH, 5, 3, 1)
+	// A cancel which removes nothing publishes nothing
+	m.Submit(&msg.Message{Kind: msg.CANCEL, Price: 5, Amount: 2, TraderId: trader1, TradeId: 1, StockId: stockId})
+	m.Submit(&msg.Message{Kind: msg.CANCEL, Price: 9, Amount: 1, TraderId: trader3, TradeId: 1, StockId: stockId})
+	expectDepth(t, depth, msg.SELL_DEPTH, 9, 0, 0)
+	buys, sells := m.Survey(stockId, 10)
+	if len(buys) != 1 || buys[0] != (msg.SurveyLimit{Price: 5, Size: 3, Orders: 1}) {
+		t.Errorf("Unexpected buy survey %v", buys)
+	}
+	if len(sells) != 0 {
+		t.Errorf("Unexpected sell survey %v", sells)
+	}
+}
+
+func TestDepthMatchAcrossLimits(t *testing.T) {
+	m, depth := mkDepthMatcher()
+	m.Submit(&msg.Message{Kind: msg.SELL, Price: 7, Amount: 1, TraderId: trader1, TradeId: 1, StockId: stockId})
+	expectDepth(t, depth, msg.SELL_DEPTH, 7, 1, 1)
+	m.Submit(&msg.Message{Kind: msg.SELL, Price: 7, Amount: 1, TraderId: trader1, TradeId: 2, StockId: stockId})
+	expectDepth(t, depth, msg.SELL_DEPTH, 7, 2, 2)
+	m.Submit(&msg.Message{Kind: msg.SELL, Price: 8, Amount: 2, TraderId: trader1, TradeId: 3, StockId: stockId})
+	expectDepth(t, depth, msg.SELL_DEPTH, 8, 2, 1)
+	// Sweep both sells at 7, part of the sell at 8 and rest the remainder
+	m.Submit(&msg.Message{Kind: msg.BUY, Price: 8, Amount: 5, TraderId: trader2, TradeId: 1, StockId: stockId})
+	expectDepth(t, depth, msg.SELL_DEPTH, 7, 0, 0)
+	expectDepth(t, depth, msg.SELL_DEPTH, 8, 0, 0)
+	expectDepth(t, depth, msg.BUY_DEPTH, 8, 1, 1)
+}
+
+// Surveying a stock never traded neither finds limits nor creates a book
+func TestSurveyUnknownStock(t *testing.T) {
+	m, _ := mkDepthMatcher()
+	buys, sells := m.Survey(stockId, 10)
+	if len(buys) != 0 || len(sells) != 0 {
+		t.Errorf("Unexpected survey %v %v", buys, sells)
+	}
+	if len(m.books) != 0 {
+		t.Errorf("Expecting no books, found %d", len(m.books))
+	}
+}
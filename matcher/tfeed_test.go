@@ -0,0 +1,145 @@
+package matcher
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/msg"
+	"sort"
+	"testing"
+)
+
+var feedMaker = msg.NewMessageMaker(1)
+
+func expectBookEvent(t *testing.T, feed coordinator.MsgReader, kind msg.MsgKind, seq, ref uint32, price, amount uint64) {
+	e := msg.Message{}
+	e.WriteBookEvent(kind, seq, ref, stockId, price, amount)
+	f := feed.Read()
+	validate(t, &f, &e, 2)
+}
+
+func TestOrderFeedEvents(t *testing.T) {
+	feed := coordinator.NewChanReaderWriter(100)
+	m := NewMatcher(100)
+	m.Config("Feed Matcher", coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+	m.SetOrderFeed(feed)
+	m.Submit(&msg.Message{Kind: msg.SELL, Price: 7, Amount: 3, TraderId: trader1, TradeId: 1, StockId: stockId})
+	expectBookEvent(t, feed, msg.BOOK_ADD_SELL, 1, 1, 7, 3)
+	m.Submit(&msg.Message{Kind: msg.BUY, Price: 5, Amount: 2, TraderId: trader2, TradeId: 1, StockId: stockId})
+	expectBookEvent(t, feed, msg.BOOK_ADD_BUY, 2, 2, 5, 2)
+	// Partially execute the sell, the trade price is the mid price
+	m.Submit(&msg.Message{Kind: msg.BUY, Price: 9, Amount: 1, TraderId: trader3, TradeId: 1, StockId: stockId})
+	expectBookEvent(t, feed, msg.BOOK_EXECUTE, 3, 1, 8, 1)
+	// Sweep the sell and rest the remainder
+	m.Submit(&msg.Message{Kind: msg.BUY, Price: 7, Amount: 4, TraderId: trader3, TradeId: 2, StockId: stockId})
+	expectBookEvent(t, feed, msg.BOOK_EXECUTE, 4, 1, 7, 2)
+	expectBookEvent(t, feed, msg.BOOK_ADD_BUY, 5, 3, 7, 2)
+	// Cancel the first buy
+	m.Submit(&msg.Message{Kind: msg.CANCEL, Price: 5, Amount: 2, TraderId: trader2, TradeId: 1, StockId: stockId})
+	expectBookEvent(t, feed, msg.BOOK_DELETE, 6, 2, 5, 2)
+}
+
+type shadowOrder struct {
+	kind   msg.MsgKind
+	price  uint64
+	amount uint64
+}
+
+// A book rebuilt purely from order feed events
+type shadowBook map[uint32]*shadowOrder
+
+func (b shadowBook) apply(t *testing.T, e *msg.Message) {
+	switch e.Kind {
+	case msg.BOOK_ADD_BUY:
+		b[e.TradeId] = &shadowOrder{kind: msg.BUY, price: e.Price, amount: e.Amount}
+	case msg.BOOK_ADD_SELL:
+		b[e.TradeId] = &shadowOrder{kind: msg.SELL, price: e.Price, amount: e.Amount}
+	case msg.BOOK_EXECUTE:
+		o := b[e.TradeId]
+		o.amount -= e.Amount
+		if o.amount == 0 {
+			delete(b, e.TradeId)
+		}
+	case msg.BOOK_DELETE:
+		delete(b, e.TradeId)
+	default:
+		t.Errorf("Unexpected book event %v", e)
+	}
+}
+
+func (b shadowBook) survey(kind msg.MsgKind) []msg.SurveyLimit {
+	limits := make(map[uint64]*msg.SurveyLimit)
+	for _, o := range b {
+		if o.kind != kind {
+			continue
+		}
+		l := limits[o.price]
+		if l == nil {
+			l = &msg.SurveyLimit{Price: o.price}
+			limits[o.price] = l
+		}
+		l.Size += o.amount
+		l.Orders++
+	}
+	survey := make([]msg.SurveyLimit, 0, len(limits))
+	for _, l := range limits {
+		survey = append(survey, *l)
+	}
+	sort.Slice(survey, func(i, j int) bool {
+		if kind == msg.BUY {
+			return survey[i].Price > survey[j].Price
+		}
+		return survey[i].Price < survey[j].Price
+	})
+	return survey
+}
+
+// Collects every message written to it
+type sliceWriter struct {
+	ms []msg.Message
+}
+
+func (w *sliceWriter) Write(m msg.Message) {
+	w.ms = append(w.ms, m)
+}
+
+func TestOrderFeedRebuildsBook(t *testing.T) {
+	testSet, err := feedMaker.RndTradeSet(1000, 100, 1, 200)
+	if err != nil {
+		panic(err.Error())
+	}
+	feed := &sliceWriter{}
+	m := NewMatcher(1000)
+	m.Config("Feed Matcher", coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+	m.SetOrderFeed(feed)
+	book := make(shadowBook)
+	seq := uint32(0)
+	for i := range testSet {
+		m.Submit(&testSet[i])
+		for _, e := range feed.ms {
+			seq++
+			if e.TraderId != seq {
+				t.Errorf("Expecting sequence number %d, found %d", seq, e.TraderId)
+				return
+			}
+			book.apply(t, &e)
+		}
+		feed.ms = feed.ms[:0]
+		buys, sells := m.Survey(testSet[i].StockId, 1000)
+		expectSurvey(t, book.survey(msg.BUY), buys)
+		expectSurvey(t, book.survey(msg.SELL), sells)
+		if t.Failed() {
+			return
+		}
+	}
+}
+
+func expectSurvey(t *testing.T, expected, found []msg.SurveyLimit) {
+	if len(expected) != len(found) {
+		t.Errorf("Expecting %d limits, found %d", len(expected), len(found))
+		return
+	}
+	for i := range expected {
+		if expected[i] != found[i] {
+			t.Errorf("Expecting limit %v, found %v", expected[i], found[i])
+		}
+	}
+}
@@ -2,6 +2,7 @@ package matcher
 
 import (
 	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher/pqueue"
 	"github.com/fmstephe/matching_engine/msg"
 	"runtime"
 	"testing"
@@ -51,12 +52,16 @@ func testPrice(t *testing.T, bPrice, sPrice, expected uint64) {
 }
 
 type testerMaker struct {
+	mkBook BookMaker
 }
 
 func (tm *testerMaker) Make() MatchTester {
 	in := coordinator.NewChanReaderWriter(30)
 	out := coordinator.NewChanReaderWriter(30)
 	m := NewMatcher(100)
+	if tm.mkBook != nil {
+		m.SetBookMaker(tm.mkBook)
+	}
 	m.Config("Matcher", in, out)
 	go m.Run()
 	return &localTester{in: in, out: out}
@@ -85,3 +90,90 @@ func (lt *localTester) Cleanup(t *testing.T) {}
 func TestRunTestSuite(t *testing.T) {
 	RunTestSuite(t, &testerMaker{})
 }
+
+func TestRunTestSuiteAllBooks(t *testing.T) {
+	// The suite's sells are priced up to 10,010
+	for name, mkBook := range bookMakers(1, 20000) {
+		t.Run(name, func(t *testing.T) {
+			RunTestSuite(t, &testerMaker{mkBook: mkBook})
+		})
+	}
+}
+
+func TestOutOfBandRejected(t *testing.T) {
+	in := coordinator.NewChanReaderWriter(30)
+	out := coordinator.NewChanReaderWriter(30)
+	m := NewMatcher(100)
+	// Only stock 1 has a price band
+	m.SetBookMaker(func(stockId uint64) pqueue.OrderBook {
+		if stockId == 1 {
+			return pqueue.NewLadderMatchQueues(10, 20)
+		}
+		return &pqueue.MatchQueues{}
+	})
+	m.Config("Matcher", in, out)
+	go m.Run()
+	lt := &localTester{in: in, out: out}
+	b := &msg.Message{Kind: msg.BUY, TraderId: trader1, TradeId: 1, StockId: 1, Price: 21, Amount: 1}
+	lt.Send(t, b)
+	lt.Expect(t, &msg.Message{Kind: msg.REJECTED, TraderId: trader1, TradeId: 1, StockId: 1, Price: 21, Amount: 1})
+	// The rejected buy doesn't rest, so this sell can't be matched
+	s := &msg.Message{Kind: msg.SELL, TraderId: trader2, TradeId: 1, StockId: 1, Price: 20, Amount: 1}
+	lt.Send(t, s)
+	lt.Send(t, &msg.Message{Kind: msg.CANCEL, TraderId: trader2, TradeId: 1, StockId: 1, Price: 20, Amount: 1})
+	lt.Expect(t, &msg.Message{Kind: msg.CANCELLED, TraderId: trader2, TradeId: 1, StockId: 1, Price: 20, Amount: 1})
+	// Other stocks accept any price
+	lt.Send(t, &msg.Message{Kind: msg.BUY, TraderId: trader1, TradeId: 2, StockId: 2, Price: 21, Amount: 1})
+	lt.Send(t, &msg.Message{Kind: msg.SELL, TraderId: trader2, TradeId: 2, StockId: 2, Price: 21, Amount: 1})
+	lt.Expect(t, &msg.Message{Kind: msg.FULL, TraderId: trader1, TradeId: 2, StockId: 2, Price: 21, Amount: 1})
+	lt.Expect(t, &msg.Message{Kind: msg.FULL, TraderId: trader2, TradeId: 2, StockId: 2, Price: 21, Amount: 1})
+}
+
+// Orders are matched before the band is checked, only an out of band remainder is rejected
+func TestOutOfBandMatched(t *testing.T) {
+	in := coordinator.NewChanReaderWriter(30)
+	out := coordinator.NewChanReaderWriter(30)
+	m := NewMatcher(100)
+	m.SetBookMaker(func(stockId uint64) pqueue.OrderBook {
+		return pqueue.NewLadderMatchQueues(10, 20)
+	})
+	m.Config("Matcher", in, out)
+	go m.Run()
+	lt := &localTester{in: in, out: out}
+	// A market sell trades against a resting buy, and its remainder is rejected
+	lt.Send(t, &msg.Message{Kind: msg.BUY, TraderId: trader1, TradeId: 1, StockId: 1, Price: 15, Amount: 1})
+	lt.Send(t, &msg.Message{Kind: msg.SELL, TraderId: trader2, TradeId: 1, StockId: 1, Price: msg.MARKET_PRICE, Amount: 2})
+	lt.Expect(t, &msg.Message{Kind: msg.FULL, TraderId: trader1, TradeId: 1, StockId: 1, Price: 15, Amount: 1})
+	lt.Expect(t, &msg.Message{Kind: msg.PARTIAL, TraderId: trader2, TradeId: 1, StockId: 1, Price: 15, Amount: 1})
+	lt.Expect(t, &msg.Message{Kind: msg.REJECTED, TraderId: trader2, TradeId: 1, StockId: 1, Price: msg.MARKET_PRICE, Amount: 1})
+	// A buy above the band trades against a resting sell
+	lt.Send(t, &msg.Message{Kind: msg.SELL, TraderId: trader2, TradeId: 2, StockId: 1, Price: 15, Amount: 1})
+	lt.Send(t, &msg.Message{Kind: msg.BUY, TraderId: trader1, TradeId: 2, StockId: 1, Price: 25, Amount: 1})
+	lt.Expect(t, &msg.Message{Kind: msg.FULL, TraderId: trader1, TradeId: 2, StockId: 1, Price: 20, Amount: 1})
+	lt.Expect(t, &msg.Message{Kind: msg.FULL, TraderId: trader2, TradeId: 2, StockId: 1, Price: 20, Amount: 1})
+	// Its remainder would rest above the band, so is rejected
+	lt.Send(t, &msg.Message{Kind: msg.SELL, TraderId: trader2, TradeId: 3, StockId: 1, Price: 15, Amount: 1})
+	lt.Send(t, &msg.Message{Kind: msg.BUY, TraderId: trader1, TradeId: 3, StockId: 1, Price: 25, Amount: 3})
+	lt.Expect(t, &msg.Message{Kind: msg.PARTIAL, TraderId: trader1, TradeId: 3, StockId: 1, Price: 20, Amount: 1})
+	lt.Expect(t, &msg.Message{Kind: msg.FULL, TraderId: trader2, TradeId: 3, StockId: 1, Price: 20, Amount: 1})
+	lt.Expect(t, &msg.Message{Kind: msg.REJECTED, TraderId: trader1, TradeId: 3, StockId: 1, Price: 25, Amount: 2})
+	// Nothing was left resting
+	lt.Send(t, &msg.Message{Kind: msg.CANCEL, TraderId: trader1, TradeId: 3, StockId: 1, Price: 25, Amount: 2})
+	lt.Expect(t, &msg.Message{Kind: msg.NOT_CANCELLED, TraderId: trader1, TradeId: 3, StockId: 1, Price: 25, Amount: 2})
+}
+
+// NEW_TRADER used to panic, it is now accepted and ignored
+func TestNewTraderIgnored(t *testing.T) {
+	m := NewMatcher(100)
+	out := &sliceWriter{}
+	m.Config("Matcher", coordinator.NewNoopReaderWriter(), out)
+	nt := &msg.Message{}
+	nt.WriteNewTrader(trader1)
+	m.Submit(nt)
+	if len(out.ms) != 0 {
+		t.Errorf("Expecting no outputs, found %v", out.ms)
+	}
+	if stats := m.slab.Stats(); stats.Live != 0 {
+		t.Errorf("Expecting no live nodes, found %d", stats.Live)
+	}
+}
@@ -0,0 +1,195 @@
+package matcher
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/msg"
+	"path/filepath"
+	"testing"
+)
+
+var snapshotMaker = msg.NewMessageMaker(1)
+
+func mkSnapshotMatcher() (*M, *sliceWriter, *sliceWriter) {
+	out := &sliceWriter{}
+	feed := &sliceWriter{}
+	m := NewMatcher(100)
+	m.Config("Snapshot Matcher", coordinator.NewNoopReaderWriter(), out)
+	m.SetOrderFeed(feed)
+	return m, out, feed
+}
+
+func TestSnapshotRestoreContinuesIdentically(t *testing.T) {
+	testSet, err := snapshotMaker.RndTradeSet(1000, 100, 1, 50)
+	if err != nil {
+		panic(err.Error())
+	}
+	half := len(testSet) / 2
+	orig, origOut, origFeed := mkSnapshotMatcher()
+	for i := 0; i < half; i++ {
+		orig.Submit(&testSet[i])
+	}
+	b := &bytes.Buffer{}
+	if err := orig.Snapshot(b); err != nil {
+		t.Fatalf("Unexpected snapshot error %s", err.Error())
+	}
+	snapshot := b.Bytes()
+	restored, restoredOut, restoredFeed := mkSnapshotMatcher()
+	if err := restored.Restore(bytes.NewReader(snapshot)); err != nil {
+		t.Fatalf("Unexpected restore error %s", err.Error())
+	}
+	// A snapshot of the restored matcher is identical
+	rb := &bytes.Buffer{}
+	restored.Snapshot(rb)
+	if !bytes.Equal(snapshot, rb.Bytes()) {
+		t.Errorf("Snapshot of restored matcher differs from original snapshot")
+	}
+	origOut.ms, origFeed.ms = nil, nil
+	for i := half; i < len(testSet); i++ {
+		orig.Submit(&testSet[i])
+		restored.Submit(&testSet[i])
+	}
+	expectSame(t, origOut.ms, restoredOut.ms)
+	expectSame(t, origFeed.ms, restoredFeed.ms)
+}
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "matcher.snap")
+	orig, _, _ := mkSnapshotMatcher()
+	orig.Submit(&msg.Message{Kind: msg.BUY, Price: 5, Amount: 2, TraderId: trader1, TradeId: 1, StockId: stockId})
+	orig.Submit(&msg.Message{Kind: msg.SELL, Price: 9, Amount: 3, TraderId: trader2, TradeId: 1, StockId: stockId + 1})
+	if err := orig.SnapshotFile(path); err != nil {
+		t.Fatalf("Unexpected snapshot error %s", err.Error())
+	}
+	restored, _, _ := mkSnapshotMatcher()
+	if err := restored.RestoreFile(path); err != nil {
+		t.Fatalf("Unexpected restore error %s", err.Error())
+	}
+	buys, _ := restored.Survey(stockId, 10)
+	_, sells := restored.Survey(stockId+1, 10)
+	if len(buys) != 1 || buys[0] != (msg.SurveyLimit{Price: 5, Size: 2, Orders: 1}) {
+		t.Errorf("Unexpected restored buys %v", buys)
+	}
+	if len(sells) != 1 || sells[0] != (msg.SurveyLimit{Price: 9, Size: 3, Orders: 1}) {
+		t.Errorf("Unexpected restored sells %v", sells)
+	}
+}
+
+func TestRestoreCorruptSnapshot(t *testing.T) {
+	orig, _, _ := mkSnapshotMatcher()
+	orig.Submit(&msg.Message{Kind: msg.BUY, Price: 5, Amount: 2, TraderId: trader1, TradeId: 1, StockId: stockId})
+	b := &bytes.Buffer{}
+	orig.Snapshot(b)
+	snapshot := b.Bytes()
+	// Truncated
+	m, _, _ := mkSnapshotMatcher()
+	if err := m.Restore(bytes.NewReader(snapshot[:len(snapshot)-1])); err == nil {
+		t.Errorf("Expected error restoring truncated snapshot")
+	}
+	// Flipped bit
+	corrupt := append([]byte{}, snapshot...)
+	corrupt[len(corrupt)-10] ^= 1
+	if err := m.Restore(bytes.NewReader(corrupt)); err == nil {
+		t.Errorf("Expected error restoring corrupt snapshot")
+	}
+	// Wrong version
+	corrupt = append([]byte{}, snapshot...)
+	corrupt[len(snapshotMagic)]++
+	if err := m.Restore(bytes.NewReader(corrupt)); err == nil {
+		t.Errorf("Expected error restoring unsupported snapshot version")
+	}
+	buys, _ := m.Survey(stockId, 10)
+	if len(buys) != 0 {
+		t.Errorf("Failed restore modified the matcher %v", buys)
+	}
+}
+
+// Snapshots don't depend on the type of book holding the orders
+func TestSnapshotAcrossBooks(t *testing.T) {
+	testSet, err := snapshotMaker.RndTradeSet(1000, 100, 1, 50)
+	if err != nil {
+		panic(err.Error())
+	}
+	orig, _, _ := mkSnapshotMatcher()
+	for i := 0; i < len(testSet)/2; i++ {
+		orig.Submit(&testSet[i])
+	}
+	b := &bytes.Buffer{}
+	orig.Snapshot(b)
+	for name, mkBook := range bookMakers(1, 50) {
+		restored, _, _ := mkSnapshotMatcher()
+		restored.SetBookMaker(mkBook)
+		if err := restored.Restore(bytes.NewReader(b.Bytes())); err != nil {
+			t.Fatalf("Unexpected restore error into %s %s", name, err.Error())
+		}
+		rb := &bytes.Buffer{}
+		restored.Snapshot(rb)
+		if !bytes.Equal(b.Bytes(), rb.Bytes()) {
+			t.Errorf("Snapshot of matcher restored into %s differs from original snapshot", name)
+		}
+	}
+	// Orders outside of a book's band can't be restored
+	restored, _, _ := mkSnapshotMatcher()
+	restored.SetBookMaker(bookMakers(10, 20)["LadderMatchQueues"])
+	if err := restored.Restore(bytes.NewReader(b.Bytes())); err == nil {
+		t.Errorf("Expected error restoring orders outside of band")
+	}
+}
+
+func expectSame(t *testing.T, expected, found []msg.Message) {
+	if len(expected) != len(found) {
+		t.Errorf("Expecting %d messages, found %d", len(expected), len(found))
+		return
+	}
+	for i := range expected {
+		if expected[i] != found[i] {
+			t.Errorf("Expecting %v, found %v", &expected[i], &found[i])
+			return
+		}
+	}
+}
+
+// Orders replaced by a restore, or read by a failed restore, are returned to the slab
+func TestRestoreFreesOrders(t *testing.T) {
+	orig, _, _ := mkSnapshotMatcher()
+	orig.Submit(&msg.Message{Kind: msg.BUY, Price: 5, Amount: 2, TraderId: trader1, TradeId: 1, StockId: stockId})
+	orig.Submit(&msg.Message{Kind: msg.SELL, Price: 9, Amount: 3, TraderId: trader2, TradeId: 1, StockId: stockId})
+	b := &bytes.Buffer{}
+	orig.Snapshot(b)
+	snapshot := b.Bytes()
+	m, _, _ := mkSnapshotMatcher()
+	for i := uint32(1); i <= 5; i++ {
+		m.Submit(&msg.Message{Kind: msg.BUY, Price: 5, Amount: 1, TraderId: trader3, TradeId: i, StockId: stockId + 1})
+	}
+	if err := m.Restore(bytes.NewReader(snapshot)); err != nil {
+		t.Fatalf("Unexpected restore error %s", err.Error())
+	}
+	if live := m.SlabStats().Live; live != 2 {
+		t.Errorf("Expecting 2 live orders, found %d", live)
+	}
+	// Fails on the checksum, after every order has been read
+	corrupt := append([]byte{}, snapshot...)
+	corrupt[len(corrupt)-1] ^= 1
+	if err := m.Restore(bytes.NewReader(corrupt)); err == nil {
+		t.Errorf("Expected error restoring corrupt snapshot")
+	}
+	if live := m.SlabStats().Live; live != 2 {
+		t.Errorf("Expecting 2 live orders, found %d", live)
+	}
+}
+
+// A restore reads no further than the end of the snapshot
+func TestRestoreLeavesTrailingBytes(t *testing.T) {
+	orig, _, _ := mkSnapshotMatcher()
+	orig.Submit(&msg.Message{Kind: msg.BUY, Price: 5, Amount: 2, TraderId: trader1, TradeId: 1, StockId: stockId})
+	b := &bytes.Buffer{}
+	orig.Snapshot(b)
+	b.WriteString("trailing")
+	m, _, _ := mkSnapshotMatcher()
+	if err := m.Restore(b); err != nil {
+		t.Fatalf("Unexpected restore error %s", err.Error())
+	}
+	if b.String() != "trailing" {
+		t.Errorf("Expecting trailing bytes to be unread, found %q", b.String())
+	}
+}
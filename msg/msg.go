@@ -20,6 +20,12 @@ const (
 	REJECTED      = MsgKind(iota)
 	SHUTDOWN      = MsgKind(iota)
 	NEW_TRADER    = MsgKind(iota)
+	BUY_DEPTH     = MsgKind(iota)
+	SELL_DEPTH    = MsgKind(iota)
+	BOOK_ADD_BUY  = MsgKind(iota)
+	BOOK_ADD_SELL = MsgKind(iota)
+	BOOK_EXECUTE  = MsgKind(iota)
+	BOOK_DELETE   = MsgKind(iota)
 	NUM_OF_KIND   = int(iota)
 )
 
@@ -47,6 +53,18 @@ func (k MsgKind) String() string {
 		return "SHUTDOWN"
 	case NEW_TRADER:
 		return "NEW_TRADER"
+	case BUY_DEPTH:
+		return "BUY_DEPTH"
+	case SELL_DEPTH:
+		return "SELL_DEPTH"
+	case BOOK_ADD_BUY:
+		return "BOOK_ADD_BUY"
+	case BOOK_ADD_SELL:
+		return "BOOK_ADD_SELL"
+	case BOOK_EXECUTE:
+		return "BOOK_EXECUTE"
+	case BOOK_DELETE:
+		return "BOOK_DELETE"
 	}
 	panic("Uncreachable")
 }
@@ -66,6 +84,42 @@ type Message struct {
 	TradeId  uint32  `json:"tradeId"`
 }
 
+// The aggregate of all orders resting at a single price
+type SurveyLimit struct {
+	Price  uint64 `json:"price"`
+	Size   uint64 `json:"size"`
+	Orders uint32 `json:"orders"`
+}
+
+// Writes a BUY_DEPTH or SELL_DEPTH message describing l.
+// A depth message carries the total size of the limit in Amount and the number
+// of orders resting there in TradeId. A limit with no orders has an Amount of 0.
+func (m *Message) WriteDepth(kind MsgKind, stockId uint64, l *SurveyLimit) {
+	*m = Message{}
+	m.Kind = kind
+	m.Price = l.Price
+	m.Amount = l.Size
+	m.StockId = stockId
+	m.TradeId = l.Orders
+}
+
+// Writes an anonymised order book event. Book events carry the event's
+// sequence number in TraderId and the order reference in TradeId.
+//
+// BOOK_ADD_BUY and BOOK_ADD_SELL carry the price and amount of a newly resting order.
+// BOOK_EXECUTE carries the trade price and the amount executed, an order
+// executed down to zero has left the book.
+// BOOK_DELETE carries the price and remaining amount of a cancelled order.
+func (m *Message) WriteBookEvent(kind MsgKind, seq, ref uint32, stockId, price, amount uint64) {
+	*m = Message{}
+	m.Kind = kind
+	m.Price = price
+	m.Amount = amount
+	m.StockId = stockId
+	m.TraderId = seq
+	m.TradeId = ref
+}
+
 const (
 	SizeofMessage = int(unsafe.Sizeof(Message{}))
 )
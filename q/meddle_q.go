@@ -2,7 +2,9 @@ package q
 
 import (
 	"container/list"
+	"io"
 	"runtime"
+	"sync"
 )
 
 type Meddler interface {
@@ -18,6 +20,7 @@ type meddleQ struct {
 	writeChan chan []byte
 	readChan  chan []byte
 	shutdown  chan bool
+	closeOnce sync.Once
 	buf       *list.List
 	meddler   Meddler
 }
@@ -45,8 +48,14 @@ func NewSimpleQ(name string) *meddleQ {
 	return q
 }
 
+// Once the queue is closed Read returns io.EOF, including a Read already blocked
 func (q *meddleQ) Read(p []byte) (int, error) {
-	c := <-q.readChan
+	var c []byte
+	select {
+	case c = <-q.readChan:
+	case <-q.shutdown:
+		return 0, io.EOF
+	}
 	copy(p, c)
 	if len(p) < len(c) {
 		return len(p), nil
@@ -55,14 +64,18 @@ func (q *meddleQ) Read(p []byte) (int, error) {
 }
 
 func (q *meddleQ) Close() error {
-	q.shutdown <- true
+	q.closeOnce.Do(func() { close(q.shutdown) })
 	return nil
 }
 
 func (q *meddleQ) Write(p []byte) (int, error) {
 	c := make([]byte, len(p))
 	copy(c, p)
-	q.writeChan <- c
+	select {
+	case q.writeChan <- c:
+	case <-q.shutdown:
+		return 0, io.ErrClosedPipe
+	}
 	return len(c), nil
 }
 
@@ -81,8 +94,11 @@ func (q *meddleQ) run() {
 
 func (q *meddleQ) read() {
 	if q.buf.Len() == 0 {
-		r := <-q.writeChan
-		q.buf.PushBack(r)
+		select {
+		case r := <-q.writeChan:
+			q.buf.PushBack(r)
+		case <-q.shutdown:
+		}
 	} else {
 		select {
 		case r := <-q.writeChan:
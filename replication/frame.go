@@ -0,0 +1,52 @@
+package replication
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/fmstephe/matching_engine/msg"
+)
+
+// Replication frames are written to a message oriented transport, one frame per Write.
+//
+//	data: 'D', sequence number (8 bytes), marshalled msg.Message (msg.ByteSize bytes)
+//	ack:  'A', sequence number of the last message applied (8 bytes)
+const (
+	dataFrame     = 'D'
+	ackFrame      = 'A'
+	seqOffset     = 1
+	msgOffset     = 9
+	ackFrameSize  = msgOffset
+	dataFrameSize = msgOffset + msg.ByteSize
+)
+
+var binCoder = binary.LittleEndian
+
+func writeData(b []byte, seq uint64, m *msg.Message) []byte {
+	b = b[:dataFrameSize]
+	b[0] = dataFrame
+	binCoder.PutUint64(b[seqOffset:msgOffset], seq)
+	m.Marshal(b[msgOffset:])
+	return b
+}
+
+func writeAck(b []byte, seq uint64) []byte {
+	b = b[:ackFrameSize]
+	b[0] = ackFrame
+	binCoder.PutUint64(b[seqOffset:msgOffset], seq)
+	return b
+}
+
+func readData(b []byte, m *msg.Message) (uint64, error) {
+	if len(b) != dataFrameSize || b[0] != dataFrame {
+		return 0, errors.New(fmt.Sprintf("Malformed data frame %v", b))
+	}
+	return binCoder.Uint64(b[seqOffset:msgOffset]), m.Unmarshal(b[msgOffset:])
+}
+
+func readAck(b []byte) (uint64, error) {
+	if len(b) != ackFrameSize || b[0] != ackFrame {
+		return 0, errors.New(fmt.Sprintf("Malformed ack frame %v", b))
+	}
+	return binCoder.Uint64(b[seqOffset:msgOffset]), nil
+}
@@ -0,0 +1,157 @@
+package replication
+
+import (
+	"fmt"
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/msg"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// The number of times a frame is resent, by default, before the replica is given up on
+const DefaultMaxResends = 100
+
+// A MsgReader which sequences every message and replicates it before passing it on.
+// Placed between the primary's matcher and its input, the matcher only
+// processes a message once the replica has acknowledged applying it. So
+// every message whose outputs the primary has published is already in the
+// replica's books.
+//
+// Frames are resent until acknowledged, so the transport may drop frames.
+// A replica which has not acknowledged a frame after it has been resent
+// maxResends times, or which can no longer be written to or read from, is
+// given up on. The primary then carries on standalone, so that losing the
+// replica never stops the primary.
+type Primary struct {
+	in         coordinator.MsgReader
+	toRep      io.Writer
+	timeout    time.Duration
+	maxResends int
+	standalone int32
+	seq        uint64
+	acks       chan uint64
+	acksDone   chan bool
+	acked      uint64
+	b          []byte
+	timer      *time.Timer
+}
+
+// Creates a Primary replicating messages read from in. Frames are written to
+// toReplica and acknowledgements read from fromReplica. Unacknowledged frames
+// are resent every timeout.
+func NewPrimary(in coordinator.MsgReader, toReplica io.Writer, fromReplica io.Reader, timeout time.Duration) *Primary {
+	p := &Primary{
+		in:         in,
+		toRep:      toReplica,
+		timeout:    timeout,
+		maxResends: DefaultMaxResends,
+		acks:       make(chan uint64, 64),
+		acksDone:   make(chan bool),
+		b:          make([]byte, dataFrameSize),
+		timer:      time.NewTimer(timeout),
+	}
+	go p.readAcks(fromReplica)
+	return p
+}
+
+func (p *Primary) Read() msg.Message {
+	m := p.in.Read()
+	p.seq++
+	p.replicate(p.seq, &m)
+	return m
+}
+
+// The sequence number of the last message replicated
+func (p *Primary) Seq() uint64 {
+	return p.seq
+}
+
+// Sets the number of times an unacknowledged frame is resent before the replica is given up on
+func (p *Primary) SetMaxResends(n int) {
+	p.maxResends = n
+}
+
+// Indicates whether the replica has been given up on, messages are no longer replicated
+func (p *Primary) Standalone() bool {
+	return atomic.LoadInt32(&p.standalone) == 1
+}
+
+func (p *Primary) replicate(seq uint64, m *msg.Message) {
+	if p.Standalone() {
+		return
+	}
+	f := writeData(p.b, seq, m)
+	for resends := 0; ; resends++ {
+		if resends > p.maxResends {
+			p.fallBack(fmt.Sprintf("message %d not acknowledged after %d resends", seq, p.maxResends))
+			return
+		}
+		if _, err := p.toRep.Write(f); err != nil {
+			p.fallBack(err.Error())
+			return
+		}
+		acked, alive := p.awaitAck(seq)
+		if acked {
+			return
+		}
+		if !alive {
+			p.fallBack("acknowledgements from the replica have stopped")
+			return
+		}
+	}
+}
+
+func (p *Primary) fallBack(reason string) {
+	atomic.StoreInt32(&p.standalone, 1)
+	println("Primary: " + reason + ", continuing without the replica")
+}
+
+// Returns true if seq is acknowledged before the timeout expires, and false
+// for alive if no more acknowledgements can arrive
+func (p *Primary) awaitAck(seq uint64) (acked, alive bool) {
+	if !p.timer.Stop() {
+		select {
+		case <-p.timer.C:
+		default:
+		}
+	}
+	p.timer.Reset(p.timeout)
+	for p.acked < seq {
+		select {
+		case ack := <-p.acks:
+			p.ack(ack)
+		case <-p.acksDone:
+			// Acknowledgements read before the reader stopped still count
+			for len(p.acks) > 0 {
+				p.ack(<-p.acks)
+			}
+			return p.acked >= seq, false
+		case <-p.timer.C:
+			return false, true
+		}
+	}
+	return true, true
+}
+
+func (p *Primary) ack(ack uint64) {
+	if ack > p.acked {
+		p.acked = ack
+	}
+}
+
+func (p *Primary) readAcks(fromReplica io.Reader) {
+	defer close(p.acksDone)
+	b := make([]byte, ackFrameSize)
+	for {
+		n, err := fromReplica.Read(b)
+		if err != nil {
+			return
+		}
+		ack, err := readAck(b[:n])
+		if err != nil {
+			continue // A frame damaged in transit is treated as dropped
+		}
+		p.acks <- ack
+	}
+}
@@ -0,0 +1,129 @@
+package replication
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/msg"
+	"io"
+	"sync"
+)
+
+// Applies the messages replicated by a Primary to its own matcher, in lockstep
+// with the primary. The replica's outputs are discarded until it is promoted.
+type Replica struct {
+	m          *matcher.M
+	fromPrim   io.ReadCloser
+	toPrim     io.Writer
+	frames     chan []byte
+	stop       chan bool
+	stopped    chan bool
+	readerDone chan bool
+	lock       sync.Mutex
+	running    bool
+	promoted   bool
+	applied    uint64
+	shutdown   bool
+}
+
+// Creates a Replica applying frames read from fromPrimary to m.
+// Acknowledgements are written to toPrimary. fromPrimary is closed on promotion.
+func NewReplica(m *matcher.M, fromPrimary io.ReadCloser, toPrimary io.Writer) *Replica {
+	r := &Replica{
+		m:          m,
+		fromPrim:   fromPrimary,
+		toPrim:     toPrimary,
+		frames:     make(chan []byte, 64),
+		stop:       make(chan bool),
+		stopped:    make(chan bool),
+		readerDone: make(chan bool),
+	}
+	m.Config("Replica", coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+	go r.readFrames()
+	return r
+}
+
+// Applies replicated messages until the replica is promoted.
+// Once a SHUTDOWN is replicated no further messages are applied, but resent
+// frames are still acknowledged, as the primary may not have seen the last ack.
+// Run returns immediately if the replica is already running or has been promoted.
+func (r *Replica) Run() {
+	r.lock.Lock()
+	if r.running || r.promoted {
+		r.lock.Unlock()
+		return
+	}
+	r.running = true
+	r.lock.Unlock()
+	defer close(r.stopped)
+	ack := make([]byte, ackFrameSize)
+	m := &msg.Message{}
+	for {
+		select {
+		case <-r.stop:
+			return
+		case f := <-r.frames:
+			seq, err := readData(f, m)
+			if err != nil {
+				continue // A frame damaged in transit is treated as dropped
+			}
+			if seq == r.applied+1 && !r.shutdown {
+				if m.Kind == msg.SHUTDOWN {
+					r.shutdown = true
+				} else {
+					r.m.Submit(m)
+				}
+				r.applied = seq
+			}
+			// Duplicates, and frames arriving out of order, are answered with the last applied message
+			if _, err := r.toPrim.Write(writeAck(ack, r.applied)); err != nil {
+				panic(err.Error())
+			}
+		}
+	}
+}
+
+// The sequence number of the last message applied
+func (r *Replica) Applied() uint64 {
+	return r.applied
+}
+
+// Stops replication and hands the matcher over to in and out. The returned
+// matcher holds every message the primary processed, and is ready to Run.
+// Promote must only be called after the primary is known to be dead. It may
+// be called whether or not Run has been started. Promoting again does nothing,
+// the matcher keeps the in and out it was first promoted with.
+func (r *Replica) Promote(in coordinator.MsgReader, out coordinator.MsgWriter) *matcher.M {
+	r.lock.Lock()
+	if r.promoted {
+		r.lock.Unlock()
+		return r.m
+	}
+	r.promoted = true
+	running := r.running
+	r.lock.Unlock()
+	close(r.stop)
+	if running {
+		<-r.stopped
+	}
+	// Closing fromPrimary releases the frame reader from a blocked Read
+	r.fromPrim.Close()
+	<-r.readerDone
+	r.m.Config("Promoted Replica", in, out)
+	return r.m
+}
+
+func (r *Replica) readFrames() {
+	defer close(r.readerDone)
+	for {
+		b := make([]byte, dataFrameSize)
+		n, err := r.fromPrim.Read(b)
+		if err != nil {
+			return
+		}
+		select {
+		case r.frames <- b[:n]:
+		case <-r.stop:
+			return
+		}
+	}
+}
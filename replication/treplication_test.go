@@ -0,0 +1,236 @@
+package replication
+
+import (
+	"bytes"
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/msg"
+	"github.com/fmstephe/matching_engine/q"
+	"io"
+	"testing"
+	"time"
+)
+
+var replicationMaker = msg.NewMessageMaker(1)
+
+// Collects every message written to it
+type sliceWriter struct {
+	ms []msg.Message
+}
+
+func (w *sliceWriter) Write(m msg.Message) {
+	w.ms = append(w.ms, m)
+}
+
+func testMsgs(t *testing.T) []msg.Message {
+	ms, err := replicationMaker.RndTradeSet(300, 30, 1, 30)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return ms
+}
+
+type pair struct {
+	primary *matcher.M
+	replica *Replica
+	repM    *matcher.M
+}
+
+// Runs a primary over ms, replicating to a replica over lossy queues
+func runPair(ms []msg.Message, dropProb float64) *pair {
+	toReplica := q.NewMeddleQ("toReplica", q.NewProbDropMeddler(dropProb))
+	toPrimary := q.NewMeddleQ("toPrimary", q.NewProbDropMeddler(dropProb))
+	repM := matcher.NewMatcher(100)
+	replica := NewReplica(repM, toReplica, toPrimary)
+	go replica.Run()
+	primary := matcher.NewMatcher(100)
+	in := NewPrimary(coordinator.NewPreloadedReaderWriter(ms), toReplica, toPrimary, time.Millisecond)
+	primary.Config("Primary", in, coordinator.NewShutdownReaderWriter())
+	primary.Run()
+	return &pair{primary: primary, replica: replica, repM: repM}
+}
+
+func TestReplicaInLockstep(t *testing.T) {
+	for _, dropProb := range []float64{0.0, 0.1, 0.3} {
+		p := runPair(testMsgs(t), dropProb)
+		// The primary has processed the SHUTDOWN, so the replica has applied it
+		p.replica.Promote(coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+		expectSameState(t, p.primary, p.repM)
+	}
+}
+
+func TestPromotion(t *testing.T) {
+	ms := testMsgs(t)
+	half := len(ms) / 2
+	// A reference matcher which sees every message
+	ref := matcher.NewMatcher(100)
+	refOut := &sliceWriter{}
+	ref.Config("Reference", coordinator.NewNoopReaderWriter(), refOut)
+	for i := 0; i < half; i++ {
+		ref.Submit(&ms[i])
+	}
+	refOut.ms = nil
+	for i := half; i < len(ms); i++ {
+		ref.Submit(&ms[i])
+	}
+	// The primary dies after processing the first half of the messages
+	toReplica := q.NewMeddleQ("toReplica", q.NewProbDropMeddler(0.2))
+	toPrimary := q.NewMeddleQ("toPrimary", q.NewProbDropMeddler(0.2))
+	repM := matcher.NewMatcher(100)
+	replica := NewReplica(repM, toReplica, toPrimary)
+	go replica.Run()
+	primary := matcher.NewMatcher(100)
+	in := NewPrimary(coordinator.NewPreloadedReaderWriter(ms[:half]), toReplica, toPrimary, time.Millisecond)
+	primary.Config("Primary", in, coordinator.NewNoopReaderWriter())
+	for i := 0; i < half; i++ {
+		m := in.Read()
+		primary.Submit(&m)
+	}
+	// Promote the replica and send it the remaining messages
+	promotedIn := coordinator.NewChanReaderWriter(len(ms))
+	promotedOut := &sliceWriter{}
+	promoted := replica.Promote(promotedIn, promotedOut)
+	if replica.Applied() != uint64(half) {
+		t.Errorf("Expecting replica to have applied %d messages, found %d", half, replica.Applied())
+	}
+	expectSameState(t, primary, promoted)
+	for i := half; i < len(ms); i++ {
+		promotedIn.Write(ms[i])
+	}
+	promotedIn.Write(msg.Message{Kind: msg.SHUTDOWN})
+	promoted.Run()
+	expectSameMsgs(t, append(refOut.ms, msg.Message{Kind: msg.SHUTDOWN}), promotedOut.ms)
+}
+
+// A reader whose stream has failed
+type failedReader struct{}
+
+func (r failedReader) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+// Losing the replica part way through never stops the primary, it carries on standalone
+func TestPrimaryOutlivesReplica(t *testing.T) {
+	ms := testMsgs(t)
+	ref := matcher.NewMatcher(100)
+	refOut := &sliceWriter{}
+	ref.Config("Reference", coordinator.NewNoopReaderWriter(), refOut)
+	for i := range ms {
+		ref.Submit(&ms[i])
+	}
+	half := len(ms) / 2
+	for _, name := range []string{"closed", "silent", "failed"} {
+		toReplica := q.NewMeddleQ("toReplica", q.NewProbDropMeddler(0.1))
+		toPrimary := q.NewMeddleQ("toPrimary", q.NewProbDropMeddler(0.1))
+		replica := NewReplica(matcher.NewMatcher(100), toReplica, toPrimary)
+		go replica.Run()
+		var fromReplica io.Reader = toPrimary
+		switch name {
+		case "closed":
+			// The replica is promoted part way through, closing the primary's stream to it
+		case "silent":
+			// Acknowledgements stop arriving, without the stream failing
+			fromReplica = &silentReader{closed: make(chan bool)}
+		case "failed":
+			fromReplica = failedReader{}
+		}
+		in := NewPrimary(coordinator.NewPreloadedReaderWriter(ms), toReplica, fromReplica, time.Millisecond)
+		in.SetMaxResends(5)
+		primary := matcher.NewMatcher(100)
+		out := &sliceWriter{}
+		primary.Config("Primary", in, out)
+		for i := range ms {
+			if i == half && name == "closed" {
+				replica.Promote(coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+			}
+			m := in.Read()
+			primary.Submit(&m)
+		}
+		if !in.Standalone() {
+			t.Errorf("%s: Expecting the primary to carry on standalone", name)
+		}
+		expectSameMsgs(t, refOut.ms, out.ms)
+		replica.Promote(coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+	}
+}
+
+// A reader from a primary which has gone quiet, Read blocks until it is closed
+type silentReader struct {
+	closed chan bool
+}
+
+func (r *silentReader) Read(p []byte) (int, error) {
+	<-r.closed
+	return 0, io.EOF
+}
+
+func (r *silentReader) Close() error {
+	close(r.closed)
+	return nil
+}
+
+// Promotion releases the frame reader, whether or not Run was started
+func TestPromoteStopsReader(t *testing.T) {
+	for _, run := range []bool{false, true} {
+		replica := NewReplica(matcher.NewMatcher(100), &silentReader{closed: make(chan bool)}, q.NewSimpleQ("toPrimary"))
+		if run {
+			go replica.Run()
+		}
+		done := make(chan bool)
+		go func() {
+			replica.Promote(coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Promote did not return, run %v", run)
+		}
+		// Run is a no-op once promoted, and promoting again returns the same matcher
+		replica.Run()
+		first := replica.m
+		if again := replica.Promote(coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter()); again != first {
+			t.Errorf("Expecting a second Promote to return the promoted matcher")
+		}
+	}
+}
+
+// Running a replica twice doesn't stop it twice
+func TestRunTwice(t *testing.T) {
+	replica := NewReplica(matcher.NewMatcher(100), &silentReader{closed: make(chan bool)}, q.NewSimpleQ("toPrimary"))
+	go replica.Run()
+	for {
+		replica.lock.Lock()
+		running := replica.running
+		replica.lock.Unlock()
+		if running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Returns immediately, rather than running alongside the first Run
+	replica.Run()
+	replica.Promote(coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+}
+
+func expectSameState(t *testing.T, expected, found *matcher.M) {
+	eb, fb := &bytes.Buffer{}, &bytes.Buffer{}
+	expected.Snapshot(eb)
+	found.Snapshot(fb)
+	if !bytes.Equal(eb.Bytes(), fb.Bytes()) {
+		t.Errorf("Matcher states differ")
+	}
+}
+
+func expectSameMsgs(t *testing.T, expected, found []msg.Message) {
+	if len(expected) != len(found) {
+		t.Errorf("Expecting %d messages, found %d", len(expected), len(found))
+		return
+	}
+	for i := range expected {
+		if expected[i] != found[i] {
+			t.Errorf("Expecting %v, found %v", &expected[i], &found[i])
+			return
+		}
+	}
+}
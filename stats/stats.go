@@ -0,0 +1,219 @@
+package stats
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/msg"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Trading activity for a single stock over some period
+type Summary struct {
+	StockId  uint64 `json:"stockId"`
+	Trades   uint64 `json:"trades"`
+	Last     uint64 `json:"last"`
+	High     uint64 `json:"high"`
+	Low      uint64 `json:"low"`
+	Volume   uint64 `json:"volume"`
+	Notional uint64 `json:"notional"`
+}
+
+// The volume weighted average price, 0 if nothing has traded
+func (s *Summary) VWAP() uint64 {
+	if s.Volume == 0 {
+		return 0
+	}
+	return s.Notional / s.Volume
+}
+
+func (s *Summary) add(price, amount uint64) {
+	if s.Trades == 0 || price > s.High {
+		s.High = price
+	}
+	if s.Trades == 0 || price < s.Low {
+		s.Low = price
+	}
+	s.Trades++
+	s.Last = price
+	s.Volume += amount
+	s.Notional += price * amount
+}
+
+// An OHLCV bar covering the logical times [Start, Start+interval)
+type Bar struct {
+	StockId uint64 `json:"stockId"`
+	Start   uint64 `json:"start"`
+	Open    uint64 `json:"open"`
+	High    uint64 `json:"high"`
+	Low     uint64 `json:"low"`
+	Close   uint64 `json:"close"`
+	Volume  uint64 `json:"volume"`
+}
+
+// Receives each completed bar, in the manner of a coordinator.MsgWriter
+type BarWriter interface {
+	Write(b Bar)
+}
+
+type stock struct {
+	day  Summary
+	open bool // Is there a bar in progress
+	bar  Bar
+	bars []Bar
+}
+
+// Summarises the trades published on a matcher's order feed.
+// The logical clock is the feed's sequence number, each bar covers interval
+// sequence numbers. Bars in which a stock did not trade are not produced.
+// Queries are safe to make while Run is consuming the feed, including from
+// the BarWriter.
+type Stats struct {
+	lock     sync.Mutex
+	interval uint64
+	barIdx   uint64
+	stocks   map[uint64]*stock
+	out      BarWriter
+	outLock  sync.Mutex // Keeps bars in order, without holding lock while they are written
+}
+
+// Creates a Stats producing bars every interval logical ticks.
+// Bars completed at the same time are written to out ordered by StockId, unless out is nil.
+func NewStats(interval uint64, out BarWriter) *Stats {
+	if interval == 0 {
+		interval = math.MaxUint64
+	}
+	return &Stats{interval: interval, stocks: make(map[uint64]*stock), out: out}
+}
+
+// Consumes order feed events until a SHUTDOWN is read, any bars in progress are then completed
+func (s *Stats) Run(in coordinator.MsgReader) {
+	for {
+		m := in.Read()
+		if m.Kind == msg.SHUTDOWN {
+			s.Flush()
+			return
+		}
+		s.Add(&m)
+	}
+}
+
+// Adds a single order feed event. Only BOOK_EXECUTE events are trades,
+// but every event advances the logical clock.
+func (s *Stats) Add(m *msg.Message) {
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+	s.write(s.add(m))
+}
+
+// Returns the bars completed by m
+func (s *Stats) add(m *msg.Message) []Bar {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	closed := s.tick(uint64(m.TraderId))
+	if m.Kind != msg.BOOK_EXECUTE {
+		return closed
+	}
+	st := s.getStock(m.StockId)
+	st.day.add(m.Price, m.Amount)
+	if !st.open {
+		st.open = true
+		st.bar = Bar{StockId: m.StockId, Start: s.barIdx * s.interval, Open: m.Price, High: m.Price, Low: m.Price}
+	}
+	b := &st.bar
+	if m.Price > b.High {
+		b.High = m.Price
+	}
+	if m.Price < b.Low {
+		b.Low = m.Price
+	}
+	b.Close = m.Price
+	b.Volume += m.Amount
+	return closed
+}
+
+// Completes every bar in progress
+func (s *Stats) Flush() {
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+	s.lock.Lock()
+	closed := s.closeBars()
+	s.lock.Unlock()
+	s.write(closed)
+}
+
+func (s *Stats) tick(now uint64) []Bar {
+	idx := now / s.interval
+	if idx == s.barIdx {
+		return nil
+	}
+	s.barIdx = idx
+	return s.closeBars()
+}
+
+// Completes every bar in progress, returning them ordered by StockId
+func (s *Stats) closeBars() []Bar {
+	var closed []Bar
+	for _, st := range s.stocks {
+		if st.open {
+			st.open = false
+			st.bars = append(st.bars, st.bar)
+			closed = append(closed, st.bar)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].StockId < closed[j].StockId })
+	return closed
+}
+
+func (s *Stats) write(bars []Bar) {
+	if s.out == nil {
+		return
+	}
+	for _, b := range bars {
+		s.out.Write(b)
+	}
+}
+
+func (s *Stats) getStock(stockId uint64) *stock {
+	st := s.stocks[stockId]
+	if st == nil {
+		st = &stock{day: Summary{StockId: stockId}}
+		s.stocks[stockId] = st
+	}
+	return st
+}
+
+// Returns everything stockId has traded so far, false if it has not traded
+func (s *Stats) Summary(stockId uint64) (Summary, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	st := s.stocks[stockId]
+	if st == nil {
+		return Summary{}, false
+	}
+	return st.day, true
+}
+
+// Returns a summary for every stock which has traded, ordering is not defined
+func (s *Stats) Summaries() []Summary {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	summaries := make([]Summary, 0, len(s.stocks))
+	for _, st := range s.stocks {
+		summaries = append(summaries, st.day)
+	}
+	return summaries
+}
+
+// Returns the completed bars for stockId in time order
+func (s *Stats) Bars(stockId uint64) []Bar {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	st := s.stocks[stockId]
+	if st == nil {
+		return nil
+	}
+	bars := make([]Bar, len(st.bars))
+	copy(bars, st.bars)
+	return bars
+}
@@ -0,0 +1,115 @@
+package stats
+
+import (
+	"github.com/fmstephe/matching_engine/coordinator"
+	"github.com/fmstephe/matching_engine/matcher"
+	"github.com/fmstephe/matching_engine/msg"
+	"reflect"
+	"testing"
+)
+
+func execute(seq uint32, stockId, price, amount uint64) *msg.Message {
+	m := &msg.Message{}
+	m.WriteBookEvent(msg.BOOK_EXECUTE, seq, 1, stockId, price, amount)
+	return m
+}
+
+func TestSummary(t *testing.T) {
+	s := NewStats(10, nil)
+	if _, ok := s.Summary(1); ok {
+		t.Errorf("Expected no summary before trading")
+	}
+	s.Add(execute(1, 1, 10, 2))
+	s.Add(execute(2, 1, 14, 1))
+	s.Add(execute(3, 1, 8, 1))
+	s.Add(execute(4, 2, 100, 5))
+	sum, ok := s.Summary(1)
+	expected := Summary{StockId: 1, Trades: 3, Last: 8, High: 14, Low: 8, Volume: 4, Notional: 42}
+	if !ok || sum != expected {
+		t.Errorf("Expecting %v, found %v", expected, sum)
+	}
+	if sum.VWAP() != 10 {
+		t.Errorf("Expecting VWAP of 10, found %d", sum.VWAP())
+	}
+	if len(s.Summaries()) != 2 {
+		t.Errorf("Expecting summaries for 2 stocks, found %v", s.Summaries())
+	}
+}
+
+// A BarWriter which queries the Stats writing to it, as a consumer may
+type barRecorder struct {
+	s    *Stats
+	bars []Bar
+}
+
+func (r *barRecorder) Write(b Bar) {
+	if _, ok := r.s.Summary(b.StockId); !ok {
+		panic("Bar written for a stock with no summary")
+	}
+	r.bars = append(r.bars, b)
+}
+
+func (r *barRecorder) expect(t *testing.T, expected ...Bar) {
+	if !reflect.DeepEqual(expected, r.bars) {
+		t.Errorf("Expecting %v, found %v", expected, r.bars)
+	}
+	r.bars = nil
+}
+
+func TestBars(t *testing.T) {
+	r := &barRecorder{}
+	s := NewStats(10, r)
+	r.s = s
+	s.Add(execute(1, 1, 10, 1))
+	s.Add(execute(5, 1, 12, 2))
+	s.Add(execute(9, 1, 9, 1))
+	// Non-trade events advance the clock
+	add := &msg.Message{}
+	add.WriteBookEvent(msg.BOOK_ADD_BUY, 12, 2, 1, 5, 1)
+	s.Add(add)
+	r.expect(t, Bar{StockId: 1, Start: 0, Open: 10, High: 12, Low: 9, Close: 9, Volume: 4})
+	// No trades between 10 and 30
+	s.Add(execute(31, 1, 11, 3))
+	s.Flush()
+	r.expect(t, Bar{StockId: 1, Start: 30, Open: 11, High: 11, Low: 11, Close: 11, Volume: 3})
+	if len(s.Bars(1)) != 2 {
+		t.Errorf("Expecting 2 bars, found %v", s.Bars(1))
+	}
+}
+
+// Bars completed together are always written in StockId order
+func TestBarsOrderedByStock(t *testing.T) {
+	r := &barRecorder{}
+	s := NewStats(10, r)
+	r.s = s
+	for _, stockId := range []uint64{5, 3, 9, 1, 7} {
+		s.Add(execute(1, stockId, 10, 1))
+	}
+	s.Flush()
+	var expected []Bar
+	for _, stockId := range []uint64{1, 3, 5, 7, 9} {
+		expected = append(expected, Bar{StockId: stockId, Open: 10, High: 10, Low: 10, Close: 10, Volume: 1})
+	}
+	r.expect(t, expected...)
+}
+
+func TestRunOverOrderFeed(t *testing.T) {
+	feed := coordinator.NewChanReaderWriter(100)
+	m := matcher.NewMatcher(100)
+	m.Config("Stats Matcher", coordinator.NewNoopReaderWriter(), coordinator.NewNoopReaderWriter())
+	m.SetOrderFeed(feed)
+	m.Submit(&msg.Message{Kind: msg.SELL, Price: 10, Amount: 2, TraderId: 1, TradeId: 1, StockId: 1})
+	m.Submit(&msg.Message{Kind: msg.BUY, Price: 10, Amount: 1, TraderId: 2, TradeId: 1, StockId: 1})
+	m.Submit(&msg.Message{Kind: msg.BUY, Price: 12, Amount: 1, TraderId: 2, TradeId: 2, StockId: 1})
+	feed.Write(msg.Message{Kind: msg.SHUTDOWN})
+	s := NewStats(100, nil)
+	s.Run(feed)
+	sum, _ := s.Summary(1)
+	expected := Summary{StockId: 1, Trades: 2, Last: 11, High: 11, Low: 10, Volume: 2, Notional: 21}
+	if sum != expected {
+		t.Errorf("Expecting %v, found %v", expected, sum)
+	}
+	if len(s.Bars(1)) != 1 {
+		t.Errorf("Expecting a single bar, found %v", s.Bars(1))
+	}
+}